@@ -0,0 +1,418 @@
+package mongoadapter
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx"
+)
+
+// Operations recorded in the history collection
+const (
+	AuditInsert  = "insert"
+	AuditUpdate  = "update"
+	AuditReplace = "replace"
+	AuditDelete  = "delete"
+)
+
+// AuditOptions configures EnableAudit()
+type AuditOptions struct {
+	// HistoryCollection is the collection of the same db receiving the
+	// history entries, defaults to "history". It can be shared by several
+	// collections, entries carry the name of the audited collection.
+	HistoryCollection string
+	// Diff stores only the changed top-level fields of updates and replaces
+	// instead of the before and after snapshots
+	Diff bool
+}
+
+// HistoryEntry is a single recorded change of a document
+type HistoryEntry struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	Coll       string             `bson:"coll"`
+	DocumentID interface{}        `bson:"documentId"`
+	Op         string             `bson:"op"`
+	Actor      string             `bson:"actor,omitempty"`
+	At         time.Time          `bson:"at"`
+	Before     bson.Raw           `bson:"before,omitempty"`
+	After      bson.Raw           `bson:"after,omitempty"`
+	Diff       *DocumentDiff      `bson:"diff,omitempty"`
+}
+
+// DocumentDiff holds the top-level fields set (with their new values) and
+// removed by a change
+type DocumentDiff struct {
+	Set   bson.Raw `bson:"set,omitempty"`
+	Unset []string `bson:"unset,omitempty"`
+}
+
+// EnableAudit records every insert, update, replace and delete made through
+// the adapter on the given collection into the history collection, together
+// with the actor found in the view's context (see WithActor()).
+// Updates, replaces and deletes read the affected documents before and after
+// the write, so they cost two extra queries and only the documents matched
+// by the first read are changed. It creates the index used by History().
+func (m *Mongo) EnableAudit(db, coll string, opts AuditOptions) error {
+	if opts.HistoryCollection == "" {
+		opts.HistoryCollection = "history"
+	}
+	if opts.HistoryCollection == coll {
+		return errors.New("a collection cannot keep its own history")
+	}
+	ctx, cancel := context.WithTimeout(m.context(), m.writeTimeout)
+	defer cancel()
+	_, err := m.conn.Database(db).Collection(opts.HistoryCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bsonx.Doc{
+			{Key: "coll", Value: bsonx.Int32(1)},
+			{Key: "documentId", Value: bsonx.Int32(1)},
+			{Key: "at", Value: bsonx.Int32(1)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	m.updateSettings(db, coll, func(s *collectionSettings) {
+		s.audit = &opts
+	})
+	return nil
+}
+
+// DisableAudit stops recording the changes of the given collection
+func (m *Mongo) DisableAudit(db, coll string) {
+	m.updateSettings(db, coll, func(s *collectionSettings) {
+		s.audit = nil
+	})
+}
+
+// skipAudit is used for the writes made by the audit itself
+func skipAudit() Option {
+	return func(m *Mongo) {
+		m.skipAudit = true
+	}
+}
+
+// audited returns the audit options if the writes of the view on the
+// collection must be recorded
+func (m *Mongo) audited(s collectionSettings) *AuditOptions {
+	if m.skipAudit {
+		return nil
+	}
+	return s.audit
+}
+
+// History returns the recorded changes of a document, oldest first
+func (m *Mongo) History(db, coll string, id interface{}) ([]HistoryEntry, error) {
+	var s = m.settings(db, coll)
+	if s.audit == nil {
+		return nil, errors.New("audit is not enabled for " + db + "." + coll)
+	}
+	ctx, cancel := context.WithTimeout(m.context(), m.readTimeout)
+	defer cancel()
+	var filter = bson.M{"coll": coll, "documentId": id}
+	var sort = options.Find().SetSort(bson.D{{Key: "at", Value: 1}, {Key: "_id", Value: 1}})
+	cur, err := m.conn.Database(db).Collection(s.audit.HistoryCollection).Find(ctx, filter, sort)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	var entries []HistoryEntry
+	for cur.Next(ctx) {
+		var entry HistoryEntry
+		if err := cur.Decode(&entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, cur.Err()
+}
+
+// DocumentAt reconstructs a document as it was at the given time from its
+// history. It returns mongo.ErrNoDocuments if the document did not exist then.
+// Documents that existed before auditing was enabled can only be
+// reconstructed from their first recorded change on.
+func (m *Mongo) DocumentAt(db, coll string, id interface{}, at time.Time) (bson.Raw, error) {
+	entries, err := m.History(db, coll, id)
+	if err != nil {
+		return nil, err
+	}
+	var state bson.Raw
+	for _, entry := range entries {
+		if entry.At.After(at) {
+			break
+		}
+		switch {
+		case entry.Op == AuditDelete && entry.After == nil:
+			state = nil
+		case entry.After != nil:
+			state = entry.After
+		case entry.Diff != nil && state != nil:
+			if state, err = entry.Diff.apply(state); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if state == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	return state, nil
+}
+
+// insertAudited runs insert on docs, recording them if the collection is
+// audited. In that case every document gets an _id beforehand, so that the
+// inserted documents can be recorded as they are.
+func (m *Mongo) insertAudited(db, coll string, s collectionSettings, docs []interface{}, insert func(docs []interface{}) error) error {
+	var audit = m.audited(s)
+	if audit == nil {
+		return insert(docs)
+	}
+	var prepared = make([]interface{}, len(docs))
+	var raws = make([]bson.Raw, len(docs))
+	for i, doc := range docs {
+		d, err := toDocument(doc)
+		if err != nil {
+			return err
+		}
+		if _, ok := lookup(d, "_id"); !ok {
+			d = append(bson.D{{Key: "_id", Value: primitive.NewObjectID()}}, d...)
+		}
+		if raws[i], err = bson.Marshal(d); err != nil {
+			return err
+		}
+		prepared[i] = raws[i]
+	}
+	var err = insert(prepared)
+	var inserted = raws
+	if _, ok := err.(mongo.BulkWriteException); ok {
+		// depending on ordered, the documents after a failed one may or may
+		// not have been inserted, keep the ones that are stored as sent
+		var serr error
+		if inserted, serr = m.storedAsSent(db, coll, raws); serr != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	var changes []auditChange
+	for _, raw := range inserted {
+		changes = append(changes, auditChange{id: raw.Lookup("_id"), after: raw})
+	}
+	if rerr := m.recordHistory(db, coll, audit, AuditInsert, changes); rerr != nil {
+		return rerr
+	}
+	return err
+}
+
+// storedAsSent returns the documents of docs that are stored unchanged
+func (m *Mongo) storedAsSent(db, coll string, docs []bson.Raw) ([]bson.Raw, error) {
+	var ids = make(bson.A, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.Lookup("_id")
+	}
+	stored, err := m.findRaw(db, coll, bson.M{"_id": bson.M{"$in": ids}}, options.Find())
+	if err != nil {
+		return nil, err
+	}
+	var storedByID = make(map[string]bson.Raw, len(stored))
+	for _, doc := range stored {
+		storedByID[doc.Lookup("_id").String()] = doc
+	}
+	var result []bson.Raw
+	for _, doc := range docs {
+		if bsonEqual(doc, storedByID[doc.Lookup("_id").String()]) {
+			result = append(result, doc)
+		}
+	}
+	return result, nil
+}
+
+// auditWrite records an update, replace or delete. It reads the documents
+// matching filter, runs the write on exactly those documents (by _id) and
+// reads them again. write receives a view that does not audit and the
+// restricted filter, it returns the upserted id if any.
+func (m *Mongo) auditWrite(db, coll string, audit *AuditOptions, op string, filter interface{}, many bool,
+	write func(v *Mongo, filter interface{}) (interface{}, error)) error {
+	var view = m.With(skipAudit())
+	var readFilter = filter
+	if op == AuditDelete {
+		readFilter = m.readFilter(db, coll, filter)
+	}
+	var findOptions = options.Find()
+	if !many {
+		findOptions.SetLimit(1)
+	}
+	before, err := m.findRaw(db, coll, readFilter, findOptions)
+	if err != nil {
+		return err
+	}
+	var ids = make(bson.A, 0, len(before))
+	for _, doc := range before {
+		ids = append(ids, doc.Lookup("_id"))
+	}
+
+	var upsertedID interface{}
+	if len(before) == 0 {
+		// nothing to record unless it is an upsert
+		if upsertedID, err = write(view, filter); err != nil || upsertedID == nil {
+			return err
+		}
+		ids = append(ids, upsertedID)
+	} else {
+		if _, err = write(view, andFilter(filter, bson.M{"_id": bson.M{"$in": ids}})); err != nil {
+			return err
+		}
+	}
+
+	after, err := m.findRaw(db, coll, bson.M{"_id": bson.M{"$in": ids}}, options.Find())
+	if err != nil {
+		return err
+	}
+	var afterByID = make(map[string]bson.Raw, len(after))
+	for _, doc := range after {
+		afterByID[doc.Lookup("_id").String()] = doc
+	}
+
+	var changes []auditChange
+	for _, doc := range before {
+		var id = doc.Lookup("_id")
+		var change = auditChange{id: id, before: doc, after: afterByID[id.String()]}
+		if op != AuditDelete && change.after == nil {
+			continue
+		}
+		if op != AuditDelete && bsonEqual(change.before, change.after) {
+			// matched but not modified
+			continue
+		}
+		changes = append(changes, change)
+	}
+	if upsertedID != nil {
+		for _, doc := range after {
+			changes = append(changes, auditChange{id: doc.Lookup("_id"), after: doc})
+		}
+		op = AuditInsert
+	}
+	return m.recordHistory(db, coll, audit, op, changes)
+}
+
+type auditChange struct {
+	id     bson.RawValue
+	before bson.Raw
+	after  bson.Raw
+}
+
+func (m *Mongo) findRaw(db, coll string, filter interface{}, opts *options.FindOptions) ([]bson.Raw, error) {
+	ctx, cancel := context.WithTimeout(m.context(), m.readTimeout)
+	defer cancel()
+	cur, err := m.collection(db, coll).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	var docs []bson.Raw
+	for cur.Next(ctx) {
+		docs = append(docs, append(bson.Raw(nil), cur.Current...))
+	}
+	return docs, cur.Err()
+}
+
+func (m *Mongo) recordHistory(db, coll string, audit *AuditOptions, op string, changes []auditChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	var now = time.Now().UTC()
+	var actor = ActorFromContext(m.context())
+	var entries = make([]interface{}, 0, len(changes))
+	for _, change := range changes {
+		var entry = HistoryEntry{
+			Coll:       coll,
+			DocumentID: change.id,
+			Op:         op,
+			Actor:      actor,
+			At:         now,
+			Before:     change.before,
+			After:      change.after,
+		}
+		if audit.Diff && change.before != nil && change.after != nil {
+			diff, err := diffDocuments(change.before, change.after)
+			if err != nil {
+				return err
+			}
+			entry.Before, entry.After, entry.Diff = nil, nil, diff
+		}
+		entries = append(entries, entry)
+	}
+	ctx, cancel := context.WithTimeout(m.context(), m.writeTimeout)
+	defer cancel()
+	_, err := m.conn.Database(db).Collection(audit.HistoryCollection).InsertMany(ctx, entries)
+	if err != nil {
+		return errors.New("the write succeeded but recording its history failed, got error: " + err.Error())
+	}
+	return nil
+}
+
+// diffDocuments returns the top-level fields of after that are new or
+// changed compared to before, and the fields removed from before
+func diffDocuments(before, after bson.Raw) (*DocumentDiff, error) {
+	beforeElements, err := before.Elements()
+	if err != nil {
+		return nil, err
+	}
+	afterElements, err := after.Elements()
+	if err != nil {
+		return nil, err
+	}
+	var diff = &DocumentDiff{}
+	var changed bson.D
+	for _, e := range afterElements {
+		if old, err := before.LookupErr(e.Key()); err != nil || !old.Equal(e.Value()) {
+			changed = append(changed, bson.E{Key: e.Key(), Value: e.Value()})
+		}
+	}
+	for _, e := range beforeElements {
+		if _, err := after.LookupErr(e.Key()); err != nil {
+			diff.Unset = append(diff.Unset, e.Key())
+		}
+	}
+	if len(changed) > 0 {
+		if diff.Set, err = bson.Marshal(changed); err != nil {
+			return nil, err
+		}
+	}
+	return diff, nil
+}
+
+// apply returns doc with the diff applied
+func (d *DocumentDiff) apply(doc bson.Raw) (bson.Raw, error) {
+	elements, err := doc.Elements()
+	if err != nil {
+		return nil, err
+	}
+	var unset = make(map[string]bool, len(d.Unset))
+	for _, v := range d.Unset {
+		unset[v] = true
+	}
+	var result bson.D
+	for _, e := range elements {
+		if !unset[e.Key()] {
+			result = append(result, bson.E{Key: e.Key(), Value: e.Value()})
+		}
+	}
+	if d.Set != nil {
+		setElements, err := d.Set.Elements()
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range setElements {
+			result = set(result, e.Key(), e.Value())
+		}
+	}
+	return bson.Marshal(result)
+}
+
+func bsonEqual(a, b bson.Raw) bool {
+	return string(a) == string(b)
+}
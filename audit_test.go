@@ -0,0 +1,93 @@
+package mongoadapter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMongo_EnableAudit(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	var coll = "audited"
+	_ = m.conn.Database(mongoDatabase).Collection(coll).Drop(context.Background())
+	_ = m.conn.Database(mongoDatabase).Collection("auditedHistory").Drop(context.Background())
+	assert.Nil(t, m.EnableAudit(mongoDatabase, coll, AuditOptions{HistoryCollection: "auditedHistory"}))
+	defer m.DisableAudit(mongoDatabase, coll)
+	view := m.With(Context(WithActor(context.Background(), "auditor")))
+
+	res, err := view.InsertOne(mongoDatabase, coll, bson.M{"name": "sara", "city": "rome"})
+	assert.Nil(t, err)
+	var id = res.InsertedID
+	time.Sleep(5 * time.Millisecond)
+	var afterInsert = time.Now()
+	time.Sleep(5 * time.Millisecond)
+
+	_, err = view.UpdateOne(mongoDatabase, coll, bson.M{"name": "sara"}, bson.M{"$set": bson.M{"city": "milan"}})
+	assert.Nil(t, err)
+	_, err = view.DeleteOne(mongoDatabase, coll, bson.M{"name": "sara"})
+	assert.Nil(t, err)
+
+	entries, err := m.History(mongoDatabase, coll, id)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, AuditInsert, entries[0].Op)
+	assert.Equal(t, AuditUpdate, entries[1].Op)
+	assert.Equal(t, "rome", entries[1].Before.Lookup("city").StringValue())
+	assert.Equal(t, "milan", entries[1].After.Lookup("city").StringValue())
+	assert.Equal(t, AuditDelete, entries[2].Op)
+	assert.Equal(t, "auditor", entries[2].Actor)
+
+	doc, err := m.DocumentAt(mongoDatabase, coll, id, afterInsert)
+	assert.Nil(t, err)
+	assert.Equal(t, "rome", doc.Lookup("city").StringValue())
+	_, err = m.DocumentAt(mongoDatabase, coll, id, time.Now())
+	assert.True(t, m.NoDocument(err))
+}
+
+func TestMongo_EnableAudit_diff(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	var coll = "auditedWithDiff"
+	_ = m.conn.Database(mongoDatabase).Collection(coll).Drop(context.Background())
+	assert.Nil(t, m.EnableAudit(mongoDatabase, coll, AuditOptions{Diff: true}))
+	defer m.DisableAudit(mongoDatabase, coll)
+
+	res, err := m.InsertMany(mongoDatabase, coll, []interface{}{
+		bson.M{"name": "robert", "city": "rome", "age": 30},
+		bson.M{"name": "john", "city": "rome", "age": 40},
+	})
+	assert.Nil(t, err)
+	_, err = m.UpdateMany(mongoDatabase, coll, bson.M{"city": "rome"}, bson.M{"$set": bson.M{"city": "paris"}, "$unset": bson.M{"age": ""}})
+	assert.Nil(t, err)
+
+	entries, err := m.History(mongoDatabase, coll, res.InsertedIDs[0])
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2)
+	assert.Nil(t, entries[1].After)
+	assert.Equal(t, "paris", entries[1].Diff.Set.Lookup("city").StringValue())
+	assert.Equal(t, []string{"age"}, entries[1].Diff.Unset)
+
+	doc, err := m.DocumentAt(mongoDatabase, coll, res.InsertedIDs[0], time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, "paris", doc.Lookup("city").StringValue())
+	_, err = doc.LookupErr("age")
+	assert.Error(t, err)
+}
+
+func TestDiffDocuments(t *testing.T) {
+	before, _ := bson.Marshal(bson.D{{Key: "a", Value: 1}, {Key: "b", Value: "x"}, {Key: "c", Value: true}})
+	after, _ := bson.Marshal(bson.D{{Key: "a", Value: 1}, {Key: "b", Value: "y"}, {Key: "d", Value: 2}})
+	diff, err := diffDocuments(before, after)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"c"}, diff.Unset)
+	_, err = diff.Set.LookupErr("a")
+	assert.Error(t, err)
+
+	applied, err := diff.apply(before)
+	assert.Nil(t, err)
+	var result bson.M
+	assert.Nil(t, bson.Unmarshal(applied, &result))
+	assert.Equal(t, bson.M{"a": int32(1), "b": "y", "d": int32(2)}, result)
+}
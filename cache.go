@@ -0,0 +1,316 @@
+package mongoadapter
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/sync/singleflight"
+)
+
+// Cache stores the results of cached queries, see UseCache()
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte, ttl time.Duration)
+}
+
+// queryCache is the caching state shared by an instance and its views
+type queryCache struct {
+	sync.Mutex
+	backend     Cache
+	generations map[string]uint64
+	group       singleflight.Group
+}
+
+func newQueryCache() *queryCache {
+	return &queryCache{generations: make(map[string]uint64)}
+}
+
+// UseCache makes the cached queries of the collections enabled with
+// EnableCache() go through c, e.g. m.UseCache(NewLRUCache(10000))
+func (m *Mongo) UseCache(c Cache) {
+	m.registry.cache.Lock()
+	defer m.registry.cache.Unlock()
+	m.registry.cache.backend = c
+}
+
+// EnableCache caches the results of FindOneCached(), FindManyCached() and
+// SearchCached() on the given collection for ttl. Writes made through the
+// adapter invalidate the cached results of the collection in this process,
+// the writes of other processes are only seen once ttl expired.
+func (m *Mongo) EnableCache(db, coll string, ttl time.Duration) {
+	m.updateSettings(db, coll, func(s *collectionSettings) {
+		s.cacheTTL = ttl
+	})
+}
+
+// DisableCache stops caching the queries of the given collection
+func (m *Mongo) DisableCache(db, coll string) {
+	m.updateSettings(db, coll, func(s *collectionSettings) {
+		s.cacheTTL = 0
+	})
+	m.invalidateCache(db, coll)
+}
+
+// FindOneCached decodes the first document matching filter into result,
+// from the cache if the collection is cached. It returns
+// mongo.ErrNoDocuments if there is none, which is cached as well.
+func (m *Mongo) FindOneCached(db, coll string, filter interface{}, result interface{}, opts ...*options.FindOneOptions) error {
+	var findOne = options.MergeFindOneOptions(opts...)
+	var find = options.Find().SetLimit(1)
+	find.AllowPartialResults, find.Collation, find.Comment = findOne.AllowPartialResults, findOne.Collation, findOne.Comment
+	find.Hint, find.Max, find.Min, find.MaxTime = findOne.Hint, findOne.Max, findOne.Min, findOne.MaxTime
+	find.Projection, find.ReturnKey, find.ShowRecordID = findOne.Projection, findOne.ReturnKey, findOne.ShowRecordID
+	find.Skip, find.Sort = findOne.Skip, findOne.Sort
+	docs, err := m.cachedQuery(db, coll, "findOne", findCacheKey(filter, find), func(ctx context.Context) (*mongo.Cursor, error) {
+		return m.collection(db, coll).Find(ctx, m.readFilter(db, coll, filter), find)
+	})
+	if err != nil {
+		return err
+	}
+	values, err := docs.Values()
+	if err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return values[0].Unmarshal(result)
+}
+
+// FindManyCached decodes the documents matching filter into results, which
+// must be a pointer to a slice, from the cache if the collection is cached
+func (m *Mongo) FindManyCached(db, coll string, filter interface{}, results interface{}, opts ...*options.FindOptions) error {
+	var findOptions = options.MergeFindOptions(opts...)
+	docs, err := m.cachedQuery(db, coll, "find", findCacheKey(filter, findOptions), func(ctx context.Context) (*mongo.Cursor, error) {
+		return m.collection(db, coll).Find(ctx, m.readFilter(db, coll, filter), findOptions)
+	})
+	if err != nil {
+		return err
+	}
+	return bson.RawValue{Type: bson.TypeArray, Value: docs}.Unmarshal(results)
+}
+
+// findCacheKey is the cache key of a find, it holds every option that
+// changes the results. The others, such as the batch size or the maximum
+// time, only change how they are fetched.
+func findCacheKey(filter interface{}, o *options.FindOptions) bson.D {
+	return bson.D{
+		{Key: "filter", Value: normalizeKey(filter)},
+		{Key: "projection", Value: normalizeKey(o.Projection)},
+		{Key: "sort", Value: normalizeKey(o.Sort)},
+		{Key: "skip", Value: o.Skip},
+		{Key: "limit", Value: o.Limit},
+		{Key: "collation", Value: o.Collation},
+		{Key: "hint", Value: normalizeKey(o.Hint)},
+		{Key: "min", Value: normalizeKey(o.Min)},
+		{Key: "max", Value: normalizeKey(o.Max)},
+		{Key: "returnKey", Value: o.ReturnKey},
+		{Key: "showRecordId", Value: o.ShowRecordID},
+		{Key: "allowPartialResults", Value: o.AllowPartialResults},
+	}
+}
+
+// SearchCached is Search() decoding the documents into results, which
+// must be a pointer to a slice, from the cache if the collection is cached
+func (m *Mongo) SearchCached(db, coll string, filters map[string][]string, sorting map[string]int, limit, skip int64, results interface{}) error {
+	var key = bson.D{
+		{Key: "filters", Value: normalizeKey(filters)},
+		{Key: "sort", Value: normalizeKey(sorting)},
+		{Key: "skip", Value: skip},
+		{Key: "limit", Value: limit},
+	}
+	docs, err := m.cachedQuery(db, coll, "search", key, func(ctx context.Context) (*mongo.Cursor, error) {
+		return m.Search(db, coll, filters, sorting, limit, skip)
+	})
+	if err != nil {
+		return err
+	}
+	return bson.RawValue{Type: bson.TypeArray, Value: docs}.Unmarshal(results)
+}
+
+// cachedQuery returns the documents of the query run by fetch as a BSON
+// array, from the cache if possible. Concurrent identical queries share a
+// single round-trip.
+func (m *Mongo) cachedQuery(db, coll, op string, key bson.D, fetch func(ctx context.Context) (*mongo.Cursor, error)) (bson.Raw, error) {
+	var qc = m.registry.cache
+	var ttl = m.settings(db, coll).cacheTTL
+	qc.Lock()
+	var backend = qc.backend
+	var generation = qc.generations[db+"."+coll]
+	qc.Unlock()
+
+	var load = func() (bson.Raw, error) {
+		ctx, cancel := context.WithTimeout(m.context(), m.readTimeout)
+		defer cancel()
+		cur, err := fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer cur.Close(ctx)
+		var docs = bson.A{}
+		for cur.Next(ctx) {
+			docs = append(docs, bson.Raw(append([]byte(nil), cur.Current...)))
+		}
+		if err := cur.Err(); err != nil {
+			return nil, err
+		}
+		// documents are kept in an array, which can't be marshaled on its own
+		data, err := bson.Marshal(bson.D{{Key: "d", Value: docs}})
+		if err != nil {
+			return nil, err
+		}
+		return bson.Raw(data).Lookup("d").Value, nil
+	}
+	if backend == nil || ttl <= 0 {
+		return load()
+	}
+
+	// the view's visibility of soft-deleted documents changes the results
+	key = append(key, bson.E{Key: "includeDeleted", Value: m.includeDeleted})
+	keyJSON, err := bson.MarshalExtJSON(key, true, false)
+	if err != nil {
+		return nil, errors.New("failed to build the cache key, got error: " + err.Error())
+	}
+	var cacheKey = db + "." + coll + "#" + strconv.FormatUint(generation, 10) + ":" + op + ":" + string(keyJSON)
+	if data, ok := backend.Get(cacheKey); ok {
+		return data, nil
+	}
+	v, err, _ := qc.group.Do(cacheKey, func() (interface{}, error) {
+		data, err := load()
+		if err == nil {
+			backend.Set(cacheKey, data, ttl)
+		}
+		return data, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(bson.Raw), nil
+}
+
+// invalidateCache drops the cached results of the collection by moving it
+// to a new generation, the entries of the old one are left to expire
+func (m *Mongo) invalidateCache(db, coll string) {
+	if m.registry == nil {
+		return
+	}
+	var qc = m.registry.cache
+	qc.Lock()
+	defer qc.Unlock()
+	if qc.backend != nil {
+		qc.generations[db+"."+coll]++
+	}
+}
+
+// normalizeKey sorts the keys of maps, whose iteration order is random,
+// so that equal filters give equal cache keys. The order of bson.D is kept.
+func normalizeKey(v interface{}) interface{} {
+	switch value := v.(type) {
+	case bson.M:
+		return sortedDocument(value)
+	case map[string]interface{}:
+		return sortedDocument(value)
+	case map[string][]string:
+		var doc = make(map[string]interface{}, len(value))
+		for k, e := range value {
+			doc[k] = e
+		}
+		return sortedDocument(doc)
+	case map[string]int:
+		var doc = make(map[string]interface{}, len(value))
+		for k, e := range value {
+			doc[k] = e
+		}
+		return sortedDocument(doc)
+	case bson.D:
+		var doc = make(bson.D, len(value))
+		for i, e := range value {
+			doc[i] = bson.E{Key: e.Key, Value: normalizeKey(e.Value)}
+		}
+		return doc
+	case bson.A:
+		var array = make(bson.A, len(value))
+		for i, e := range value {
+			array[i] = normalizeKey(e)
+		}
+		return array
+	case []interface{}:
+		return normalizeKey(bson.A(value))
+	}
+	return v
+}
+
+func sortedDocument(m map[string]interface{}) bson.D {
+	var keys = make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var doc = make(bson.D, len(keys))
+	for i, k := range keys {
+		doc[i] = bson.E{Key: k, Value: normalizeKey(m[k])}
+	}
+	return doc
+}
+
+// lruCache is the in-process Cache returned by NewLRUCache()
+type lruCache struct {
+	sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List
+}
+
+type lruEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+// NewLRUCache returns an in-process Cache keeping at most maxEntries
+// results, the least recently used are evicted first
+func NewLRUCache(maxEntries int) Cache {
+	return &lruCache{maxEntries: maxEntries, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+func (c *lruCache) Get(key string) ([]byte, bool) {
+	c.Lock()
+	defer c.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	var entry = element.Value.(*lruEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return entry.value, true
+}
+
+func (c *lruCache) Set(key string, value []byte, ttl time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	var entry = &lruEntry{key: key, value: value, expiresAt: time.Now().Add(ttl)}
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		var oldest = c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+}
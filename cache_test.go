@@ -0,0 +1,108 @@
+package mongoadapter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestMongo_FindOneCached(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	var coll = "cached"
+	_ = m.conn.Database(mongoDatabase).Collection(coll).Drop(context.Background())
+	m.UseCache(NewLRUCache(100))
+	defer m.UseCache(nil)
+	m.EnableCache(mongoDatabase, coll, time.Minute)
+	defer m.DisableCache(mongoDatabase, coll)
+
+	_, err := m.InsertOne(mongoDatabase, coll, bson.M{"name": "sara", "city": "rome"})
+	assert.Nil(t, err)
+	var user bson.M
+	assert.Nil(t, m.FindOneCached(mongoDatabase, coll, bson.M{"name": "sara"}, &user))
+	assert.Equal(t, "rome", user["city"])
+
+	// a change made behind the adapter's back is not seen
+	_, err = m.conn.Database(mongoDatabase).Collection(coll).UpdateOne(context.Background(),
+		bson.M{"name": "sara"}, bson.M{"$set": bson.M{"city": "paris"}})
+	assert.Nil(t, err)
+	assert.Nil(t, m.FindOneCached(mongoDatabase, coll, bson.M{"name": "sara"}, &user))
+	assert.Equal(t, "rome", user["city"])
+
+	// writes through the adapter invalidate the collection
+	_, err = m.UpdateOne(mongoDatabase, coll, bson.M{"name": "sara"}, bson.M{"$set": bson.M{"city": "milan"}})
+	assert.Nil(t, err)
+	assert.Nil(t, m.FindOneCached(mongoDatabase, coll, bson.M{"name": "sara"}, &user))
+	assert.Equal(t, "milan", user["city"])
+
+	assert.True(t, m.NoDocument(m.FindOneCached(mongoDatabase, coll, bson.M{"name": "nobody"}, &user)))
+}
+
+func TestMongo_FindManyCached(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	var coll = "cachedMany"
+	_ = m.conn.Database(mongoDatabase).Collection(coll).Drop(context.Background())
+	m.UseCache(NewLRUCache(100))
+	defer m.UseCache(nil)
+	m.EnableCache(mongoDatabase, coll, time.Minute)
+	defer m.DisableCache(mongoDatabase, coll)
+	_, err := insertDummyUser(mongoDatabase, coll, 5)
+	assert.Nil(t, err)
+
+	var users []DummyUser
+	assert.Nil(t, m.FindManyCached(mongoDatabase, coll, bson.M{}, &users, options.Find().SetLimit(3)))
+	assert.Len(t, users, 3)
+	var all []DummyUser
+	assert.Nil(t, m.SearchCached(mongoDatabase, coll, nil, nil, 0, 0, &all))
+	assert.Len(t, all, 5)
+
+	_, err = m.DeleteMany(mongoDatabase, coll, bson.M{})
+	assert.Nil(t, err)
+	all = nil
+	assert.Nil(t, m.SearchCached(mongoDatabase, coll, nil, nil, 0, 0, &all))
+	assert.Len(t, all, 0)
+}
+
+func TestLRUCache(t *testing.T) {
+	cache := NewLRUCache(2)
+	cache.Set("a", []byte("1"), time.Minute)
+	cache.Set("b", []byte("2"), time.Minute)
+	_, _ = cache.Get("a")
+	cache.Set("c", []byte("3"), time.Minute)
+	_, ok := cache.Get("b")
+	assert.False(t, ok, "the least recently used entry must be evicted")
+	value, ok := cache.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "1", string(value))
+
+	cache.Set("d", []byte("4"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	_, ok = cache.Get("d")
+	assert.False(t, ok)
+}
+
+func TestNormalizeKey(t *testing.T) {
+	a, _ := bson.MarshalExtJSON(bson.D{{Key: "f", Value: normalizeKey(bson.M{"b": 1, "a": bson.M{"y": 1, "x": 2}})}}, true, false)
+	b, _ := bson.MarshalExtJSON(bson.D{{Key: "f", Value: normalizeKey(bson.M{"a": bson.M{"x": 2, "y": 1}, "b": 1})}}, true, false)
+	assert.Equal(t, string(a), string(b))
+
+	var sort = bson.D{{Key: "z", Value: 1}, {Key: "a", Value: -1}}
+	assert.Equal(t, sort, normalizeKey(sort))
+}
+
+func TestFindCacheKey(t *testing.T) {
+	var key = func(o *options.FindOptions) string {
+		data, err := bson.MarshalExtJSON(findCacheKey(bson.M{"name": "sara"}, o), true, false)
+		assert.Nil(t, err)
+		return string(data)
+	}
+	var plain = key(options.Find())
+	var caseInsensitive = key(options.Find().SetCollation(&options.Collation{Locale: "en", Strength: 2}))
+	assert.NotEqual(t, plain, caseInsensitive, "collations must not share results")
+	assert.NotEqual(t, plain, key(options.Find().SetHint("name_1")))
+	assert.NotEqual(t, plain, key(options.Find().SetMin(bson.D{{Key: "name", Value: "a"}})))
+	assert.Equal(t, plain, key(options.Find().SetBatchSize(10)), "the batch size doesn't change the results")
+}
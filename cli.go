@@ -0,0 +1,500 @@
+package mongoadapter
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Output formats of RunCommand
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+)
+
+// RunCommand runs the ad-hoc subcommands of the mongoadapter CLI on args:
+//
+//	find [-filter <json>] [-projection <json>] [-sort <json>] [-limit n] [-skip n] <db> <coll>
+//	search [-filters <json>] [-sort <json>] [-limit n] [-skip n] <db> <coll>
+//	count [-filter <json>] <db> <coll>
+//	insert [-file path] [-format json|ndjson] <db> <coll>
+//	update -filter <json> -update <json> [-many] [-upsert] <db> <coll>
+//	delete -filter <json> [-many] [-yes] <db> <coll>
+//	index list|create|drop|sync ... <db> <coll>
+//	stats <db> [coll]
+//	ping
+//	export|import ..., see RunTransferCommand()
+//
+// The -filters of search use the syntax of Search(), e.g.
+// {"country": ["italy", "eq"], "name": ["^sa", "like"]}. Results are
+// written to out as a table, or as Extended JSON with -output json.
+// Deletes are confirmed by answering y on in, unless -yes is given.
+func RunCommand(ctx context.Context, m *Mongo, args []string, in io.Reader, out, errOut io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("no command specified, expected one of find, search, count, insert, update, delete, index, stats, ping, export or import")
+	}
+	var view = m.With(Context(ctx))
+	var flags = flag.NewFlagSet(args[0], flag.ContinueOnError)
+	flags.SetOutput(errOut)
+	var output = flags.String("output", OutputTable, "table or json")
+
+	switch args[0] {
+	case "find":
+		var filter = flags.String("filter", "", "Extended JSON filter")
+		var projection = flags.String("projection", "", "Extended JSON projection")
+		var sorting = flags.String("sort", "", "Extended JSON sort")
+		var limit = flags.Int64("limit", 20, "maximum number of documents, 0 for all")
+		var skip = flags.Int64("skip", 0, "number of documents to skip")
+		db, coll, err := parseTransferArgs(flags, args[1:])
+		if err != nil {
+			return err
+		}
+		var opts = options.Find().SetLimit(*limit).SetSkip(*skip)
+		if opts.Projection, err = parseJSONArg("projection", *projection); err != nil {
+			return err
+		}
+		if opts.Sort, err = parseJSONArg("sort", *sorting); err != nil {
+			return err
+		}
+		query, err := parseFilterArg(*filter)
+		if err != nil {
+			return err
+		}
+		cur, err := view.FindMany(db, coll, query, opts)
+		if err != nil {
+			return err
+		}
+		return writeCursor(ctx, cur, *output, out)
+	case "search":
+		var filters = flags.String("filters", "", `Search() filters, e.g. {"country": ["italy", "eq"]}`)
+		var sorting = flags.String("sort", "", `sort, e.g. {"name": 1}`)
+		var limit = flags.Int64("limit", 20, "maximum number of documents")
+		var skip = flags.Int64("skip", 0, "number of documents to skip")
+		db, coll, err := parseTransferArgs(flags, args[1:])
+		if err != nil {
+			return err
+		}
+		searchFilters, err := parseSearchFilters(*filters)
+		if err != nil {
+			return err
+		}
+		var sortRule map[string]int
+		if *sorting != "" {
+			if err := json.Unmarshal([]byte(*sorting), &sortRule); err != nil {
+				return errors.New("invalid sort, got error: " + err.Error())
+			}
+		}
+		cur, err := view.Search(db, coll, searchFilters, sortRule, *limit, *skip)
+		if err != nil {
+			return err
+		}
+		return writeCursor(ctx, cur, *output, out)
+	case "count":
+		var filter = flags.String("filter", "", "Extended JSON filter")
+		db, coll, err := parseTransferArgs(flags, args[1:])
+		if err != nil {
+			return err
+		}
+		query, err := parseFilterArg(*filter)
+		if err != nil {
+			return err
+		}
+		n, err := view.Count(db, coll, query)
+		if err != nil {
+			return err
+		}
+		return writeResult(out, *output, bson.D{{Key: "count", Value: n}})
+	case "insert":
+		var file = flags.String("file", "-", "JSON or NDJSON file, - for stdin")
+		var format = flags.String("format", FormatJSON, "json or ndjson")
+		db, coll, err := parseTransferArgs(flags, args[1:])
+		if err != nil {
+			return err
+		}
+		var r = in
+		if *file != "-" {
+			f, err := os.Open(*file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		n, err := view.Import(db, coll, r, ImportOptions{Format: *format})
+		if err != nil {
+			return err
+		}
+		return writeResult(out, *output, bson.D{{Key: "inserted", Value: n}})
+	case "update":
+		var filter = flags.String("filter", "", "Extended JSON filter (required)")
+		var update = flags.String("update", "", "Extended JSON update (required)")
+		var many = flags.Bool("many", false, "update all the matching documents")
+		var upsert = flags.Bool("upsert", false, "insert a document if none matches")
+		db, coll, err := parseTransferArgs(flags, args[1:])
+		if err != nil {
+			return err
+		}
+		if *filter == "" || *update == "" {
+			return errors.New("update expects -filter and -update")
+		}
+		query, err := parseFilterArg(*filter)
+		if err != nil {
+			return err
+		}
+		changes, err := parseJSONArg("update", *update)
+		if err != nil {
+			return err
+		}
+		var res *mongo.UpdateResult
+		if *many {
+			res, err = view.UpdateMany(db, coll, query, changes, options.Update().SetUpsert(*upsert))
+		} else {
+			res, err = view.UpdateOne(db, coll, query, changes, options.Update().SetUpsert(*upsert))
+		}
+		if err != nil {
+			return err
+		}
+		return writeResult(out, *output, bson.D{
+			{Key: "matched", Value: res.MatchedCount},
+			{Key: "modified", Value: res.ModifiedCount},
+			{Key: "upserted", Value: res.UpsertedCount},
+		})
+	case "delete":
+		var filter = flags.String("filter", "", "Extended JSON filter (required, {} matches everything)")
+		var many = flags.Bool("many", false, "delete all the matching documents")
+		var yes = flags.Bool("yes", false, "do not ask for confirmation")
+		db, coll, err := parseTransferArgs(flags, args[1:])
+		if err != nil {
+			return err
+		}
+		if *filter == "" {
+			return errors.New("delete expects -filter")
+		}
+		query, err := parseFilterArg(*filter)
+		if err != nil {
+			return err
+		}
+		if !*yes {
+			var countOptions = options.Count()
+			if !*many {
+				countOptions.SetLimit(1)
+			}
+			n, err := view.Count(db, coll, query, countOptions)
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				return writeResult(out, *output, bson.D{{Key: "deleted", Value: int64(0)}})
+			}
+			_, _ = fmt.Fprintf(errOut, "delete %v document(s) from %v.%v? [y/N] ", n, db, coll)
+			answer, _ := bufio.NewReader(in).ReadString('\n')
+			if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+				return errors.New("delete cancelled")
+			}
+		}
+		var res *mongo.DeleteResult
+		if *many {
+			res, err = view.DeleteMany(db, coll, query)
+		} else {
+			res, err = view.DeleteOne(db, coll, query)
+		}
+		if err != nil {
+			return err
+		}
+		return writeResult(out, *output, bson.D{{Key: "deleted", Value: res.DeletedCount}})
+	case "index":
+		return runIndexCommand(view, flags, *output, args[1:], out)
+	case "stats":
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		var command bson.D
+		switch flags.NArg() {
+		case 1:
+			command = bson.D{{Key: "dbStats", Value: 1}}
+		case 2:
+			command = bson.D{{Key: "collStats", Value: flags.Arg(1)}}
+		default:
+			return errors.New("stats expects a database and an optional collection")
+		}
+		runCtx, cancel := context.WithTimeout(ctx, view.readTimeout)
+		defer cancel()
+		stats, err := view.conn.Database(flags.Arg(0)).RunCommand(runCtx, command).DecodeBytes()
+		if err != nil {
+			return err
+		}
+		return writeStats(out, *output, stats)
+	case "ping":
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		status, err := view.Health(ctx)
+		if err != nil {
+			return err
+		}
+		if *output == OutputJSON {
+			return json.NewEncoder(out).Encode(status)
+		}
+		_, err = fmt.Fprintf(out, "%v in %v\n", status.State, status.Latency)
+		if err == nil && status.LastError != "" {
+			_, err = fmt.Fprintln(out, status.LastError)
+		}
+		return err
+	case "export", "import":
+		return RunTransferCommand(ctx, m, args, in, out, errOut)
+	}
+	return errors.New("unknown command " + strconv.Quote(args[0]))
+}
+
+// runIndexCommand runs the index list, create, drop and sync subcommands
+func runIndexCommand(m *Mongo, flags *flag.FlagSet, output string, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("no index command specified, expected list, create, drop or sync")
+	}
+	switch args[0] {
+	case "list":
+		db, coll, err := parseTransferArgs(flags, args[1:])
+		if err != nil {
+			return err
+		}
+		// listed raw, the order of the index keys matters
+		ctx, cancel := context.WithTimeout(m.context(), m.readTimeout)
+		defer cancel()
+		cur, err := m.collection(db, coll).Indexes().List(ctx)
+		if err != nil {
+			return err
+		}
+		return writeCursor(ctx, cur, output, out)
+	case "create":
+		var keys = flags.String("keys", "", `Extended JSON keys (required), e.g. {"email": 1}`)
+		var name = flags.String("name", "", "index name, defaults to the server's naming")
+		var unique = flags.Bool("unique", false, "unique index")
+		var sparse = flags.Bool("sparse", false, "sparse index")
+		var ttl = flags.Int("ttl", -1, "expire documents after the given seconds")
+		db, coll, err := parseTransferArgs(flags, args[1:])
+		if err != nil {
+			return err
+		}
+		var spec = IndexSpec{Name: *name, Unique: *unique, Sparse: *sparse}
+		if *keys == "" {
+			return errors.New("index create expects -keys")
+		}
+		if err := bson.UnmarshalExtJSON([]byte(*keys), false, &spec.Key); err != nil {
+			return errors.New("invalid keys, got error: " + err.Error())
+		}
+		if *ttl >= 0 {
+			var seconds = int32(*ttl)
+			spec.ExpireAfterSeconds = &seconds
+		}
+		if err := m.createIndexSpecs(db, coll, spec); err != nil {
+			return err
+		}
+		return writeResult(out, output, bson.D{{Key: "created", Value: spec.name()}})
+	case "drop":
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if flags.NArg() != 3 {
+			return errors.New("index drop expects a database, a collection and an index name")
+		}
+		if err := m.DropIndex(flags.Arg(0), flags.Arg(1), flags.Arg(2)); err != nil {
+			return err
+		}
+		return writeResult(out, output, bson.D{{Key: "dropped", Value: flags.Arg(2)}})
+	case "sync":
+		var file = flags.String("file", "", `Extended JSON array of index specifications (required), e.g. [{"key": {"email": 1}, "unique": true}]`)
+		var dryRun = flags.Bool("dry-run", false, "only print the changes")
+		db, coll, err := parseTransferArgs(flags, args[1:])
+		if err != nil {
+			return err
+		}
+		if *file == "" {
+			return errors.New("index sync expects -file")
+		}
+		data, err := ioutil.ReadFile(*file)
+		if err != nil {
+			return err
+		}
+		var declared struct {
+			Indexes []IndexSpec `bson:"indexes"`
+		}
+		// wrapped since Extended JSON can't hold a top-level array
+		if err := bson.UnmarshalExtJSON([]byte(`{"indexes":`+string(data)+`}`), false, &declared); err != nil {
+			return errors.New("invalid index specifications, got error: " + err.Error())
+		}
+		changes, err := m.SyncIndexes(db, coll, declared.Indexes, *dryRun)
+		if err != nil {
+			return err
+		}
+		return writeResult(out, output, bson.D{
+			{Key: "created", Value: stringsValue(changes.Created)},
+			{Key: "dropped", Value: stringsValue(changes.Dropped)},
+		})
+	}
+	return errors.New("unknown index command " + strconv.Quote(args[0]))
+}
+
+// stringsValue keeps empty lists as [] rather than null in the output
+func stringsValue(values []string) bson.A {
+	var a = bson.A{}
+	for _, v := range values {
+		a = append(a, v)
+	}
+	return a
+}
+
+// parseFilterArg is parseJSONArg() defaulting to the empty filter
+func parseFilterArg(value string) (interface{}, error) {
+	filter, err := parseJSONArg("filter", value)
+	if filter == nil && err == nil {
+		filter = bson.D{}
+	}
+	return filter, err
+}
+
+// parseSearchFilters reads the filters of Search() from JSON
+func parseSearchFilters(value string) (map[string][]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var filters map[string][]string
+	if err := json.Unmarshal([]byte(value), &filters); err != nil {
+		return nil, errors.New("invalid filters, got error: " + err.Error())
+	}
+	for field, filter := range filters {
+		if len(filter) != 2 || (filter[1] != "eq" && filter[1] != "like") {
+			return nil, errors.New("invalid filter of " + strconv.Quote(field) + `, expected ["value", "eq"] or ["regex", "like"]`)
+		}
+	}
+	return filters, nil
+}
+
+func writeCursor(ctx context.Context, cur *mongo.Cursor, output string, out io.Writer) error {
+	defer cur.Close(ctx)
+	var docs []bson.Raw
+	for cur.Next(ctx) {
+		docs = append(docs, append(bson.Raw(nil), cur.Current...))
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+	return writeDocuments(out, output, docs)
+}
+
+// writeResult writes the outcome of a command, a single line for tables
+func writeResult(out io.Writer, output string, result bson.D) error {
+	doc, err := bson.Marshal(result)
+	if err != nil {
+		return err
+	}
+	if output == OutputJSON {
+		return writeDocuments(out, output, []bson.Raw{doc})
+	}
+	var parts = make([]string, len(result))
+	for i, e := range result {
+		parts[i] = e.Key + ": " + cellValue(bson.Raw(doc).Lookup(e.Key))
+	}
+	_, err = fmt.Fprintln(out, strings.Join(parts, ", "))
+	return err
+}
+
+// writeStats writes the top-level fields of a stats command as rows
+func writeStats(out io.Writer, output string, stats bson.Raw) error {
+	if output == OutputJSON {
+		return writeDocuments(out, output, []bson.Raw{stats})
+	}
+	elements, err := stats.Elements()
+	if err != nil {
+		return err
+	}
+	var w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, e := range elements {
+		if e.Value().Type == bsontype.EmbeddedDocument {
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "%v\t%v\n", e.Key(), cellValue(e.Value()))
+	}
+	return w.Flush()
+}
+
+// writeDocuments writes docs as a JSON array or as a table whose columns
+// are the top-level fields of all the documents
+func writeDocuments(out io.Writer, output string, docs []bson.Raw) error {
+	switch output {
+	case OutputJSON:
+		var jw = &jsonWriter{w: bufio.NewWriter(out), array: true}
+		for _, doc := range docs {
+			if err := jw.write(doc); err != nil {
+				return err
+			}
+		}
+		return jw.close()
+	case OutputTable:
+	default:
+		return errors.New("unknown output " + strconv.Quote(output) + ", expected table or json")
+	}
+
+	var columns []string
+	var seen = map[string]bool{}
+	for _, doc := range docs {
+		elements, err := doc.Elements()
+		if err != nil {
+			return err
+		}
+		for _, e := range elements {
+			if !seen[e.Key()] {
+				seen[e.Key()] = true
+				columns = append(columns, e.Key())
+			}
+		}
+	}
+	var w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, strings.Join(columns, "\t"))
+	for _, doc := range docs {
+		var cells = make([]string, len(columns))
+		for i, column := range columns {
+			if value, err := doc.LookupErr(column); err == nil {
+				cells[i] = cellValue(value)
+			}
+		}
+		_, _ = fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+	_, _ = fmt.Fprintf(w, "(%v documents)\n", len(docs))
+	return w.Flush()
+}
+
+// cellValue renders a value on a single line of a table
+func cellValue(v bson.RawValue) string {
+	switch v.Type {
+	case bsontype.String:
+		return strings.Replace(v.StringValue(), "\n", `\n`, -1)
+	case bsontype.ObjectID:
+		return v.ObjectID().Hex()
+	case bsontype.DateTime:
+		return v.Time().UTC().Format(time.RFC3339)
+	case bsontype.Int32, bsontype.Int64, bsontype.Double, bsontype.Boolean, bsontype.Null:
+		return v.String()
+	case bsontype.EmbeddedDocument, bsontype.Array:
+		// relaxed Extended JSON is the most readable form of nested values
+		data, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: v}}, false, false)
+		if err == nil {
+			return strings.TrimSuffix(strings.TrimPrefix(string(data), `{"v":`), "}")
+		}
+	}
+	return v.String()
+}
@@ -0,0 +1,73 @@
+package mongoadapter
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestWriteDocuments(t *testing.T) {
+	var id = primitive.NewObjectID()
+	a, _ := bson.Marshal(bson.D{{Key: "_id", Value: id}, {Key: "name", Value: "sara"}})
+	b, _ := bson.Marshal(bson.D{{Key: "name", Value: "john"}, {Key: "tags", Value: bson.A{"x", int32(1)}}})
+
+	var out bytes.Buffer
+	assert.Nil(t, writeDocuments(&out, OutputTable, []bson.Raw{a, b}))
+	var lines = strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 4)
+	assert.Equal(t, []string{"_id", "name", "tags"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{id.Hex(), "sara"}, strings.Fields(lines[1]))
+	assert.Equal(t, []string{"john", `["x",1]`}, strings.Fields(lines[2]))
+	assert.Equal(t, "(2 documents)", lines[3])
+
+	out.Reset()
+	assert.Nil(t, writeDocuments(&out, OutputJSON, []bson.Raw{b}))
+	assert.Equal(t, `[{"name":"john","tags":["x",1]}]`+"\n", out.String())
+	assert.Error(t, writeDocuments(&out, "xml", nil))
+}
+
+func TestParseSearchFilters(t *testing.T) {
+	filters, err := parseSearchFilters(`{"country": ["italy", "eq"], "name": ["^sa", "like"]}`)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]string{"country": {"italy", "eq"}, "name": {"^sa", "like"}}, filters)
+	_, err = parseSearchFilters(`{"country": ["italy"]}`)
+	assert.Error(t, err)
+	_, err = parseSearchFilters(`{"country": ["italy", "in"]}`)
+	assert.Error(t, err)
+}
+
+func TestRunCommand(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	var coll = "cli"
+	_ = m.conn.Database(mongoDatabase).Collection(coll).Drop(context.Background())
+	var ctx = context.Background()
+	var out, errOut bytes.Buffer
+
+	var in = strings.NewReader(`{"name": "sara", "age": 30}` + "\n" + `{"name": "john", "age": 40}`)
+	assert.Nil(t, RunCommand(ctx, m, []string{"insert", "-format", "ndjson", mongoDatabase, coll}, in, &out, &errOut))
+	assert.Equal(t, "inserted: 2\n", out.String())
+
+	out.Reset()
+	assert.Nil(t, RunCommand(ctx, m, []string{"count", "-filter", `{"age": {"$gt": 35}}`, mongoDatabase, coll}, nil, &out, &errOut))
+	assert.Equal(t, "count: 1\n", out.String())
+
+	out.Reset()
+	assert.Nil(t, RunCommand(ctx, m, []string{"search", "-output", "json", "-filters", `{"name": ["^sa", "like"]}`, mongoDatabase, coll}, nil, &out, &errOut))
+	assert.Contains(t, out.String(), `"name":"sara"`)
+
+	out.Reset()
+	var err = RunCommand(ctx, m, []string{"delete", "-filter", `{}`, "-many", mongoDatabase, coll}, strings.NewReader("n\n"), &out, &errOut)
+	assert.EqualError(t, err, "delete cancelled")
+	assert.Contains(t, errOut.String(), "delete 2 document(s)")
+	assert.Nil(t, RunCommand(ctx, m, []string{"delete", "-filter", `{}`, "-many", mongoDatabase, coll}, strings.NewReader("y\n"), &out, &errOut))
+	assert.Equal(t, "deleted: 2\n", out.String())
+
+	out.Reset()
+	assert.Nil(t, RunCommand(ctx, m, []string{"index", "create", "-keys", `{"name": 1}`, "-unique", mongoDatabase, coll}, nil, &out, &errOut))
+	assert.Equal(t, "created: name_1\n", out.String())
+}
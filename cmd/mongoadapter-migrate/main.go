@@ -0,0 +1,65 @@
+// Command mongoadapter-migrate applies the migrations registered with
+// mongoadapter.RegisterMigration. The binary only knows the migrations of the
+// packages it imports, so copy this file into your project and add a blank
+// import of the package(s) holding your migrations:
+//
+//	import _ "example.com/project/migrations"
+//
+// Usage:
+//
+//	mongoadapter-migrate [flags] up|down [n]|to <version>|status
+//
+// The connection flags can also be set through the GOTEST_MONGO_* variables
+// of .env.sample, e.g. GOTEST_MONGO_HOST, and the other flags through the
+// variable named in their description.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/farzandalaee/mongoadapter"
+)
+
+func main() {
+	var config = mongoadapter.DefaultMongoConfig()
+	if err := config.LoadEnv("GOTEST_MONGO_"); err != nil {
+		fail(err)
+	}
+	var db, coll string
+	config.RegisterFlags(flag.CommandLine, "")
+	flag.StringVar(&db, "db", env("GOTEST_MONGO_DB", ""), "database to migrate (GOTEST_MONGO_DB)")
+	flag.StringVar(&coll, "collection", env("GOTEST_MONGO_MIGRATIONS_COLL", "migrations"), "collection keeping the applied versions (GOTEST_MONGO_MIGRATIONS_COLL)")
+	flag.Parse()
+	if err := config.Validate(); err != nil {
+		fail(err)
+	}
+
+	m, err := mongoadapter.NewMongo(&config)
+	if err != nil {
+		fail(err)
+	}
+	migrator, err := mongoadapter.NewMigrator(m, mongoadapter.MigratorConfig{DB: db, Collection: coll})
+	if err != nil {
+		fail(err)
+	}
+	err = mongoadapter.RunMigrationCommand(context.Background(), migrator, flag.Args(), os.Stdout)
+	mongoadapter.Destroy(config.Host, config.Port)
+	if err != nil {
+		fail(err)
+	}
+}
+
+func env(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "mongoadapter-migrate:", err)
+	os.Exit(1)
+}
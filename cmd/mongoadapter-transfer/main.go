@@ -0,0 +1,47 @@
+// Command mongoadapter-transfer exports collections to, and imports them
+// from, Extended JSON, NDJSON and CSV.
+//
+// Usage:
+//
+//	mongoadapter-transfer [flags] export [-format json|ndjson|csv] [-canonical] [-filter <json>] [-projection <json>] [-fields a,b] <db> <coll> > dump.json
+//	mongoadapter-transfer [flags] import [-format json|ndjson|csv] [-batch n] [-upsert-key a,b] [-types field:type,...] <db> <coll> < dump.json
+//
+// The connection flags can also be set through the GOTEST_MONGO_* variables
+// of .env.sample, e.g. GOTEST_MONGO_HOST. Progress is reported on stderr.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/farzandalaee/mongoadapter"
+)
+
+func main() {
+	var config = mongoadapter.DefaultMongoConfig()
+	if err := config.LoadEnv("GOTEST_MONGO_"); err != nil {
+		fail(err)
+	}
+	config.RegisterFlags(flag.CommandLine, "")
+	flag.Parse()
+	if err := config.Validate(); err != nil {
+		fail(err)
+	}
+
+	m, err := mongoadapter.NewMongo(&config)
+	if err != nil {
+		fail(err)
+	}
+	err = mongoadapter.RunTransferCommand(context.Background(), m, flag.Args(), os.Stdin, os.Stdout, os.Stderr)
+	mongoadapter.Destroy(config.Host, config.Port)
+	if err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "mongoadapter-transfer:", err)
+	os.Exit(1)
+}
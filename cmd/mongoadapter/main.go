@@ -0,0 +1,72 @@
+// Command mongoadapter runs ad-hoc operations through the adapter.
+//
+// Usage:
+//
+//	mongoadapter [flags] find [-filter <json>] [-projection <json>] [-sort <json>] [-limit n] [-skip n] <db> <coll>
+//	mongoadapter [flags] search [-filters <json>] [-sort <json>] [-limit n] [-skip n] <db> <coll>
+//	mongoadapter [flags] count [-filter <json>] <db> <coll>
+//	mongoadapter [flags] insert [-file path] [-format json|ndjson] <db> <coll>
+//	mongoadapter [flags] update -filter <json> -update <json> [-many] [-upsert] <db> <coll>
+//	mongoadapter [flags] delete -filter <json> [-many] [-yes] <db> <coll>
+//	mongoadapter [flags] index list <db> <coll>
+//	mongoadapter [flags] index create -keys <json> [-name n] [-unique] [-sparse] [-ttl seconds] <db> <coll>
+//	mongoadapter [flags] index drop <db> <coll> <name>
+//	mongoadapter [flags] index sync -file indexes.json [-dry-run] <db> <coll>
+//	mongoadapter [flags] stats <db> [coll]
+//	mongoadapter [flags] ping
+//
+// Every subcommand accepts -output table|json. The connection flags can
+// also be set through the GOTEST_MONGO_* variables of .env.sample, which
+// are read from the environment and from the file given by -env.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/farzandalaee/mongoadapter"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	var envFile = ".env"
+	for i, arg := range os.Args[1:] {
+		switch {
+		case (arg == "-env" || arg == "--env") && i+2 < len(os.Args):
+			envFile = os.Args[i+2]
+		case strings.HasPrefix(arg, "-env="), strings.HasPrefix(arg, "--env="):
+			envFile = arg[strings.Index(arg, "=")+1:]
+		}
+	}
+	// a missing file is fine, the environment and the flags are enough
+	_ = godotenv.Load(envFile)
+
+	var config = mongoadapter.DefaultMongoConfig()
+	if err := config.LoadEnv("GOTEST_MONGO_"); err != nil {
+		fail(err)
+	}
+	flag.String("env", envFile, "file of environment variables")
+	config.RegisterFlags(flag.CommandLine, "")
+	flag.Parse()
+	if err := config.Validate(); err != nil {
+		fail(err)
+	}
+
+	m, err := mongoadapter.NewMongo(&config)
+	if err != nil {
+		fail(err)
+	}
+	err = mongoadapter.RunCommand(context.Background(), m, flag.Args(), os.Stdin, os.Stdout, os.Stderr)
+	mongoadapter.Destroy(config.Host, config.Port)
+	if err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "mongoadapter:", err)
+	os.Exit(1)
+}
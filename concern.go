@@ -0,0 +1,126 @@
+package mongoadapter
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// Option changes the behaviour of a Mongo view returned by With()
+type Option func(m *Mongo)
+
+// ReadPreference makes the reads of the view go to the given members,
+// e.g. ReadPreference(readpref.SecondaryPreferred()) for analytics queries
+func ReadPreference(rp *readpref.ReadPref) Option {
+	return func(m *Mongo) {
+		m.readPref = rp
+	}
+}
+
+// ReadConcern sets the read concern of the view, e.g. readconcern.Majority()
+func ReadConcern(rc *readconcern.ReadConcern) Option {
+	return func(m *Mongo) {
+		m.readConcern = rc
+	}
+}
+
+// WriteConcern sets the write concern of the view, e.g.
+// writeconcern.New(writeconcern.WMajority(), writeconcern.J(true))
+func WriteConcern(wc *writeconcern.WriteConcern) Option {
+	return func(m *Mongo) {
+		m.writeConcern = wc
+	}
+}
+
+// Codecs makes the view encode and decode documents with the given
+// registry instead of the driver's default one
+func Codecs(r *bsoncodec.Registry) Option {
+	return func(m *Mongo) {
+		m.codecs = r
+	}
+}
+
+// With returns a view of m sharing its connection but with the given options
+// applied on top of the connection-level defaults. The view is cheap to
+// create and can be used for a single call:
+// m.With(ReadPreference(readpref.Secondary())).Search(...)
+func (m *Mongo) With(opts ...Option) *Mongo {
+	var view = *m
+	for _, opt := range opts {
+		opt(&view)
+	}
+	return &view
+}
+
+// collection returns the driver's collection handle with the view's
+// read preference, read concern, write concern and codecs applied.
+// Every operation of the adapter should get its collection from here.
+func (m *Mongo) collection(db, coll string) *mongo.Collection {
+	if m.readPref == nil && m.readConcern == nil && m.writeConcern == nil && m.codecs == nil {
+		return m.conn.Database(db).Collection(coll)
+	}
+	var collOptions = options.Collection()
+	if m.readPref != nil {
+		collOptions.SetReadPreference(m.readPref)
+	}
+	if m.readConcern != nil {
+		collOptions.SetReadConcern(m.readConcern)
+	}
+	if m.writeConcern != nil {
+		collOptions.SetWriteConcern(m.writeConcern)
+	}
+	if m.codecs != nil {
+		collOptions.SetRegistry(m.codecs)
+	}
+	return m.conn.Database(db).Collection(coll, collOptions)
+}
+
+// parseReadPreference accepts the mode names used in connection strings:
+// primary, primaryPreferred, secondary, secondaryPreferred and nearest
+func parseReadPreference(mode string) (*readpref.ReadPref, error) {
+	m, err := readpref.ModeFromString(mode)
+	if err != nil {
+		return nil, errors.New("invalid read preference " + strconv.Quote(mode) + ", got error: " + err.Error())
+	}
+	return readpref.New(m)
+}
+
+// parseReadConcern accepts local, majority, available, linearizable and snapshot
+func parseReadConcern(level string) (*readconcern.ReadConcern, error) {
+	switch level {
+	case "local", "majority", "available", "linearizable", "snapshot":
+		return readconcern.New(readconcern.Level(level)), nil
+	}
+	return nil, errors.New("invalid read concern " + strconv.Quote(level))
+}
+
+// parseWriteConcern accepts "majority", a number of members or a tag set
+// name for w, journal requests acknowledgement after the journal commit
+func parseWriteConcern(w string, journal bool) (*writeconcern.WriteConcern, error) {
+	var opts []writeconcern.Option
+	if w == "majority" {
+		opts = append(opts, writeconcern.WMajority())
+	} else if n, err := strconv.Atoi(w); err == nil {
+		if n < 0 {
+			return nil, errors.New("invalid write concern " + strconv.Quote(w) + ", w must not be negative")
+		}
+		opts = append(opts, writeconcern.W(n))
+	} else if strings.TrimSpace(w) != "" {
+		opts = append(opts, writeconcern.WTagSet(w))
+	}
+	if journal {
+		opts = append(opts, writeconcern.J(true))
+	}
+	var wc = writeconcern.New(opts...)
+	if !wc.IsValid() {
+		return nil, errors.New("invalid write concern, w: 0 cannot be combined with journal")
+	}
+	return wc, nil
+}
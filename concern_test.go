@@ -0,0 +1,70 @@
+package mongoadapter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+func TestNewMongo_mustAssertErr_invalidReadPreference(t *testing.T) {
+	Destroy(mongoConfig.Host, mongoConfig.Port)
+	var wrongConfig = *mongoConfig
+	wrongConfig.ReadPreference = "wrong"
+	m, err := NewMongo(&wrongConfig)
+	assert.Error(t, err)
+	assert.Nil(t, m)
+	Destroy(mongoConfig.Host, mongoConfig.Port)
+}
+
+func TestNewMongo_mustAssertTrue_withConcerns(t *testing.T) {
+	Destroy(mongoConfig.Host, mongoConfig.Port)
+	var config = *mongoConfig
+	config.ReadPreference = "primaryPreferred"
+	config.ReadConcern = "local"
+	config.WriteConcern = "1"
+	config.Journal = true
+	m, err := NewMongo(&config)
+	assert.Nil(t, err)
+	_, err = m.InsertOne(mongoDatabase, mongoColl, bson.M{"name": "concerned"})
+	assert.Nil(t, err)
+	Destroy(mongoConfig.Host, mongoConfig.Port)
+}
+
+func TestMongo_With(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	view := m.With(
+		ReadPreference(readpref.SecondaryPreferred()),
+		ReadConcern(readconcern.Local()),
+		WriteConcern(writeconcern.New(writeconcern.W(1))),
+	)
+	assert.Equal(t, m.ID, view.ID)
+	assert.Nil(t, m.readPref)
+	assert.Equal(t, readpref.SecondaryPreferredMode, view.readPref.Mode())
+
+	_, err := view.InsertOne(mongoDatabase, mongoColl, bson.M{"name": "viewer"})
+	assert.Nil(t, err)
+	cur, err := view.Search(mongoDatabase, mongoColl, map[string][]string{"name": {"viewer", "eq"}}, nil, 0, 0)
+	assert.Nil(t, err)
+	assert.True(t, CountCursor(cur) > 0)
+}
+
+func TestParseWriteConcern(t *testing.T) {
+	wc, err := parseWriteConcern("majority", true)
+	assert.Nil(t, err)
+	assert.Equal(t, "majority", wc.GetW())
+	assert.True(t, wc.GetJ())
+
+	wc, err = parseWriteConcern("2", false)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, wc.GetW())
+
+	_, err = parseWriteConcern("0", true)
+	assert.Error(t, err)
+
+	_, err = parseReadConcern("wrong")
+	assert.Error(t, err)
+}
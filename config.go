@@ -0,0 +1,326 @@
+package mongoadapter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// configField maps a field of MongoConfig to its environment variable
+// suffix, its key in files and its flag name
+type configField struct {
+	env   string
+	key   string
+	flag  string
+	usage string
+	get   func(c *MongoConfig) string
+	set   func(c *MongoConfig, v string) error
+}
+
+var configFields = []configField{
+	{"HOST", "host", "host", "mongo host",
+		func(c *MongoConfig) string { return c.Host },
+		func(c *MongoConfig, v string) error { c.Host = v; return nil }},
+	{"PORT", "port", "port", "mongo port",
+		func(c *MongoConfig) string { return strconv.Itoa(c.Port) },
+		func(c *MongoConfig, v string) (err error) { c.Port, err = strconv.Atoi(v); return err }},
+	{"USERNAME", "username", "username", "mongo username",
+		func(c *MongoConfig) string { return c.Username },
+		func(c *MongoConfig, v string) error { c.Username = v; return nil }},
+	{"PASSWORD", "password", "password", "mongo password",
+		func(c *MongoConfig) string { return c.Password },
+		func(c *MongoConfig, v string) error { c.Password = v; return nil }},
+	durationField("CONN_TIMEOUT", "connTimeout", "conn-timeout", "connection timeout",
+		func(c *MongoConfig) *time.Duration { return &c.ConnTimeout }),
+	durationField("READ_TIMEOUT", "readTimeout", "read-timeout", "read timeout",
+		func(c *MongoConfig) *time.Duration { return &c.ReadTimeout }),
+	durationField("WRITE_TIMEOUT", "writeTimeout", "write-timeout", "write timeout",
+		func(c *MongoConfig) *time.Duration { return &c.WriteTimeout }),
+	durationField("MAX_CONN_IDLE_TIME", "maxConnIdleTime", "max-conn-idle-time", "maximum idle time of a connection",
+		func(c *MongoConfig) *time.Duration { return &c.MaxConnIdleTime }),
+	durationField("SERVER_SELECTION_TIMEOUT", "serverSelectionTimeout", "server-selection-timeout", "maximum wait for a suitable member, 0 for the driver default",
+		func(c *MongoConfig) *time.Duration { return &c.ServerSelectionTimeout }),
+	durationField("SOCKET_TIMEOUT", "socketTimeout", "socket-timeout", "maximum wait for a read or write on a connection, 0 for none",
+		func(c *MongoConfig) *time.Duration { return &c.SocketTimeout }),
+	durationField("HEARTBEAT_INTERVAL", "heartbeatInterval", "heartbeat-interval", "time between two checks of the members, 0 for the driver default",
+		func(c *MongoConfig) *time.Duration { return &c.HeartbeatInterval }),
+	durationField("COUNT_TIMEOUT", "countTimeout", "count-timeout", "timeout of counts, 0 for the read timeout",
+		func(c *MongoConfig) *time.Duration { return &c.CountTimeout }),
+	durationField("AGGREGATE_TIMEOUT", "aggregateTimeout", "aggregate-timeout", "timeout of aggregations and searches, 0 for the read timeout",
+		func(c *MongoConfig) *time.Duration { return &c.AggregateTimeout }),
+	durationField("BULK_TIMEOUT", "bulkTimeout", "bulk-timeout", "timeout of writes on many documents, 0 for the write timeout",
+		func(c *MongoConfig) *time.Duration { return &c.BulkTimeout }),
+	{"MAX_POOL_SIZE", "maxPoolSize", "max-pool-size", "maximum number of connections, 0 for the driver default",
+		func(c *MongoConfig) string { return strconv.FormatUint(c.MaxPoolSize, 10) },
+		func(c *MongoConfig, v string) (err error) {
+			c.MaxPoolSize, err = strconv.ParseUint(v, 10, 64)
+			return err
+		}},
+	{"MIN_POOL_SIZE", "minPoolSize", "min-pool-size", "minimum number of connections",
+		func(c *MongoConfig) string { return strconv.FormatUint(c.MinPoolSize, 10) },
+		func(c *MongoConfig, v string) (err error) {
+			c.MinPoolSize, err = strconv.ParseUint(v, 10, 64)
+			return err
+		}},
+	{"READ_PREFERENCE", "readPreference", "read-preference", "primary, primaryPreferred, secondary, secondaryPreferred or nearest",
+		func(c *MongoConfig) string { return c.ReadPreference },
+		func(c *MongoConfig, v string) error { c.ReadPreference = v; return nil }},
+	{"READ_CONCERN", "readConcern", "read-concern", "local, majority, available, linearizable or snapshot",
+		func(c *MongoConfig) string { return c.ReadConcern },
+		func(c *MongoConfig, v string) error { c.ReadConcern = v; return nil }},
+	{"WRITE_CONCERN", "writeConcern", "write-concern", "majority, a number of members or a tag set",
+		func(c *MongoConfig) string { return c.WriteConcern },
+		func(c *MongoConfig, v string) error { c.WriteConcern = v; return nil }},
+	{"JOURNAL", "journal", "journal", "acknowledge writes after the journal commit",
+		func(c *MongoConfig) string { return strconv.FormatBool(c.Journal) },
+		func(c *MongoConfig, v string) (err error) { c.Journal, err = strconv.ParseBool(v); return err }},
+}
+
+// durationField is a configField of a timeout, see parseDuration()
+func durationField(env, key, flag, usage string, field func(c *MongoConfig) *time.Duration) configField {
+	return configField{env, key, flag, usage + ", e.g. 30s or a number of seconds",
+		func(c *MongoConfig) string { return formatDuration(*field(c)) },
+		func(c *MongoConfig, v string) (err error) { *field(c), err = parseDuration(v); return err }}
+}
+
+// parseDuration reads a timeout given as a duration such as "1m30s" or
+// "500ms", or as a number of seconds as the configurations did before
+func parseDuration(v string) (time.Duration, error) {
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, errors.New("invalid timeout " + strconv.Quote(v) + ", expected a duration or seconds")
+	}
+	return d, nil
+}
+
+// formatDuration formats the timeout the way NewMongo() reads it, a legacy
+// number of seconds included
+func formatDuration(d time.Duration) string {
+	return legacyDuration(d).String()
+}
+
+// minHeartbeatInterval is the rate limit of the driver, it never checks
+// the members more often than that
+const minHeartbeatInterval = 500 * time.Millisecond
+
+// DefaultMongoConfig returns the configuration of a local server with the
+// timeouts NewMongo() falls back to
+func DefaultMongoConfig() MongoConfig {
+	return MongoConfig{
+		Host:         "127.0.0.1",
+		Port:         27017,
+		ConnTimeout:  defaultTimeout,
+		ReadTimeout:  defaultTimeout,
+		WriteTimeout: defaultTimeout,
+	}
+}
+
+// LoadMongoConfig returns the defaults overridden by the given files, in
+// order, and then by the environment variables starting with envPrefix,
+// e.g. LoadMongoConfig("GOTEST_MONGO_", "config.yml"). The result is validated.
+func LoadMongoConfig(envPrefix string, files ...string) (*MongoConfig, error) {
+	var c = DefaultMongoConfig()
+	for _, file := range files {
+		if err := c.LoadFile(file); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.LoadEnv(envPrefix); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// LoadEnv sets the fields whose variable is set in the environment, the
+// variables are the prefix followed by HOST, PORT, USERNAME, PASSWORD,
+// CONN_TIMEOUT, READ_TIMEOUT, WRITE_TIMEOUT, MAX_CONN_IDLE_TIME,
+// SERVER_SELECTION_TIMEOUT, SOCKET_TIMEOUT, HEARTBEAT_INTERVAL,
+// COUNT_TIMEOUT, AGGREGATE_TIMEOUT, BULK_TIMEOUT, MAX_POOL_SIZE,
+// MIN_POOL_SIZE, READ_PREFERENCE, READ_CONCERN, WRITE_CONCERN and JOURNAL.
+// Timeouts are durations like "1m" or "500ms", or a number of seconds.
+func (c *MongoConfig) LoadEnv(prefix string) error {
+	for _, f := range configFields {
+		v, ok := os.LookupEnv(prefix + f.env)
+		if !ok {
+			continue
+		}
+		if err := f.set(c, strings.TrimSpace(v)); err != nil {
+			return errors.New("invalid " + prefix + f.env + ", got error: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// LoadFile sets the fields present in a YAML (.yml, .yaml) or JSON (.json)
+// file, the keys are the camel-cased field names, e.g. maxPoolSize.
+// Unknown keys are refused so that typos don't go unnoticed.
+func (c *MongoConfig) LoadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var values map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		var decoder = json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		err = decoder.Decode(&values)
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(data, &values)
+	default:
+		return errors.New("unknown configuration format of " + path + ", expected .json, .yml or .yaml")
+	}
+	if err != nil {
+		return errors.New("failed to read " + path + ", got error: " + err.Error())
+	}
+	var keys = make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		f, ok := configFieldByKey(key)
+		if !ok {
+			return errors.New("unknown key " + strconv.Quote(key) + " in " + path)
+		}
+		if values[key] == nil {
+			continue
+		}
+		if err := f.set(c, fmt.Sprint(values[key])); err != nil {
+			return errors.New("invalid " + key + " in " + path + ", got error: " + err.Error())
+		}
+	}
+	return nil
+}
+
+func configFieldByKey(key string) (configField, bool) {
+	for _, f := range configFields {
+		if f.key == key {
+			return f, true
+		}
+	}
+	return configField{}, false
+}
+
+// configFlag sets a field of the configuration from the command line
+type configFlag struct {
+	c *MongoConfig
+	f configField
+}
+
+func (v configFlag) String() string {
+	if v.c == nil {
+		return ""
+	}
+	return v.f.get(v.c)
+}
+
+func (v configFlag) Set(s string) error {
+	return v.f.set(v.c, s)
+}
+
+// IsBoolFlag allows -journal without a value
+func (v configFlag) IsBoolFlag() bool {
+	return v.f.key == "journal"
+}
+
+// RegisterFlags adds a flag per field to fs, named after the prefix and
+// the field, e.g. "mongo-" gives -mongo-host and -mongo-read-timeout. The
+// current values are the defaults, so load the files and the environment
+// before parsing the flags to give the flags the last word.
+func (c *MongoConfig) RegisterFlags(fs *flag.FlagSet, prefix string) {
+	for _, f := range configFields {
+		fs.Var(configFlag{c: c, f: f}, prefix+f.flag, f.usage)
+	}
+}
+
+// Validate checks the configuration, it reports all the problems at once
+func (c *MongoConfig) Validate() error {
+	var problems []string
+	if c.Host == "" {
+		problems = append(problems, "host is required")
+	}
+	if c.Port <= 0 || c.Port > 65535 {
+		problems = append(problems, "port must be between 1 and 65535")
+	}
+	if (c.Username == "") != (c.Password == "") {
+		problems = append(problems, "username and password must be set together")
+	}
+	for _, timeout := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"connTimeout", c.ConnTimeout},
+		{"readTimeout", c.ReadTimeout},
+		{"writeTimeout", c.WriteTimeout},
+		{"maxConnIdleTime", c.MaxConnIdleTime},
+		{"serverSelectionTimeout", c.ServerSelectionTimeout},
+		{"socketTimeout", c.SocketTimeout},
+		{"heartbeatInterval", c.HeartbeatInterval},
+		{"countTimeout", c.CountTimeout},
+		{"aggregateTimeout", c.AggregateTimeout},
+		{"bulkTimeout", c.BulkTimeout},
+	} {
+		if timeout.value < 0 {
+			problems = append(problems, timeout.name+" must not be negative")
+		}
+	}
+	if d := legacyDuration(c.HeartbeatInterval); d > 0 && d < minHeartbeatInterval {
+		problems = append(problems, "heartbeatInterval must be at least "+minHeartbeatInterval.String())
+	}
+	if c.MaxPoolSize != 0 && c.MinPoolSize > c.MaxPoolSize {
+		problems = append(problems, "minPoolSize must not be greater than maxPoolSize")
+	}
+	if c.ReadPreference != "" {
+		if _, err := parseReadPreference(c.ReadPreference); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if c.ReadConcern != "" {
+		if _, err := parseReadConcern(c.ReadConcern); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if c.WriteConcern != "" || c.Journal {
+		if _, err := parseWriteConcern(c.WriteConcern, c.Journal); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New("invalid mongo configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// String describes the configuration for logs, the password is redacted
+func (c MongoConfig) String() string {
+	var parts = make([]string, 0, len(configFields))
+	for _, f := range configFields {
+		var v = f.get(&c)
+		if f.key == "password" && v != "" {
+			v = "[redacted]"
+		}
+		if v == "" {
+			continue
+		}
+		parts = append(parts, f.key+"="+v)
+	}
+	return "MongoConfig{" + strings.Join(parts, " ") + "}"
+}
@@ -0,0 +1,104 @@
+package mongoadapter
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMongoConfig_LoadEnv(t *testing.T) {
+	var env = map[string]string{
+		"CFGTEST_HOST":          "db.internal",
+		"CFGTEST_PORT":          "27018",
+		"CFGTEST_READ_TIMEOUT":  "1m",
+		"CFGTEST_BULK_TIMEOUT":  "90",
+		"CFGTEST_MAX_POOL_SIZE": "50",
+		"CFGTEST_JOURNAL":       "true",
+	}
+	for k, v := range env {
+		_ = os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+	config, err := LoadMongoConfig("CFGTEST_")
+	assert.Nil(t, err)
+	assert.Equal(t, "db.internal", config.Host)
+	assert.Equal(t, 27018, config.Port)
+	assert.Equal(t, time.Minute, config.ReadTimeout)
+	assert.Equal(t, 5*time.Second, config.WriteTimeout, "defaults are kept")
+	assert.Equal(t, 90*time.Second, config.BulkTimeout)
+	assert.Equal(t, uint64(50), config.MaxPoolSize)
+	assert.True(t, config.Journal)
+
+	_ = os.Setenv("CFGTEST_PORT", "http")
+	_, err = LoadMongoConfig("CFGTEST_")
+	assert.EqualError(t, err, `invalid CFGTEST_PORT, got error: strconv.Atoi: parsing "http": invalid syntax`)
+}
+
+func TestMongoConfig_LoadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	var yml = filepath.Join(dir, "mongo.yml")
+	var js = filepath.Join(dir, "mongo.json")
+	assert.Nil(t, ioutil.WriteFile(yml, []byte("host: db.internal\nport: 27018\nreadPreference: secondary\nminPoolSize: 4\n"), 0600))
+	assert.Nil(t, ioutil.WriteFile(js, []byte(`{"port": 27019, "maxPoolSize": 1000000, "writeTimeout": "30s", "socketTimeout": "1.5s"}`), 0600))
+
+	config, err := LoadMongoConfig("CFGTEST_UNSET_", yml, js)
+	assert.Nil(t, err)
+	assert.Equal(t, "db.internal", config.Host)
+	assert.Equal(t, 27019, config.Port, "later files win")
+	assert.Equal(t, "secondary", config.ReadPreference)
+	assert.Equal(t, uint64(1000000), config.MaxPoolSize)
+	assert.Equal(t, 30*time.Second, config.WriteTimeout)
+	assert.Equal(t, 1500*time.Millisecond, config.SocketTimeout)
+
+	assert.Nil(t, ioutil.WriteFile(yml, []byte("hots: db.internal\n"), 0600))
+	_, err = LoadMongoConfig("CFGTEST_UNSET_", yml)
+	assert.EqualError(t, err, `unknown key "hots" in `+yml)
+}
+
+func TestMongoConfig_RegisterFlags(t *testing.T) {
+	var config = DefaultMongoConfig()
+	var fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	config.RegisterFlags(fs, "mongo-")
+	assert.Nil(t, fs.Parse([]string{"-mongo-host", "db.internal", "-mongo-read-timeout", "10", "-mongo-journal"}))
+	assert.Equal(t, "db.internal", config.Host)
+	assert.Equal(t, 10*time.Second, config.ReadTimeout, "plain numbers are seconds")
+	assert.True(t, config.Journal)
+	assert.Equal(t, "27017", fs.Lookup("mongo-port").DefValue)
+}
+
+func TestMongoConfig_Validate(t *testing.T) {
+	var config = DefaultMongoConfig()
+	assert.Nil(t, config.Validate())
+	config.Port = 70000
+	config.Username = "admin"
+	config.MinPoolSize, config.MaxPoolSize = 10, 5
+	config.ReadTimeout = -1
+	config.ReadConcern = "strong"
+	config.HeartbeatInterval = 100 * time.Millisecond
+	var err = config.Validate()
+	assert.Error(t, err)
+	for _, problem := range []string{"port", "username and password", "minPoolSize", "readTimeout", "read concern", "heartbeatInterval"} {
+		assert.Contains(t, err.Error(), problem)
+	}
+}
+
+func TestMongoConfig_String(t *testing.T) {
+	var config = DefaultMongoConfig()
+	config.Username, config.Password = "admin", "s3cret"
+	var s = config.String()
+	assert.False(t, strings.Contains(s, "s3cret"))
+	assert.Contains(t, s, "password=[redacted]")
+	assert.Contains(t, s, "host=127.0.0.1")
+	assert.Contains(t, s, "readTimeout=5s")
+
+	config.ReadTimeout = 30
+	assert.Contains(t, config.String(), "readTimeout=30s", "legacy seconds")
+}
@@ -0,0 +1,43 @@
+package mongoadapter
+
+import (
+	"context"
+)
+
+type contextKey int
+
+const (
+	actorKey contextKey = iota
+	sessionKey
+	tenantKey
+)
+
+// Context makes the operations of the view derive their contexts (and
+// their timeouts) from ctx, so cancelling ctx aborts them and values like
+// the actor set by WithActor() reach the adapter:
+// m.With(Context(r.Context())).DeleteOne(...)
+func Context(ctx context.Context) Option {
+	return func(m *Mongo) {
+		m.ctx = ctx
+	}
+}
+
+// context returns the parent context of the view's operations
+func (m *Mongo) context() context.Context {
+	if m.ctx != nil {
+		return m.ctx
+	}
+	return context.Background()
+}
+
+// WithActor returns a copy of ctx carrying the identity of whoever performs
+// the operations, it is recorded by the adapter where relevant, e.g. as deletedBy
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey, actor)
+}
+
+// ActorFromContext returns the actor set by WithActor(), empty if there is none
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey).(string)
+	return actor
+}
@@ -0,0 +1,269 @@
+package mongoadapter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrNotLeader is returned by renewals of a candidate that lost its leadership
+var ErrNotLeader = errors.New("candidate is not the leader")
+
+// ElectionOptions configures NewElection()
+type ElectionOptions struct {
+	// Collection keeps one document per election, defaults to "leaders"
+	Collection string
+	// ID identifies the candidate, defaults to a random id
+	ID string
+	// TTL is how long a leadership lasts without being renewed, defaults to 15 seconds
+	TTL time.Duration
+	// RenewInterval is how often the leader renews, defaults to a third of TTL
+	RenewInterval time.Duration
+	// RetryInterval is how often followers try to take over, defaults to RenewInterval
+	RetryInterval time.Duration
+	// MaxClockSkew is the largest expected difference between the clocks of
+	// the replicas, defaults to a second. The leader steps down that much
+	// before its lease expires and followers take over that much after it.
+	MaxClockSkew time.Duration
+	// OnElected is called in its own goroutine when the candidate becomes the
+	// leader, ctx is cancelled as soon as the leadership is lost
+	OnElected func(ctx context.Context)
+	// OnDemoted is called when the candidate stops being the leader
+	OnDemoted func()
+}
+
+// Election lets replicas of a service elect a single leader through a
+// lease document. Every leadership gets a new Term, which can be used as a
+// fencing token like Lease.Token.
+type Election struct {
+	m       *Mongo
+	db      string
+	name    string
+	options ElectionOptions
+
+	mu       sync.Mutex
+	term     int64
+	deadline time.Time
+	cancel   context.CancelFunc
+}
+
+type electionDocument struct {
+	Name      string    `bson:"_id"`
+	Leader    string    `bson:"leader"`
+	Term      int64     `bson:"term"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+// NewElection returns a candidate for the named election on the given database
+func NewElection(m *Mongo, db, name string, opts ElectionOptions) (*Election, error) {
+	if opts.Collection == "" {
+		opts.Collection = "leaders"
+	}
+	if opts.ID == "" {
+		opts.ID = xid.New().String()
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = 15 * time.Second
+	}
+	if opts.MaxClockSkew <= 0 {
+		opts.MaxClockSkew = time.Second
+	}
+	if opts.RenewInterval <= 0 {
+		opts.RenewInterval = opts.TTL / 3
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = opts.RenewInterval
+	}
+	if opts.RenewInterval >= opts.TTL-opts.MaxClockSkew {
+		return nil, errors.New("election renew interval must be shorter than TTL minus MaxClockSkew")
+	}
+	return &Election{m: m, db: db, name: name, options: opts}, nil
+}
+
+// ID returns the id of the candidate
+func (e *Election) ID() string {
+	return e.options.ID
+}
+
+// IsLeader tells whether the candidate currently holds the leadership. It
+// turns false on its own once the lease is too close to expiry, even if the
+// database can't be reached to learn about it.
+func (e *Election) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.cancel != nil && time.Now().Before(e.deadline)
+}
+
+// Term returns the term of the current leadership, or 0 if the candidate is not the leader
+func (e *Election) Term() int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.cancel == nil {
+		return 0
+	}
+	return e.term
+}
+
+// Leader returns the id and term of the current leader, mongo.ErrNoDocuments
+// is returned if there is none
+func (e *Election) Leader(ctx context.Context) (string, int64, error) {
+	var doc electionDocument
+	err := e.m.collection(e.db, e.options.Collection).FindOne(ctx, bson.M{
+		"_id":       e.name,
+		"expiresAt": bson.M{"$gt": time.Now().UTC()},
+	}).Decode(&doc)
+	if err != nil {
+		return "", 0, err
+	}
+	return doc.Leader, doc.Term, nil
+}
+
+// Run campaigns for the leadership and renews it once elected, until ctx is
+// cancelled. The leadership is then given up so that another candidate can
+// take over without waiting for the TTL.
+func (e *Election) Run(ctx context.Context) error {
+	defer e.resign()
+	for {
+		var wait = e.options.RetryInterval
+		if e.leading() {
+			e.renew(ctx)
+		} else {
+			e.campaign(ctx)
+		}
+		if e.leading() {
+			wait = e.options.RenewInterval
+			e.mu.Lock()
+			if left := time.Until(e.deadline); left < wait {
+				wait = left
+			}
+			e.mu.Unlock()
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (e *Election) leading() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.cancel != nil
+}
+
+// campaign takes the leadership over if it is free, or if it expired with
+// a margin for the skew between the clock of the previous leader and ours
+func (e *Election) campaign(ctx context.Context) {
+	var start = time.Now()
+	var now = start.UTC()
+	var filter = bson.M{"_id": e.name, "expiresAt": bson.M{"$lte": now.Add(-e.options.MaxClockSkew)}}
+	var update = bson.M{
+		"$set": bson.M{"leader": e.options.ID, "expiresAt": now.Add(e.options.TTL)},
+		"$inc": bson.M{"term": int64(1)},
+	}
+	var doc electionDocument
+	err := e.m.collection(e.db, e.options.Collection).FindOneAndUpdate(ctx, filter, update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&doc)
+	if err != nil {
+		// a duplicate key means another candidate is the leader
+		return
+	}
+	e.elect(ctx, doc.Term, start)
+}
+
+// renew extends the leadership. On failure the candidate keeps believing it
+// is the leader only until its local deadline, which is computed from the
+// time the last successful renewal was sent.
+func (e *Election) renew(ctx context.Context) {
+	var start = time.Now()
+	var now = start.UTC()
+	e.mu.Lock()
+	var term, deadline = e.term, e.deadline
+	e.mu.Unlock()
+
+	renewCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	res, err := e.m.collection(e.db, e.options.Collection).UpdateOne(renewCtx,
+		bson.M{"_id": e.name, "leader": e.options.ID, "term": term},
+		bson.M{"$set": bson.M{"expiresAt": now.Add(e.options.TTL)}})
+	if err == nil && res.MatchedCount == 0 {
+		err = ErrNotLeader
+	}
+	if err == nil {
+		e.mu.Lock()
+		e.deadline = start.Add(e.options.TTL - e.options.MaxClockSkew)
+		e.mu.Unlock()
+		return
+	}
+	if err == ErrNotLeader || !time.Now().Before(deadline) {
+		e.demote()
+	}
+}
+
+func (e *Election) elect(ctx context.Context, term int64, start time.Time) {
+	leaderCtx, cancel := context.WithCancel(ctx)
+	var deadline = start.Add(e.options.TTL - e.options.MaxClockSkew)
+	e.mu.Lock()
+	e.term, e.deadline, e.cancel = term, deadline, cancel
+	e.mu.Unlock()
+	// the deadline cancels the leader's work even if Run() is stuck on a
+	// renewal that never returns
+	go func() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		for {
+			select {
+			case <-leaderCtx.Done():
+				return
+			case <-timer.C:
+				e.mu.Lock()
+				var left = time.Until(e.deadline)
+				e.mu.Unlock()
+				if left <= 0 {
+					e.demote()
+					return
+				}
+				timer.Reset(left)
+			}
+		}
+	}()
+	if e.options.OnElected != nil {
+		go e.options.OnElected(leaderCtx)
+	}
+}
+
+func (e *Election) demote() {
+	e.mu.Lock()
+	var cancel = e.cancel
+	e.cancel = nil
+	e.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	if e.options.OnDemoted != nil {
+		e.options.OnDemoted()
+	}
+}
+
+func (e *Election) resign() {
+	e.mu.Lock()
+	var term, leading = e.term, e.cancel != nil
+	e.mu.Unlock()
+	if !leading {
+		return
+	}
+	e.demote()
+	ctx, cancel := context.WithTimeout(context.Background(), e.m.writeTimeout)
+	defer cancel()
+	_, _ = e.m.collection(e.db, e.options.Collection).UpdateOne(ctx,
+		bson.M{"_id": e.name, "leader": e.options.ID, "term": term},
+		bson.M{"$set": bson.M{"expiresAt": time.Unix(0, 0).UTC()}})
+}
@@ -0,0 +1,100 @@
+package mongoadapter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestElection_Run(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	_ = m.conn.Database(mongoDatabase).Collection("testLeaders").Drop(context.Background())
+	var opts = ElectionOptions{
+		Collection:    "testLeaders",
+		TTL:           300 * time.Millisecond,
+		MaxClockSkew:  50 * time.Millisecond,
+		RenewInterval: 50 * time.Millisecond,
+	}
+
+	var elected = make(chan string, 2)
+	var candidates []*Election
+	var cancels []context.CancelFunc
+	for _, id := range []string{"a", "b"} {
+		id := id
+		opts.ID = id
+		opts.OnElected = func(ctx context.Context) { elected <- id }
+		election, err := NewElection(m, mongoDatabase, "consumer", opts)
+		assert.Nil(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() { _ = election.Run(ctx) }()
+		candidates = append(candidates, election)
+		cancels = append(cancels, cancel)
+	}
+
+	var first = <-elected
+	time.Sleep(500 * time.Millisecond)
+	assert.Len(t, elected, 0)
+	leader, term, err := candidates[0].Leader(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, first, leader)
+	assert.Equal(t, int64(1), term)
+
+	// the leader steps down and the other candidate takes over
+	var index = 0
+	if first == "b" {
+		index = 1
+	}
+	assert.True(t, candidates[index].IsLeader())
+	cancels[index]()
+	select {
+	case second := <-elected:
+		assert.NotEqual(t, first, second)
+		assert.Equal(t, int64(2), candidates[1-index].Term())
+	case <-time.After(time.Second):
+		t.Error("no candidate took over")
+	}
+	assert.False(t, candidates[index].IsLeader())
+	cancels[1-index]()
+}
+
+func TestElection_mustStepDownWhenRenewalFails(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	_ = m.conn.Database(mongoDatabase).Collection("testLeaders").Drop(context.Background())
+	var demoted = make(chan struct{})
+	var lost = make(chan struct{})
+	election, err := NewElection(m, mongoDatabase, "stolen", ElectionOptions{
+		Collection:    "testLeaders",
+		TTL:           300 * time.Millisecond,
+		MaxClockSkew:  50 * time.Millisecond,
+		RenewInterval: 50 * time.Millisecond,
+		OnElected: func(ctx context.Context) {
+			<-ctx.Done()
+			close(lost)
+		},
+		OnDemoted: func() { close(demoted) },
+	})
+	assert.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = election.Run(ctx) }()
+
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, election.IsLeader())
+	_, err = m.conn.Database(mongoDatabase).Collection("testLeaders").UpdateOne(context.Background(),
+		bson.M{"_id": "stolen"}, bson.M{"$set": bson.M{"leader": "someone-else"}, "$inc": bson.M{"term": 1}})
+	assert.Nil(t, err)
+	select {
+	case <-demoted:
+		<-lost
+	case <-time.After(time.Second):
+		t.Error("leader was not demoted")
+	}
+}
+
+func TestNewElection_mustRejectRenewIntervalAboveTTL(t *testing.T) {
+	_, err := NewElection(nil, "", "", ElectionOptions{TTL: time.Second, RenewInterval: time.Second})
+	assert.Error(t, err)
+}
@@ -0,0 +1,590 @@
+package mongoadapter
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Encryption modes of the `encrypt` struct tag
+const (
+	// EncryptRandom uses a random nonce, equal values give different ciphertexts
+	EncryptRandom = "random"
+	// EncryptDeterministic derives the nonce from the value, so that equal
+	// values give equal ciphertexts and can be matched with MatchEncrypted()
+	EncryptDeterministic = "deterministic"
+)
+
+// encryptedSubtype is the binary subtype of encrypted values
+const encryptedSubtype = 6
+
+// first byte of the encrypted values, it tells them apart from the values
+// encrypted by the driver, which use the same subtype
+const (
+	ciphertextRandom        = 0xA1
+	ciphertextDeterministic = 0xA2
+)
+
+// ErrNoKeyProvider is returned when encrypted fields are written or read
+// before UseKeyProvider() was called
+var ErrNoKeyProvider = errors.New("no encryption key provider, see UseKeyProvider()")
+
+// KeyProvider gives the AES keys (16, 24 or 32 bytes) used to encrypt
+// fields. Every ciphertext records the id of its key, so old keys must stay
+// available until RotateEncryption() re-encrypted their values.
+type KeyProvider interface {
+	// CurrentKey returns the key new values are encrypted with
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the key with the given id
+	Key(id string) ([]byte, error)
+	// KeyIDs returns the ids of all the keys, current one included
+	KeyIDs() ([]string, error)
+}
+
+type staticKeys struct {
+	current string
+	keys    map[string][]byte
+}
+
+// StaticKeys returns a KeyProvider serving the given keys by id, new values
+// are encrypted with the current one
+func StaticKeys(current string, keys map[string][]byte) KeyProvider {
+	return &staticKeys{current: current, keys: keys}
+}
+
+func (p *staticKeys) CurrentKey() (string, []byte, error) {
+	key, err := p.Key(p.current)
+	return p.current, key, err
+}
+
+func (p *staticKeys) Key(id string) ([]byte, error) {
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, errors.New("unknown encryption key " + strconv.Quote(id))
+	}
+	return key, nil
+}
+
+func (p *staticKeys) KeyIDs() ([]string, error) {
+	var ids = make([]string, 0, len(p.keys))
+	for id := range p.keys {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// encryptionSettings are the encrypted fields of a collection by dotted path
+type encryptionSettings struct {
+	fields map[string]string
+}
+
+// UseKeyProvider sets the keys used by the encrypted collections of the
+// instance and its views
+func (m *Mongo) UseKeyProvider(p KeyProvider) {
+	m.registry.Lock()
+	defer m.registry.Unlock()
+	m.registry.keys = p
+}
+
+func (m *Mongo) keyProvider() (KeyProvider, error) {
+	m.registry.RLock()
+	defer m.registry.RUnlock()
+	if m.registry.keys == nil {
+		return nil, ErrNoKeyProvider
+	}
+	return m.registry.keys, nil
+}
+
+// EnableEncryption encrypts, on insert, update and replace, the fields of
+// the collection tagged in the prototype struct, e.g.
+//
+//	type User struct {
+//		Name   string `bson:"name"`
+//		Email  string `bson:"email" encrypt:"deterministic"`
+//		Mobile string `bson:"mobile" encrypt:"random"`
+//	}
+//	err := m.EnableEncryption(db, "users", User{})
+//
+// Fields of embedded and inlined structs are found as well. Fields of the
+// elements of slices, arrays and maps can't be encrypted one by one, an
+// error is returned for them: tag the whole slice instead.
+//
+// Reads are not decrypted implicitly: FindOne(), FindMany(), Search() and
+// Aggregate() return the ciphertext, and documents are decrypted on decode
+// by FindOneDecrypted() or Decrypt(). This keeps the plaintext out of code
+// paths that never asked for it, such as exports and the audit trail.
+func (m *Mongo) EnableEncryption(db, coll string, prototype interface{}) error {
+	var fields = map[string]string{}
+	if err := encryptedFields(reflect.TypeOf(prototype), "", fields); err != nil {
+		return err
+	}
+	if len(fields) == 0 {
+		return errors.New("the prototype has no field tagged with encrypt")
+	}
+	m.updateSettings(db, coll, func(s *collectionSettings) {
+		s.encryption = &encryptionSettings{fields: fields}
+	})
+	return nil
+}
+
+// DisableEncryption stops encrypting the fields of the collection, values
+// that are already encrypted stay so
+func (m *Mongo) DisableEncryption(db, coll string) {
+	m.updateSettings(db, coll, func(s *collectionSettings) {
+		s.encryption = nil
+	})
+}
+
+func encryptedFields(t reflect.Type, prefix string, fields map[string]string) error {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return errors.New("encryption prototype must be a struct")
+	}
+	for i := 0; i < t.NumField(); i++ {
+		var field = t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		var tag = strings.Split(field.Tag.Get("bson"), ",")
+		var name = tag[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		var path = prefix + name + "."
+		for _, option := range tag[1:] {
+			if option == "inline" {
+				// the fields of an inlined struct are stored at its level
+				path = prefix
+			}
+		}
+		switch mode := field.Tag.Get("encrypt"); mode {
+		case EncryptRandom, EncryptDeterministic:
+			fields[prefix+name] = mode
+			continue
+		case "":
+		default:
+			return errors.New("invalid encrypt tag " + strconv.Quote(mode) + " on " + field.Name)
+		}
+		var ft = field.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		switch ft.Kind() {
+		case reflect.Struct:
+			if ft == reflect.TypeOf(time.Time{}) {
+				continue
+			}
+			if err := encryptedFields(ft, path, fields); err != nil {
+				return err
+			}
+		case reflect.Slice, reflect.Array, reflect.Map:
+			// the element paths aren't known before the values are,
+			// so they would silently be stored in the clear
+			if hasEncryptTag(ft.Elem(), map[reflect.Type]bool{}) {
+				return errors.New("encrypted fields in the elements of " + field.Name + " are not supported, tag the whole field instead")
+			}
+		}
+	}
+	return nil
+}
+
+// hasEncryptTag tells if a field reachable from t is tagged with encrypt
+func hasEncryptTag(t reflect.Type, seen map[reflect.Type]bool) bool {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return false
+	}
+	seen[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		var field = t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		if field.Tag.Get("encrypt") != "" || hasEncryptTag(field.Type, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// encryptDocument encrypts the fields of a document about to be stored
+func (m *Mongo) encryptDocument(e *encryptionSettings, v interface{}) (interface{}, error) {
+	doc, err := copyDocument(v)
+	if err != nil {
+		return nil, err
+	}
+	for path, mode := range e.fields {
+		value, ok := lookupPath(doc, path)
+		if !ok || value == nil {
+			continue
+		}
+		encrypted, err := m.encryptValue(value, mode)
+		if err != nil {
+			return nil, err
+		}
+		doc = setPath(doc, path, encrypted)
+	}
+	return doc, nil
+}
+
+// encryptUpdate encrypts the fields set by the $set and $setOnInsert
+// operators of an update, whether they are set by path or as a part of an
+// embedded document
+func (m *Mongo) encryptUpdate(e *encryptionSettings, update interface{}) (interface{}, error) {
+	if isUpdatePipeline(update) {
+		// the stages compute their values on the server, out of reach
+		return nil, errors.New("update pipelines can't be used on encrypted collections, use update operators")
+	}
+	doc, err := toDocument(update)
+	if err != nil {
+		return nil, err
+	}
+	for i, op := range doc {
+		if op.Key != "$set" && op.Key != "$setOnInsert" {
+			continue
+		}
+		operatorDoc, err := toDocument(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		for j, field := range operatorDoc {
+			for path, mode := range e.fields {
+				switch {
+				case path == field.Key && field.Value != nil:
+					if operatorDoc[j].Value, err = m.encryptValue(field.Value, mode); err != nil {
+						return nil, err
+					}
+				case strings.HasPrefix(path, field.Key+"."):
+					embedded, err := copyDocument(field.Value)
+					if err != nil {
+						continue
+					}
+					var rest = strings.TrimPrefix(path, field.Key+".")
+					value, ok := lookupPath(embedded, rest)
+					if !ok || value == nil {
+						continue
+					}
+					encrypted, err := m.encryptValue(value, mode)
+					if err != nil {
+						return nil, err
+					}
+					operatorDoc[j].Value = setPath(embedded, rest, encrypted)
+					field.Value = operatorDoc[j].Value
+				}
+			}
+		}
+		doc[i].Value = operatorDoc
+	}
+	return doc, nil
+}
+
+// copyDocument is toDocument() copying the embedded documents as well, so
+// that encrypting them leaves the caller's values untouched
+func copyDocument(v interface{}) (bson.D, error) {
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil, errors.New("failed to convert the value into a document, got error: " + err.Error())
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, errors.New("failed to convert the value into a document, got error: " + err.Error())
+	}
+	return doc, nil
+}
+
+// encryptValue encrypts value with the current key. The ciphertext is
+// mode | key id length | key id | nonce | AES-GCM(bson type | bson value).
+func (m *Mongo) encryptValue(value interface{}, mode string) (primitive.Binary, error) {
+	provider, err := m.keyProvider()
+	if err != nil {
+		return primitive.Binary{}, err
+	}
+	id, key, err := provider.CurrentKey()
+	if err != nil {
+		return primitive.Binary{}, err
+	}
+	plaintext, err := marshalValue(value)
+	if err != nil {
+		return primitive.Binary{}, err
+	}
+	return sealValue(id, key, mode, plaintext)
+}
+
+func sealValue(id string, key []byte, mode string, plaintext []byte) (primitive.Binary, error) {
+	if len(id) > 255 {
+		return primitive.Binary{}, errors.New("encryption key id is too long")
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return primitive.Binary{}, err
+	}
+	var nonce = make([]byte, aead.NonceSize())
+	var header = []byte{ciphertextRandom}
+	if mode == EncryptDeterministic {
+		// synthetic nonce, keyed so that it reveals nothing but equality
+		mac := hmac.New(sha256.New, deriveKey(key, "nonce"))
+		_, _ = mac.Write(plaintext)
+		copy(nonce, mac.Sum(nil))
+		header[0] = ciphertextDeterministic
+	} else if _, err := rand.Read(nonce); err != nil {
+		return primitive.Binary{}, err
+	}
+	header = append(header, byte(len(id)))
+	header = append(header, id...)
+	header = append(header, nonce...)
+	// the header is authenticated so the key id and mode can't be swapped
+	var data = aead.Seal(header, nonce, plaintext, header)
+	return primitive.Binary{Subtype: encryptedSubtype, Data: data}, nil
+}
+
+// openValue decrypts a ciphertext, ok is false if data was not encrypted by the adapter
+func openValue(provider KeyProvider, data []byte) (value bson.RawValue, keyID string, mode string, ok bool, err error) {
+	if len(data) < 2 || (data[0] != ciphertextRandom && data[0] != ciphertextDeterministic) {
+		return bson.RawValue{}, "", "", false, nil
+	}
+	mode = EncryptRandom
+	if data[0] == ciphertextDeterministic {
+		mode = EncryptDeterministic
+	}
+	var idEnd = 2 + int(data[1])
+	if len(data) < idEnd {
+		return bson.RawValue{}, "", "", true, errors.New("truncated encrypted value")
+	}
+	keyID = string(data[2:idEnd])
+	key, err := provider.Key(keyID)
+	if err != nil {
+		return bson.RawValue{}, keyID, mode, true, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return bson.RawValue{}, keyID, mode, true, err
+	}
+	var nonceEnd = idEnd + aead.NonceSize()
+	if len(data) < nonceEnd {
+		return bson.RawValue{}, keyID, mode, true, errors.New("truncated encrypted value")
+	}
+	plaintext, err := aead.Open(nil, data[idEnd:nonceEnd], data[nonceEnd:], data[:nonceEnd])
+	if err != nil {
+		return bson.RawValue{}, keyID, mode, true, errors.New("failed to decrypt a value, got error: " + err.Error())
+	}
+	if len(plaintext) == 0 {
+		return bson.RawValue{}, keyID, mode, true, errors.New("empty encrypted value")
+	}
+	return bson.RawValue{Type: bsontype.Type(plaintext[0]), Value: plaintext[1:]}, keyID, mode, true, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// marshalValue returns the bson type followed by the bson encoding of value
+func marshalValue(value interface{}) ([]byte, error) {
+	data, err := bson.Marshal(bson.D{{Key: "v", Value: value}})
+	if err != nil {
+		return nil, err
+	}
+	var raw = bson.Raw(data).Lookup("v")
+	return append([]byte{byte(raw.Type)}, raw.Value...), nil
+}
+
+// MatchEncrypted returns a condition matching a deterministically
+// encrypted field equal to value, whichever key encrypted it, e.g.
+// m.FindOneDecrypted(db, "users", bson.M{"email": match}, &user)
+func (m *Mongo) MatchEncrypted(value interface{}) (bson.M, error) {
+	provider, err := m.keyProvider()
+	if err != nil {
+		return nil, err
+	}
+	ids, err := provider.KeyIDs()
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := marshalValue(value)
+	if err != nil {
+		return nil, err
+	}
+	var candidates = bson.A{}
+	for _, id := range ids {
+		key, err := provider.Key(id)
+		if err != nil {
+			return nil, err
+		}
+		ciphertext, err := sealValue(id, key, EncryptDeterministic, plaintext)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, ciphertext)
+	}
+	return bson.M{"$in": candidates}, nil
+}
+
+// Decrypt decrypts the encrypted values of a raw document, e.g. the
+// Current document of a cursor, and unmarshals it into v
+func (m *Mongo) Decrypt(raw bson.Raw, v interface{}) error {
+	provider, err := m.keyProvider()
+	if err != nil {
+		return err
+	}
+	doc, err := decryptDocument(provider, raw)
+	if err != nil {
+		return err
+	}
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(data, v)
+}
+
+// FindOneDecrypted decodes the first document matching filter into result
+// after decrypting it, mongo.ErrNoDocuments is returned if there is none
+func (m *Mongo) FindOneDecrypted(db, coll string, filter interface{}, result interface{}, opts ...*options.FindOneOptions) error {
+	raw, err := m.FindOne(db, coll, filter, opts...).DecodeBytes()
+	if err != nil {
+		return err
+	}
+	return m.Decrypt(raw, result)
+}
+
+func decryptDocument(provider KeyProvider, raw bson.Raw) (bson.D, error) {
+	elements, err := raw.Elements()
+	if err != nil {
+		return nil, err
+	}
+	var doc = make(bson.D, len(elements))
+	for i, e := range elements {
+		value, err := decryptRawValue(provider, e.Value())
+		if err != nil {
+			return nil, errors.New("field " + e.Key() + ": " + err.Error())
+		}
+		doc[i] = bson.E{Key: e.Key(), Value: value}
+	}
+	return doc, nil
+}
+
+func decryptRawValue(provider KeyProvider, v bson.RawValue) (interface{}, error) {
+	switch v.Type {
+	case bsontype.Binary:
+		subtype, data := v.Binary()
+		if subtype != encryptedSubtype {
+			return v, nil
+		}
+		value, _, _, ok, err := openValue(provider, data)
+		if !ok || err != nil {
+			return v, err
+		}
+		return decryptRawValue(provider, value)
+	case bsontype.EmbeddedDocument:
+		return decryptDocument(provider, v.Document())
+	case bsontype.Array:
+		values, err := v.Array().Values()
+		if err != nil {
+			return nil, err
+		}
+		var array = make(bson.A, len(values))
+		for i, item := range values {
+			if array[i], err = decryptRawValue(provider, item); err != nil {
+				return nil, err
+			}
+		}
+		return array, nil
+	}
+	return v, nil
+}
+
+// RotateEncryption re-encrypts with the current key the encrypted fields
+// of the collection that were encrypted with another key, and returns the
+// number of documents updated. Documents are updated in place, without
+// changing their timestamps or version.
+func (m *Mongo) RotateEncryption(db, coll string) (int64, error) {
+	var s = m.settings(db, coll)
+	if s.encryption == nil {
+		return 0, nil
+	}
+	provider, err := m.keyProvider()
+	if err != nil {
+		return 0, err
+	}
+	currentID, currentKey, err := provider.CurrentKey()
+	if err != nil {
+		return 0, err
+	}
+	defer m.invalidateCache(db, coll)
+	var ctx = m.context()
+	cur, err := m.collection(db, coll).Find(ctx, bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+
+	var updated int64
+	for cur.Next(ctx) {
+		var filter = bson.D{{Key: "_id", Value: cur.Current.Lookup("_id")}}
+		var set = bson.D{}
+		for path := range s.encryption.fields {
+			value, err := cur.Current.LookupErr(strings.Split(path, ".")...)
+			if err != nil || value.Type != bsontype.Binary {
+				continue
+			}
+			subtype, data := value.Binary()
+			if subtype != encryptedSubtype {
+				continue
+			}
+			plain, keyID, mode, ok, err := openValue(provider, data)
+			if err != nil {
+				return updated, err
+			}
+			if !ok || keyID == currentID {
+				continue
+			}
+			ciphertext, err := sealValue(currentID, currentKey, mode, append([]byte{byte(plain.Type)}, plain.Value...))
+			if err != nil {
+				return updated, err
+			}
+			// the old ciphertext in the filter protects concurrent writes
+			filter = append(filter, bson.E{Key: path, Value: value})
+			set = append(set, bson.E{Key: path, Value: ciphertext})
+		}
+		if len(set) == 0 {
+			continue
+		}
+		writeCtx, cancel := context.WithTimeout(ctx, m.writeTimeout)
+		res, err := m.collection(db, coll).UpdateOne(writeCtx, filter, bson.D{{Key: "$set", Value: set}})
+		cancel()
+		if err != nil {
+			return updated, err
+		}
+		updated += res.ModifiedCount
+	}
+	return updated, cur.Err()
+}
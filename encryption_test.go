@@ -0,0 +1,166 @@
+package mongoadapter
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type encryptedUser struct {
+	Name    string `bson:"name"`
+	Email   string `bson:"email" encrypt:"deterministic"`
+	Contact struct {
+		Phone string `bson:"phone" encrypt:"random"`
+	} `bson:"contact"`
+}
+
+var testKeys = map[string][]byte{
+	"old": []byte("0123456789abcdef0123456789abcdef"),
+	"new": []byte("fedcba9876543210fedcba9876543210"),
+}
+
+func TestSealValue(t *testing.T) {
+	var provider = StaticKeys("new", testKeys)
+	plaintext, err := marshalValue("sara@example.com")
+	assert.Nil(t, err)
+
+	a, err := sealValue("new", testKeys["new"], EncryptDeterministic, plaintext)
+	assert.Nil(t, err)
+	b, err := sealValue("new", testKeys["new"], EncryptDeterministic, plaintext)
+	assert.Nil(t, err)
+	assert.Equal(t, a, b, "deterministic ciphertexts of equal values must match")
+	assert.Equal(t, byte(encryptedSubtype), a.Subtype)
+
+	c, err := sealValue("new", testKeys["new"], EncryptRandom, plaintext)
+	assert.Nil(t, err)
+	d, err := sealValue("new", testKeys["new"], EncryptRandom, plaintext)
+	assert.Nil(t, err)
+	assert.NotEqual(t, c.Data, d.Data)
+
+	value, keyID, mode, ok, err := openValue(provider, c.Data)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "new", keyID)
+	assert.Equal(t, EncryptRandom, mode)
+	assert.Equal(t, "sara@example.com", value.StringValue())
+
+	c.Data[len(c.Data)-1] ^= 1
+	_, _, _, _, err = openValue(provider, c.Data)
+	assert.Error(t, err, "tampered ciphertexts must be rejected")
+	_, _, _, ok, _ = openValue(provider, []byte{4, 0, 1, 2})
+	assert.False(t, ok, "foreign subtype 6 values must be left alone")
+}
+
+type inlinedContact struct {
+	Email string `bson:"email" encrypt:"deterministic"`
+}
+
+func TestEncryptedFields_inline(t *testing.T) {
+	var fields = map[string]string{}
+	var prototype struct {
+		Name    string         `bson:"name"`
+		Contact inlinedContact `bson:",inline"`
+	}
+	assert.Nil(t, encryptedFields(reflect.TypeOf(prototype), "", fields))
+	assert.Equal(t, map[string]string{"email": EncryptDeterministic}, fields)
+
+	var m = &Mongo{registry: newSettingsRegistry()}
+	m.UseKeyProvider(StaticKeys("new", testKeys))
+	prototype.Name, prototype.Contact.Email = "sara", "sara@example.com"
+	doc, err := m.encryptDocument(&encryptionSettings{fields: fields}, prototype)
+	assert.Nil(t, err)
+	email, _ := lookup(doc.(bson.D), "email")
+	assert.IsType(t, primitive.Binary{}, email, "inlined fields must be encrypted")
+}
+
+type encryptedNode struct {
+	Name     string          `bson:"name"`
+	Children []encryptedNode `bson:"children"`
+}
+
+func TestEncryptedFields_elements(t *testing.T) {
+	var fields = map[string]string{}
+	var contacts struct {
+		Contacts []*inlinedContact `bson:"contacts"`
+	}
+	assert.NotNil(t, encryptedFields(reflect.TypeOf(contacts), "", fields), "element fields must not be stored in the clear")
+
+	var whole struct {
+		Contacts []inlinedContact `bson:"contacts" encrypt:"random"`
+	}
+	assert.Nil(t, encryptedFields(reflect.TypeOf(whole), "", fields))
+	assert.Equal(t, map[string]string{"contacts": EncryptRandom}, fields)
+
+	var tree struct {
+		Email string        `bson:"email" encrypt:"random"`
+		Root  encryptedNode `bson:"root"`
+	}
+	assert.Nil(t, encryptedFields(reflect.TypeOf(tree), "", map[string]string{}), "recursive types must not loop")
+}
+
+func TestEncryptUpdate(t *testing.T) {
+	var fields = map[string]string{}
+	assert.Nil(t, encryptedFields(reflect.TypeOf(encryptedUser{}), "", fields))
+	assert.Equal(t, map[string]string{"email": EncryptDeterministic, "contact.phone": EncryptRandom}, fields)
+
+	m := &Mongo{registry: newSettingsRegistry()}
+	m.UseKeyProvider(StaticKeys("new", testKeys))
+	var contact = bson.D{{Key: "phone", Value: "555"}}
+	update, err := m.encryptUpdate(&encryptionSettings{fields: fields}, bson.D{
+		{Key: "$set", Value: bson.D{{Key: "email", Value: "a@b.c"}, {Key: "contact", Value: contact}, {Key: "name", Value: "sara"}}},
+	})
+	assert.Nil(t, err)
+	var set = update.(bson.D)[0].Value.(bson.D)
+	assert.IsType(t, primitive.Binary{}, set[0].Value)
+	assert.IsType(t, primitive.Binary{}, set[1].Value.(bson.D)[0].Value)
+	assert.Equal(t, "sara", set[2].Value)
+	assert.Equal(t, "555", contact[0].Value, "the caller's document must not change")
+}
+
+func TestMongo_EnableEncryption(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	var coll = "encrypted"
+	_ = m.conn.Database(mongoDatabase).Collection(coll).Drop(context.Background())
+	m.UseKeyProvider(StaticKeys("old", testKeys))
+	assert.Nil(t, m.EnableEncryption(mongoDatabase, coll, encryptedUser{}))
+	defer m.DisableEncryption(mongoDatabase, coll)
+
+	var user encryptedUser
+	user.Name, user.Email, user.Contact.Phone = "sara", "sara@example.com", "555"
+	_, err := m.InsertOne(mongoDatabase, coll, user)
+	assert.Nil(t, err)
+
+	raw, err := m.FindOne(mongoDatabase, coll, bson.M{"name": "sara"}).DecodeBytes()
+	assert.Nil(t, err)
+	assert.Equal(t, bson.TypeBinary, raw.Lookup("email").Type)
+	assert.Equal(t, bson.TypeBinary, raw.Lookup("contact", "phone").Type)
+
+	// rotate, then find by equality through the old and the new key
+	m.UseKeyProvider(StaticKeys("new", testKeys))
+	match, err := m.MatchEncrypted("sara@example.com")
+	assert.Nil(t, err)
+	var found encryptedUser
+	assert.Nil(t, m.FindOneDecrypted(mongoDatabase, coll, bson.M{"email": match}, &found))
+	assert.Equal(t, user, found)
+
+	n, err := m.RotateEncryption(mongoDatabase, coll)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), n)
+	m.UseKeyProvider(StaticKeys("new", map[string][]byte{"new": testKeys["new"]}))
+	match, err = m.MatchEncrypted("sara@example.com")
+	assert.Nil(t, err)
+	found = encryptedUser{}
+	assert.Nil(t, m.FindOneDecrypted(mongoDatabase, coll, bson.M{"email": match}, &found))
+	assert.Equal(t, user, found)
+
+	_, err = m.UpdateOne(mongoDatabase, coll, bson.M{"email": match}, bson.M{"$set": bson.M{"contact.phone": "666"}})
+	assert.Nil(t, err)
+	raw, err = m.FindOne(mongoDatabase, coll, bson.M{"name": "sara"}).DecodeBytes()
+	assert.Nil(t, err)
+	assert.Nil(t, m.Decrypt(raw, &found))
+	assert.Equal(t, "666", found.Contact.Phone)
+}
@@ -0,0 +1,204 @@
+package fixtures
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var words = []string{
+	"alpha", "bravo", "charlie", "delta", "echo", "foxtrot", "golf", "hotel",
+	"india", "juliet", "kilo", "lima", "mike", "november", "oscar", "papa",
+	"quebec", "romeo", "sierra", "tango", "uniform", "victor", "whiskey", "yankee",
+}
+
+var firstNames = []string{"sara", "john", "maria", "ali", "lena", "marco", "yuki", "omar", "anna", "paul"}
+var lastNames = []string{"rossi", "smith", "garcia", "karimi", "muller", "sato", "silva", "novak", "jones", "ricci"}
+
+// Factory fills Go structs with random data. Fields are filled according
+// to their type, and strings according to their name (email, name, phone,
+// url) or to a `fixture` tag: `fixture:"email"`, `fixture:"name"`,
+// `fixture:"word"`, `fixture:"sentence"` or `fixture:"-"` to leave the field alone.
+type Factory struct {
+	rand *rand.Rand
+}
+
+// NewFactory returns a Factory, the same seed gives the same data
+func NewFactory(seed int64) *Factory {
+	return &Factory{rand: rand.New(rand.NewSource(seed))}
+}
+
+// Fill sets the exported fields of the struct v points to
+func (f *Factory) Fill(v interface{}) error {
+	var value = reflect.ValueOf(v)
+	if !value.IsValid() {
+		return errors.New("factory fills pointers to structs, got nil")
+	}
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		return errors.New("factory fills pointers to structs, got " + value.Type().String())
+	}
+	f.fillStruct(value.Elem(), 0)
+	return nil
+}
+
+// Build returns n documents made by newDoc, filled with random data and
+// then passed to customize, if not nil, e.g.
+//
+//	docs, err := factory.Build(100, func() interface{} { return &User{} }, func(i int, doc interface{}) {
+//		doc.(*User).Role = "admin"
+//	})
+func (f *Factory) Build(n int, newDoc func() interface{}, customize func(i int, doc interface{})) ([]interface{}, error) {
+	var docs = make([]interface{}, n)
+	for i := range docs {
+		docs[i] = newDoc()
+		if err := f.Fill(docs[i]); err != nil {
+			return nil, err
+		}
+		if customize != nil {
+			customize(i, docs[i])
+		}
+	}
+	return docs, nil
+}
+
+// Seed inserts n documents built by the factory into coll, in batches of a thousand
+func (l *Loader) Seed(f *Factory, coll string, n int, newDoc func() interface{}, customize func(i int, doc interface{})) error {
+	for start := 0; start < n; start += 1000 {
+		var size = n - start
+		if size > 1000 {
+			size = 1000
+		}
+		docs, err := f.Build(size, newDoc, func(i int, doc interface{}) {
+			if customize != nil {
+				customize(start+i, doc)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := l.m.InsertMany(l.db, coll, docs); err != nil {
+			return err
+		}
+	}
+	l.collections[coll] = true
+	return nil
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+)
+
+// depth limits the recursion of self-referencing types
+func (f *Factory) fillStruct(v reflect.Value, depth int) {
+	var t = v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		var field = t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		var tag = field.Tag.Get("fixture")
+		if tag == "-" {
+			continue
+		}
+		if tag == "" {
+			tag = kindFromName(field.Name)
+		}
+		f.fill(v.Field(i), tag, depth)
+	}
+}
+
+func (f *Factory) fill(v reflect.Value, kind string, depth int) {
+	switch v.Type() {
+	case timeType:
+		// within the last year, at millisecond precision like BSON dates
+		var ago = time.Duration(f.rand.Int63n(int64(365 * 24 * time.Hour)))
+		v.Set(reflect.ValueOf(time.Now().UTC().Add(-ago).Truncate(time.Millisecond)))
+		return
+	case objectIDType:
+		v.Set(reflect.ValueOf(primitive.NewObjectID()))
+		return
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(f.String(kind))
+	case reflect.Bool:
+		v.SetBool(f.rand.Intn(2) == 1)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(f.rand.Int63n(100))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(uint64(f.rand.Int63n(100)))
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(float64(f.rand.Int63n(10000)) / 100)
+	case reflect.Struct:
+		if depth < 3 {
+			f.fillStruct(v, depth+1)
+		}
+	case reflect.Ptr:
+		if depth < 3 {
+			v.Set(reflect.New(v.Type().Elem()))
+			f.fill(v.Elem(), kind, depth+1)
+		}
+	case reflect.Slice:
+		if depth < 3 {
+			var n = 1 + f.rand.Intn(3)
+			v.Set(reflect.MakeSlice(v.Type(), n, n))
+			for i := 0; i < n; i++ {
+				f.fill(v.Index(i), kind, depth+1)
+			}
+		}
+	case reflect.Map:
+		if depth < 3 && v.Type().Key().Kind() == reflect.String {
+			v.Set(reflect.MakeMap(v.Type()))
+			for i := 0; i < 1+f.rand.Intn(3); i++ {
+				var elem = reflect.New(v.Type().Elem()).Elem()
+				f.fill(elem, "", depth+1)
+				v.SetMapIndex(reflect.ValueOf(f.String("word")).Convert(v.Type().Key()), elem)
+			}
+		}
+	}
+}
+
+// String returns a random string of the given kind: email, name, phone,
+// url, word or sentence, anything else gives a word followed by a number
+func (f *Factory) String(kind string) string {
+	var first, last = firstNames[f.rand.Intn(len(firstNames))], lastNames[f.rand.Intn(len(lastNames))]
+	switch kind {
+	case "email":
+		return fmt.Sprintf("%v.%v%d@example.com", first, last, f.rand.Intn(1000))
+	case "name":
+		return strings.Title(first) + " " + strings.Title(last)
+	case "phone":
+		return fmt.Sprintf("+1555%07d", f.rand.Intn(10000000))
+	case "url":
+		return "https://example.com/" + f.String("word")
+	case "word":
+		return words[f.rand.Intn(len(words))]
+	case "sentence":
+		var n = 4 + f.rand.Intn(6)
+		var s = make([]string, n)
+		for i := range s {
+			s[i] = words[f.rand.Intn(len(words))]
+		}
+		return strings.Title(s[0]) + " " + strings.Join(s[1:], " ") + "."
+	}
+	return fmt.Sprintf("%v-%d", words[f.rand.Intn(len(words))], f.rand.Intn(10000))
+}
+
+func kindFromName(name string) string {
+	var lower = strings.ToLower(name)
+	for _, kind := range []string{"email", "phone", "url"} {
+		if strings.Contains(lower, kind) {
+			return kind
+		}
+	}
+	if strings.HasSuffix(lower, "name") {
+		return "name"
+	}
+	return ""
+}
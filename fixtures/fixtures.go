@@ -0,0 +1,335 @@
+// Package fixtures loads test data into Mongo through the adapter.
+//
+// Fixture files are YAML or JSON documents mapping collection names to
+// named documents:
+//
+//	users:
+//	  sara:
+//	    name: sara
+//	    createdAt: "{{ now -48h }}"
+//	posts:
+//	  welcome:
+//	    _id: "{{ objectId }}"
+//	    author: "{{ ref users.sara }}"
+//	    publishedAt: "{{ now +1d }}"
+//
+// String values made of a single {{ }} expression are replaced:
+//
+//	{{ objectId }}         a new ObjectID
+//	{{ now }}              the load time, {{ now -2h30m }} or {{ now +7d }} relative to it
+//	{{ ref coll.name }}    the _id of another fixture, from any file of the same Loader
+//
+// Fixtures without an _id get a new ObjectID, so that they can be referenced.
+package fixtures
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/farzandalaee/mongoadapter"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/yaml.v2"
+)
+
+// Loader inserts fixtures into a database and remembers their ids
+type Loader struct {
+	m   *mongoadapter.Mongo
+	db  string
+	now time.Time
+
+	ids         map[string]interface{}
+	collections map[string]bool
+}
+
+type fixture struct {
+	coll string
+	name string
+	doc  bson.D
+}
+
+// New returns a Loader inserting into db
+func New(m *mongoadapter.Mongo, db string) *Loader {
+	return &Loader{
+		m:           m,
+		db:          db,
+		now:         time.Now().UTC(),
+		ids:         map[string]interface{}{},
+		collections: map[string]bool{},
+	}
+}
+
+// Now sets the time {{ now }} refers to, for reproducible fixtures
+func (l *Loader) Now(now time.Time) *Loader {
+	l.now = now.UTC()
+	return l
+}
+
+// Load reads and inserts the given fixture files, references may point to
+// fixtures of any of them
+func (l *Loader) Load(paths ...string) error {
+	var sources = make([][]byte, len(paths))
+	for i, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		sources[i] = data
+	}
+	return l.LoadBytes(sources...)
+}
+
+// LoadBytes inserts the fixtures of the given YAML or JSON sources
+func (l *Loader) LoadBytes(sources ...[]byte) error {
+	var fixtures []fixture
+	for _, data := range sources {
+		parsed, err := parse(data)
+		if err != nil {
+			return err
+		}
+		fixtures = append(fixtures, parsed...)
+	}
+	// ids are assigned first so that references don't depend on the order
+	for i := range fixtures {
+		var f = &fixtures[i]
+		var key = f.coll + "." + f.name
+		if _, ok := l.ids[key]; ok {
+			return errors.New("duplicate fixture " + strconv.Quote(key))
+		}
+		id, ok := lookup(f.doc, "_id")
+		if !ok {
+			id = primitive.NewObjectID()
+			f.doc = append(bson.D{{Key: "_id", Value: id}}, f.doc...)
+		}
+		rendered, err := l.render(id, key)
+		if err != nil {
+			return err
+		}
+		f.doc[index(f.doc, "_id")].Value = rendered
+		l.ids[key] = rendered
+	}
+
+	var byCollection = map[string][]interface{}{}
+	var order []string
+	for _, f := range fixtures {
+		doc, err := l.render(f.doc, f.coll+"."+f.name)
+		if err != nil {
+			return err
+		}
+		if _, ok := byCollection[f.coll]; !ok {
+			order = append(order, f.coll)
+		}
+		byCollection[f.coll] = append(byCollection[f.coll], doc)
+	}
+	for _, coll := range order {
+		l.collections[coll] = true
+		if _, err := l.m.InsertMany(l.db, coll, byCollection[coll]); err != nil {
+			return fmt.Errorf("failed to insert the fixtures of %v, got error: %v", coll, err)
+		}
+	}
+	return nil
+}
+
+// ID returns the _id of a loaded fixture named "coll.name", nil if there is none
+func (l *Loader) ID(ref string) interface{} {
+	return l.ids[ref]
+}
+
+// ObjectID returns the _id of a loaded fixture that has an ObjectID
+func (l *Loader) ObjectID(ref string) primitive.ObjectID {
+	id, _ := l.ids[ref].(primitive.ObjectID)
+	return id
+}
+
+// Collections returns the names of the collections fixtures were loaded into
+func (l *Loader) Collections() []string {
+	var names = make([]string, 0, len(l.collections))
+	for name := range l.collections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Truncate removes every document of the given collections, or of the
+// collections fixtures were loaded into, and forgets the loaded ids. The
+// documents are removed with the driver so that soft delete does not apply,
+// and indexes are kept.
+func (l *Loader) Truncate(colls ...string) error {
+	if len(colls) == 0 {
+		colls = l.Collections()
+	}
+	for _, coll := range colls {
+		_, err := l.m.GetConn().Database(l.db).Collection(coll).DeleteMany(context.Background(), bson.M{})
+		if err != nil {
+			return err
+		}
+		delete(l.collections, coll)
+		for key := range l.ids {
+			if strings.HasPrefix(key, coll+".") {
+				delete(l.ids, key)
+			}
+		}
+	}
+	return nil
+}
+
+// parse reads a fixture source, JSON being valid YAML. Key order is kept.
+func parse(data []byte) ([]fixture, error) {
+	var root yaml.MapSlice
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	var fixtures []fixture
+	for _, coll := range root {
+		named, ok := coll.Value.(yaml.MapSlice)
+		if !ok {
+			return nil, fmt.Errorf("fixtures of %v must be a map of named documents", coll.Key)
+		}
+		for _, item := range named {
+			doc, ok := convert(item.Value).(bson.D)
+			if !ok {
+				return nil, fmt.Errorf("fixture %v.%v must be a document", coll.Key, item.Key)
+			}
+			fixtures = append(fixtures, fixture{coll: fmt.Sprint(coll.Key), name: fmt.Sprint(item.Key), doc: doc})
+		}
+	}
+	return fixtures, nil
+}
+
+// convert turns the values decoded by yaml into their bson equivalents
+func convert(v interface{}) interface{} {
+	switch value := v.(type) {
+	case yaml.MapSlice:
+		var doc = make(bson.D, 0, len(value))
+		for _, item := range value {
+			doc = append(doc, bson.E{Key: fmt.Sprint(item.Key), Value: convert(item.Value)})
+		}
+		return doc
+	case []interface{}:
+		var array = make(bson.A, len(value))
+		for i, item := range value {
+			array[i] = convert(item)
+		}
+		return array
+	case int:
+		return int64(value)
+	}
+	return v
+}
+
+// render replaces the template expressions of v
+func (l *Loader) render(v interface{}, fixture string) (interface{}, error) {
+	switch value := v.(type) {
+	case bson.D:
+		var doc = make(bson.D, len(value))
+		for i, e := range value {
+			rendered, err := l.render(e.Value, fixture)
+			if err != nil {
+				return nil, err
+			}
+			doc[i] = bson.E{Key: e.Key, Value: rendered}
+		}
+		return doc, nil
+	case bson.A:
+		var array = make(bson.A, len(value))
+		for i, item := range value {
+			rendered, err := l.render(item, fixture)
+			if err != nil {
+				return nil, err
+			}
+			array[i] = rendered
+		}
+		return array, nil
+	case string:
+		var expr = strings.TrimSpace(value)
+		if !strings.HasPrefix(expr, "{{") || !strings.HasSuffix(expr, "}}") {
+			return value, nil
+		}
+		rendered, err := l.evaluate(strings.Fields(expr[2 : len(expr)-2]))
+		if err != nil {
+			return nil, fmt.Errorf("fixture %v: %q: %v", fixture, value, err)
+		}
+		return rendered, nil
+	}
+	return v, nil
+}
+
+func (l *Loader) evaluate(args []string) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, errors.New("empty expression")
+	}
+	switch args[0] {
+	case "objectId":
+		return primitive.NewObjectID(), nil
+	case "now":
+		if len(args) == 1 {
+			return l.now, nil
+		}
+		offset, err := parseOffset(strings.Join(args[1:], ""))
+		if err != nil {
+			return nil, err
+		}
+		return l.now.Add(offset), nil
+	case "ref":
+		if len(args) != 2 {
+			return nil, errors.New("ref expects coll.name")
+		}
+		id, ok := l.ids[args[1]]
+		if !ok {
+			return nil, errors.New("unknown fixture " + strconv.Quote(args[1]))
+		}
+		return id, nil
+	}
+	return nil, errors.New("unknown function " + strconv.Quote(args[0]))
+}
+
+// parseOffset parses a signed duration, with d for days on top of the
+// units of time.ParseDuration
+func parseOffset(s string) (time.Duration, error) {
+	var sign = time.Duration(1)
+	switch {
+	case strings.HasPrefix(s, "-"):
+		sign, s = -1, s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+	var days time.Duration
+	if i := strings.Index(s, "d"); i > 0 {
+		n, err := strconv.Atoi(s[:i])
+		if err != nil {
+			return 0, errors.New("invalid offset " + strconv.Quote(s))
+		}
+		days, s = time.Duration(n)*24*time.Hour, s[i+1:]
+	}
+	var rest time.Duration
+	if s != "" {
+		var err error
+		if rest, err = time.ParseDuration(s); err != nil {
+			return 0, err
+		}
+	}
+	return sign * (days + rest), nil
+}
+
+func lookup(doc bson.D, key string) (interface{}, bool) {
+	if i := index(doc, key); i >= 0 {
+		return doc[i].Value, true
+	}
+	return nil, false
+}
+
+func index(doc bson.D, key string) int {
+	for i, e := range doc {
+		if e.Key == key {
+			return i
+		}
+	}
+	return -1
+}
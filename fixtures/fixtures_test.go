@@ -0,0 +1,104 @@
+package fixtures
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParse(t *testing.T) {
+	fixtures, err := parse([]byte(`
+users:
+  sara:
+    name: sara
+    age: 30
+    tags: [a, b]
+    address:
+      city: rome
+posts:
+  welcome:
+    title: hello
+`))
+	assert.Nil(t, err)
+	assert.Len(t, fixtures, 2)
+	assert.Equal(t, "users", fixtures[0].coll)
+	assert.Equal(t, "sara", fixtures[0].name)
+	assert.Equal(t, bson.D{
+		{Key: "name", Value: "sara"},
+		{Key: "age", Value: int64(30)},
+		{Key: "tags", Value: bson.A{"a", "b"}},
+		{Key: "address", Value: bson.D{{Key: "city", Value: "rome"}}},
+	}, fixtures[0].doc)
+
+	_, err = parse([]byte(`{"users": {"sara": {"name": "sara"}}}`))
+	assert.Nil(t, err)
+	_, err = parse([]byte(`users: [1, 2]`))
+	assert.Error(t, err)
+}
+
+func TestLoader_render(t *testing.T) {
+	var now = time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	var l = New(nil, "").Now(now)
+	var id = primitive.NewObjectID()
+	l.ids["users.sara"] = id
+
+	doc, err := l.render(bson.D{
+		{Key: "author", Value: "{{ ref users.sara }}"},
+		{Key: "at", Value: "{{ now -1d2h }}"},
+		{Key: "until", Value: "{{now +30m}}"},
+		{Key: "list", Value: bson.A{"{{ objectId }}", "plain {{ text }}"}},
+	}, "posts.welcome")
+	assert.Nil(t, err)
+	var rendered = doc.(bson.D)
+	assert.Equal(t, id, rendered[0].Value)
+	assert.Equal(t, now.Add(-26*time.Hour), rendered[1].Value)
+	assert.Equal(t, now.Add(30*time.Minute), rendered[2].Value)
+	assert.IsType(t, primitive.ObjectID{}, rendered[3].Value.(bson.A)[0])
+	assert.Equal(t, "plain {{ text }}", rendered[3].Value.(bson.A)[1])
+
+	_, err = l.render("{{ ref users.unknown }}", "posts.welcome")
+	assert.Error(t, err)
+	_, err = l.render("{{ random }}", "posts.welcome")
+	assert.Error(t, err)
+}
+
+type fakeUser struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	FullName  string
+	Email     string
+	Age       int
+	Tags      []string `fixture:"word"`
+	CreatedAt time.Time
+	Address   struct {
+		City string `fixture:"word"`
+	}
+	Skipped string `fixture:"-"`
+}
+
+func TestFactory_Fill(t *testing.T) {
+	var a, b fakeUser
+	assert.Nil(t, NewFactory(1).Fill(&a))
+	assert.Nil(t, NewFactory(1).Fill(&b))
+	assert.Equal(t, a.Email, b.Email)
+	assert.Contains(t, a.Email, "@example.com")
+	assert.Contains(t, a.FullName, " ")
+	assert.False(t, a.ID.IsZero())
+	assert.False(t, a.CreatedAt.IsZero())
+	assert.NotEmpty(t, a.Tags)
+	assert.NotEmpty(t, a.Address.City)
+	assert.Empty(t, a.Skipped)
+
+	assert.Error(t, NewFactory(1).Fill(a))
+	assert.Error(t, NewFactory(1).Fill(nil))
+	assert.Error(t, NewFactory(1).Fill((*fakeUser)(nil)))
+
+	docs, err := NewFactory(2).Build(3, func() interface{} { return &fakeUser{} }, func(i int, doc interface{}) {
+		doc.(*fakeUser).Age = i
+	})
+	assert.Nil(t, err)
+	assert.Len(t, docs, 3)
+	assert.Equal(t, 2, docs[2].(*fakeUser).Age)
+}
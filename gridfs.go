@@ -0,0 +1,301 @@
+package mongoadapter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// FileInfo is the document GridFS keeps in the files collection of a bucket
+type FileInfo struct {
+	ID         interface{} `bson:"_id"`
+	Filename   string      `bson:"filename"`
+	Length     int64       `bson:"length"`
+	ChunkSize  int32       `bson:"chunkSize"`
+	UploadDate time.Time   `bson:"uploadDate"`
+	Metadata   bson.Raw    `bson:"metadata,omitempty"`
+}
+
+// ChunkSize sets the size of the GridFS chunks written by the view's uploads,
+// the driver's default is 255KB
+func ChunkSize(bytes int32) Option {
+	return func(m *Mongo) {
+		m.chunkSize = bytes
+	}
+}
+
+// bucket returns the named GridFS bucket of db, "fs" if name is empty, with
+// the view's concerns and chunk size
+func (m *Mongo) bucket(db, name string) (*gridfs.Bucket, error) {
+	var opts = options.GridFSBucket()
+	if name != "" {
+		opts.SetName(name)
+	}
+	if m.chunkSize > 0 {
+		opts.SetChunkSizeBytes(m.chunkSize)
+	}
+	if m.readPref != nil {
+		opts.SetReadPreference(m.readPref)
+	}
+	if m.readConcern != nil {
+		opts.SetReadConcern(m.readConcern)
+	}
+	if m.writeConcern != nil {
+		opts.SetWriteConcern(m.writeConcern)
+	}
+	return gridfs.NewBucket(m.conn.Database(db), opts)
+}
+
+// transferDeadline is the deadline of uploads and downloads. They can last
+// much longer than the read and write timeouts, so they are only bounded by
+// the deadline of the view's context, if any.
+func (m *Mongo) transferDeadline() time.Time {
+	deadline, _ := m.context().Deadline()
+	return deadline
+}
+
+// UploadFile stores the content of source in the bucket and returns the id of the new file,
+// e.g. m.UploadFile(db, "avatars", "sara.png", r.Body, options.GridFSUpload().SetMetadata(bson.M{"user": id}))
+func (m *Mongo) UploadFile(db, bucket, filename string, source io.Reader, opts ...*options.UploadOptions) (primitive.ObjectID, error) {
+	var id = primitive.NewObjectID()
+	return id, m.UploadFileWithID(db, bucket, id, filename, source, opts...)
+}
+
+// UploadFileWithID stores the content of source in the bucket under the given id
+func (m *Mongo) UploadFileWithID(db, bucket string, id interface{}, filename string, source io.Reader, opts ...*options.UploadOptions) error {
+	b, err := m.bucket(db, bucket)
+	if err != nil {
+		return err
+	}
+	if err := b.SetWriteDeadline(m.transferDeadline()); err != nil {
+		return err
+	}
+	return b.UploadFromStreamWithID(id, filename, source, opts...)
+}
+
+// DownloadFile writes the content of the file to w and returns the number of bytes written
+func (m *Mongo) DownloadFile(db, bucket string, id interface{}, w io.Writer) (int64, error) {
+	stream, err := m.OpenFile(db, bucket, id)
+	if err != nil {
+		return 0, err
+	}
+	defer stream.Close()
+	return io.Copy(w, stream)
+}
+
+// DownloadFileByName writes the content of the latest revision of the
+// named file to w, use options.GridFSName().SetRevision() for others
+func (m *Mongo) DownloadFileByName(db, bucket, filename string, w io.Writer, opts ...*options.NameOptions) (int64, error) {
+	stream, err := m.OpenFileByName(db, bucket, filename, opts...)
+	if err != nil {
+		return 0, err
+	}
+	defer stream.Close()
+	return io.Copy(w, stream)
+}
+
+// DownloadFileRange writes length bytes of the file starting at offset to w,
+// a negative length reads until the end of the file. Only the chunks
+// holding the range are read. gridfs.ErrFileNotFound is returned if the
+// file does not exist.
+func (m *Mongo) DownloadFileRange(db, bucket string, id interface{}, w io.Writer, offset, length int64) (int64, error) {
+	if offset < 0 {
+		return 0, errors.New("range offset must not be negative")
+	}
+	info, err := m.StatFile(db, bucket, id)
+	if m.NoDocument(err) {
+		return 0, gridfs.ErrFileNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	var end = info.Length
+	if length >= 0 && offset+length < end {
+		end = offset + length
+	}
+	if offset >= end || info.ChunkSize <= 0 {
+		return 0, nil
+	}
+	var chunkSize = int64(info.ChunkSize)
+	var first, last = offset / chunkSize, (end - 1) / chunkSize
+
+	// like the downloads, the range is only bounded by the view's context
+	var ctx = m.context()
+	cur, err := m.collection(db, chunksCollection(bucket)).Find(ctx,
+		bson.M{"files_id": id, "n": bson.M{"$gte": first, "$lte": last}},
+		options.Find().SetSort(bson.M{"n": 1}))
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+	var written int64
+	var expected = first
+	for cur.Next(ctx) {
+		var chunk struct {
+			N    int64  `bson:"n"`
+			Data []byte `bson:"data"`
+		}
+		if err := cur.Decode(&chunk); err != nil {
+			return written, err
+		}
+		if chunk.N != expected {
+			return written, gridfs.ErrWrongIndex
+		}
+		n, err := w.Write(chunkRange(chunk.Data, chunk.N*chunkSize, offset, end))
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		expected++
+	}
+	if err := cur.Err(); err != nil {
+		return written, err
+	}
+	if expected <= last {
+		return written, gridfs.ErrWrongIndex
+	}
+	return written, nil
+}
+
+// chunkRange returns the part of the chunk data, which starts at byte
+// start of the file, that lies within [offset, end)
+func chunkRange(data []byte, start, offset, end int64) []byte {
+	var from, to = offset - start, end - start
+	if from < 0 {
+		from = 0
+	}
+	if to > int64(len(data)) {
+		to = int64(len(data))
+	}
+	if from >= to {
+		return nil
+	}
+	return data[from:to]
+}
+
+// OpenFile returns a stream reading the content of the file, it must be closed.
+// gridfs.ErrFileNotFound is returned if the file does not exist.
+func (m *Mongo) OpenFile(db, bucket string, id interface{}) (*gridfs.DownloadStream, error) {
+	b, err := m.bucket(db, bucket)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.SetReadDeadline(m.transferDeadline()); err != nil {
+		return nil, err
+	}
+	return b.OpenDownloadStream(id)
+}
+
+// OpenFileByName returns a stream reading the content of the named file, it must be closed
+func (m *Mongo) OpenFileByName(db, bucket, filename string, opts ...*options.NameOptions) (*gridfs.DownloadStream, error) {
+	b, err := m.bucket(db, bucket)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.SetReadDeadline(m.transferDeadline()); err != nil {
+		return nil, err
+	}
+	return b.OpenDownloadStreamByName(filename, opts...)
+}
+
+// StatFile returns the information of the file, mongo.ErrNoDocuments is
+// returned if it does not exist
+func (m *Mongo) StatFile(db, bucket string, id interface{}) (*FileInfo, error) {
+	ctx, cancel := context.WithTimeout(m.context(), m.readTimeout)
+	defer cancel()
+	var info FileInfo
+	err := m.collection(db, filesCollection(bucket)).FindOne(ctx, bson.M{"_id": id}).Decode(&info)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// ListFiles returns the files of the bucket matching filter, e.g.
+// bson.M{"metadata.user": id}, sorted with options.GridFSFind().SetSort()
+func (m *Mongo) ListFiles(db, bucket string, filter interface{}, opts ...*options.GridFSFindOptions) ([]FileInfo, error) {
+	b, err := m.bucket(db, bucket)
+	if err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		filter = bson.M{}
+	}
+	if err := b.SetReadDeadline(time.Now().Add(m.readTimeout)); err != nil {
+		return nil, err
+	}
+	cur, err := b.Find(filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(m.context(), m.readTimeout)
+	defer cancel()
+	defer cur.Close(ctx)
+	var files []FileInfo
+	for cur.Next(ctx) {
+		var info FileInfo
+		if err := cur.Decode(&info); err != nil {
+			return nil, err
+		}
+		files = append(files, info)
+	}
+	return files, cur.Err()
+}
+
+// DeleteFile removes the file and its chunks
+func (m *Mongo) DeleteFile(db, bucket string, id interface{}) error {
+	b, err := m.bucket(db, bucket)
+	if err != nil {
+		return err
+	}
+	if err := b.SetWriteDeadline(time.Now().Add(m.writeTimeout)); err != nil {
+		return err
+	}
+	return b.Delete(id)
+}
+
+// RenameFile changes the name of the file
+func (m *Mongo) RenameFile(db, bucket string, id interface{}, filename string) error {
+	b, err := m.bucket(db, bucket)
+	if err != nil {
+		return err
+	}
+	if err := b.SetWriteDeadline(time.Now().Add(m.writeTimeout)); err != nil {
+		return err
+	}
+	return b.Rename(id, filename)
+}
+
+// SetFileMetadata replaces the metadata of the file
+func (m *Mongo) SetFileMetadata(db, bucket string, id interface{}, metadata interface{}) error {
+	ctx, cancel := context.WithTimeout(m.context(), m.writeTimeout)
+	defer cancel()
+	res, err := m.collection(db, filesCollection(bucket)).UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"metadata": metadata}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return gridfs.ErrFileNotFound
+	}
+	return nil
+}
+
+func filesCollection(bucket string) string {
+	if bucket == "" {
+		bucket = "fs"
+	}
+	return bucket + ".files"
+}
+
+func chunksCollection(bucket string) string {
+	if bucket == "" {
+		bucket = "fs"
+	}
+	return bucket + ".chunks"
+}
@@ -0,0 +1,74 @@
+package mongoadapter
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestMongo_UploadFile(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	var bucket = "testFiles"
+	_ = m.conn.Database(mongoDatabase).Collection(bucket + ".files").Drop(context.Background())
+	_ = m.conn.Database(mongoDatabase).Collection(bucket + ".chunks").Drop(context.Background())
+	var content = strings.Repeat("0123456789", 100)
+
+	id, err := m.With(ChunkSize(64)).UploadFile(mongoDatabase, bucket, "digits.txt", strings.NewReader(content),
+		options.GridFSUpload().SetMetadata(bson.M{"owner": "sara"}))
+	assert.Nil(t, err)
+
+	info, err := m.StatFile(mongoDatabase, bucket, id)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1000), info.Length)
+	assert.Equal(t, int32(64), info.ChunkSize)
+	assert.Equal(t, "sara", info.Metadata.Lookup("owner").StringValue())
+
+	var out bytes.Buffer
+	n, err := m.DownloadFile(mongoDatabase, bucket, id, &out)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1000), n)
+	assert.Equal(t, content, out.String())
+
+	out.Reset()
+	_, err = m.DownloadFileRange(mongoDatabase, bucket, id, &out, 125, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, content[125:135], out.String())
+	out.Reset()
+	_, err = m.DownloadFileRange(mongoDatabase, bucket, id, &out, 995, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, "56789", out.String())
+	out.Reset()
+	n, err = m.DownloadFileRange(mongoDatabase, bucket, id, &out, 60, 200)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(200), n)
+	assert.Equal(t, content[60:260], out.String(), "the range spans several chunks")
+
+	assert.Nil(t, m.RenameFile(mongoDatabase, bucket, id, "numbers.txt"))
+	assert.Nil(t, m.SetFileMetadata(mongoDatabase, bucket, id, bson.M{"owner": "john"}))
+	files, err := m.ListFiles(mongoDatabase, bucket, bson.M{"metadata.owner": "john"})
+	assert.Nil(t, err)
+	assert.Len(t, files, 1)
+	assert.Equal(t, "numbers.txt", files[0].Filename)
+	out.Reset()
+	_, err = m.DownloadFileByName(mongoDatabase, bucket, "numbers.txt", &out)
+	assert.Nil(t, err)
+	assert.Equal(t, content, out.String())
+
+	assert.Nil(t, m.DeleteFile(mongoDatabase, bucket, id))
+	_, err = m.OpenFile(mongoDatabase, bucket, id)
+	assert.Equal(t, gridfs.ErrFileNotFound, err)
+}
+
+func TestChunkRange(t *testing.T) {
+	var data = []byte("0123456789")
+	assert.Equal(t, "56789", string(chunkRange(data, 120, 125, 200)))
+	assert.Equal(t, "0123", string(chunkRange(data, 120, 100, 124)))
+	assert.Equal(t, "2345", string(chunkRange(data, 120, 122, 126)))
+	assert.Empty(t, chunkRange(data, 120, 130, 140))
+}
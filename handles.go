@@ -0,0 +1,171 @@
+package mongoadapter
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Database is a handle on a database of m, see Mongo.DB()
+type Database struct {
+	m    *Mongo
+	name string
+}
+
+// Collection is a handle on a collection, its methods are the ones of
+// Mongo without the db and coll arguments
+type Collection struct {
+	m    *Mongo
+	db   string
+	name string
+}
+
+// DB returns a handle on the database, the options apply to all of its
+// collections:
+// users := m.DB("app", WriteConcern(wc)).Coll("users")
+func (m *Mongo) DB(name string, opts ...Option) *Database {
+	return &Database{m: m.With(opts...), name: name}
+}
+
+// Name returns the name of the database
+func (d *Database) Name() string {
+	return d.name
+}
+
+// Coll returns a handle on the collection of the database, the options
+// are applied on top of the database's, e.g. Timeouts(30*time.Second, 0) or Codecs(r)
+func (d *Database) Coll(name string, opts ...Option) *Collection {
+	return &Collection{m: d.m.With(opts...), db: d.name, name: name}
+}
+
+// Name returns the name of the collection
+func (c *Collection) Name() string {
+	return c.name
+}
+
+// Database returns the name of the database of the collection
+func (c *Collection) Database() string {
+	return c.db
+}
+
+// FullName returns "db.coll"
+func (c *Collection) FullName() string {
+	return c.db + "." + c.name
+}
+
+// With returns a copy of the handle with the options applied, e.g.
+// users.With(Context(r.Context())).FindOne(filter)
+func (c *Collection) With(opts ...Option) *Collection {
+	return &Collection{m: c.m.With(opts...), db: c.db, name: c.name}
+}
+
+// ValidateCollections checks at startup that every handle is in the
+// allowlist of "db.coll" names, which may contain patterns such as
+// "app.*", so that a typo in a collection name fails early
+func ValidateCollections(allowed []string, handles ...*Collection) error {
+	var unknown []string
+	for _, c := range handles {
+		if !collectionAllowed(allowed, c.FullName()) {
+			unknown = append(unknown, c.FullName())
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return errors.New("unknown collections: " + strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+func collectionAllowed(allowed []string, name string) bool {
+	for _, pattern := range allowed {
+		if ok, err := path.Match(pattern, name); ok && err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Collection) FindOne(filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	return c.m.FindOne(c.db, c.name, filter, opts...)
+}
+
+func (c *Collection) FindMany(filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	return c.m.FindMany(c.db, c.name, filter, opts...)
+}
+
+func (c *Collection) FindWhereIn(negate bool, vars ...[]string) (*mongo.Cursor, error) {
+	return c.m.FindWhereIn(c.db, c.name, negate, vars...)
+}
+
+func (c *Collection) InsertOne(doc interface{}) (*mongo.InsertOneResult, error) {
+	return c.m.InsertOne(c.db, c.name, doc)
+}
+
+func (c *Collection) InsertMany(docs []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error) {
+	return c.m.InsertMany(c.db, c.name, docs, opts...)
+}
+
+func (c *Collection) UpdateOne(filter interface{}, data interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return c.m.UpdateOne(c.db, c.name, filter, data, opts...)
+}
+
+func (c *Collection) UpdateMany(filter interface{}, data interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return c.m.UpdateMany(c.db, c.name, filter, data, opts...)
+}
+
+func (c *Collection) ReplaceOne(filter interface{}, replacement interface{}, opts ...*options.ReplaceOptions) (*mongo.UpdateResult, error) {
+	return c.m.ReplaceOne(c.db, c.name, filter, replacement, opts...)
+}
+
+func (c *Collection) DeleteOne(filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return c.m.DeleteOne(c.db, c.name, filter, opts...)
+}
+
+func (c *Collection) DeleteMany(filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return c.m.DeleteMany(c.db, c.name, filter, opts...)
+}
+
+func (c *Collection) Count(filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	return c.m.Count(c.db, c.name, filter, opts...)
+}
+
+func (c *Collection) EstimatedCount(opts ...*options.EstimatedDocumentCountOptions) (int64, error) {
+	return c.m.EstimatedCount(c.db, c.name, opts...)
+}
+
+func (c *Collection) Search(filters map[string][]string, sorting map[string]int, limit, skip int64) (*mongo.Cursor, error) {
+	return c.m.Search(c.db, c.name, filters, sorting, limit, skip)
+}
+
+func (c *Collection) SearchCount(filters map[string][]string) (int64, error) {
+	return c.m.SearchCount(c.db, c.name, filters)
+}
+
+func (c *Collection) Aggregate(pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	return c.m.Aggregate(c.db, c.name, pipeline, opts...)
+}
+
+func (c *Collection) AddUniqueIndex(indexKey string) (string, error) {
+	return c.m.AddUniqueIndex(c.db, c.name, indexKey)
+}
+
+func (c *Collection) AddTextV3Index(indexKey string) (string, error) {
+	return c.m.AddTextV3Index(c.db, c.name, indexKey)
+}
+
+func (c *Collection) CreateIndexes(models ...mongo.IndexModel) ([]string, error) {
+	return c.m.CreateIndexes(c.db, c.name, models...)
+}
+
+func (c *Collection) ListIndexes() ([]bson.M, error) {
+	return c.m.ListIndexes(c.db, c.name)
+}
+
+func (c *Collection) DropIndex(name string) error {
+	return c.m.DropIndex(c.db, c.name, name)
+}
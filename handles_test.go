@@ -0,0 +1,61 @@
+package mongoadapter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+func TestValidateCollections(t *testing.T) {
+	m := &Mongo{registry: newSettingsRegistry()}
+	var app = m.DB("app")
+	var users, orders, typo = app.Coll("users"), app.Coll("orders"), app.Coll("uesrs")
+	var logs = m.DB("logs").Coll("requests")
+
+	assert.Nil(t, ValidateCollections([]string{"app.users", "app.orders"}, users, orders))
+	assert.Nil(t, ValidateCollections([]string{"app.users", "logs.*"}, users, logs))
+	err := ValidateCollections([]string{"app.users", "app.orders"}, users, typo, logs)
+	assert.EqualError(t, err, "unknown collections: app.uesrs, logs.requests")
+}
+
+func TestCollection_options(t *testing.T) {
+	m := &Mongo{registry: newSettingsRegistry(), readTimeout: 5 * time.Second, writeTimeout: 5 * time.Second}
+	var wc = writeconcern.New(writeconcern.WMajority())
+	var users = m.DB("app", WriteConcern(wc)).Coll("users", Timeouts(30*time.Second, 0))
+	assert.Equal(t, "app.users", users.FullName())
+	assert.Equal(t, wc, users.m.writeConcern)
+	assert.Equal(t, 30*time.Second, users.m.readTimeout)
+	assert.Equal(t, 5*time.Second, users.m.writeTimeout)
+	assert.Nil(t, m.writeConcern, "the handles must not change m")
+}
+
+func TestCollection_CRUD(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	var users = m.DB(mongoDatabase).Coll("handles")
+	_ = m.conn.Database(mongoDatabase).Collection("handles").Drop(context.Background())
+
+	_, err := users.InsertOne(DummyUser{Name: "sara", Email: "sara@example.com"})
+	assert.Nil(t, err)
+	_, err = users.UpdateOne(bson.M{"name": "sara"}, bson.M{"$set": bson.M{"email": "sara@example.org"}})
+	assert.Nil(t, err)
+	var user DummyUser
+	assert.Nil(t, users.FindOne(bson.M{"name": "sara"}).Decode(&user))
+	assert.Equal(t, "sara@example.org", user.Email)
+	n, err := users.SearchCount(map[string][]string{"name": {"sa", "like"}})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), n)
+
+	_, err = users.AddUniqueIndex("email")
+	assert.Nil(t, err)
+	indexes, err := users.ListIndexes()
+	assert.Nil(t, err)
+	assert.Len(t, indexes, 2)
+
+	res, err := users.DeleteMany(bson.M{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), res.DeletedCount)
+}
@@ -0,0 +1,310 @@
+package mongoadapter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// HealthState is the coarse state reported by Health() and tracked by HealthChecker
+type HealthState string
+
+const (
+	HealthUnknown   HealthState = "unknown"
+	HealthHealthy   HealthState = "healthy"
+	HealthUnhealthy HealthState = "unhealthy"
+)
+
+// Topology describes the deployment the client is connected to, as reported
+// by the isMaster and buildInfo commands
+type Topology struct {
+	ReplicaSet    string   `json:"replicaSet,omitempty"`
+	Primary       string   `json:"primary,omitempty"`
+	Secondaries   []string `json:"secondaries,omitempty"`
+	Me            string   `json:"me,omitempty"`
+	IsPrimary     bool     `json:"isPrimary"`
+	IsSecondary   bool     `json:"isSecondary"`
+	ServerVersion string   `json:"serverVersion,omitempty"`
+}
+
+// PoolStats is a snapshot of the connection pool counters collected
+// through the driver's pool monitor
+type PoolStats struct {
+	Open        int64 `json:"open"`
+	InUse       int64 `json:"inUse"`
+	Created     int64 `json:"created"`
+	Closed      int64 `json:"closed"`
+	CheckOuts   int64 `json:"checkOuts"`
+	CheckOutErr int64 `json:"checkOutErrors"`
+	Cleared     int64 `json:"cleared"`
+}
+
+// HealthStatus is the result of a single health check
+type HealthStatus struct {
+	State     HealthState   `json:"state"`
+	Latency   time.Duration `json:"latency"`
+	Topology  Topology      `json:"topology"`
+	Pool      PoolStats     `json:"pool"`
+	LastError string        `json:"lastError,omitempty"`
+	CheckedAt time.Time     `json:"checkedAt"`
+}
+
+// Healthy reports whether the status is HealthHealthy
+func (s *HealthStatus) Healthy() bool {
+	return s != nil && s.State == HealthHealthy
+}
+
+// poolStats keeps the counters fed by the driver's PoolMonitor
+type poolStats struct {
+	sync.Mutex
+	stats PoolStats
+}
+
+func (p *poolStats) monitor() *event.PoolMonitor {
+	return &event.PoolMonitor{Event: p.handle}
+}
+
+func (p *poolStats) handle(e *event.PoolEvent) {
+	p.Lock()
+	defer p.Unlock()
+	switch e.Type {
+	case event.ConnectionCreated:
+		p.stats.Created++
+		p.stats.Open++
+	case event.ConnectionClosed:
+		p.stats.Closed++
+		p.stats.Open--
+	case event.GetSucceeded:
+		p.stats.CheckOuts++
+		p.stats.InUse++
+	case event.ConnectionReturned:
+		p.stats.InUse--
+	case event.GetFailed:
+		p.stats.CheckOutErr++
+	case event.PoolCleared:
+		p.stats.Cleared++
+	}
+}
+
+func (p *poolStats) snapshot() PoolStats {
+	if p == nil {
+		return PoolStats{}
+	}
+	p.Lock()
+	defer p.Unlock()
+	return p.stats
+}
+
+// PoolStats returns the current connection pool counters
+func (m *Mongo) PoolStats() PoolStats {
+	return m.pool.snapshot()
+}
+
+// Health pings the primary and collects topology and pool information.
+// The returned error is the ping (or topology) error, it is also recorded in
+// the LastError field of the returned status, which is never nil.
+// If ctx has no deadline, the read timeout of the instance is used.
+func (m *Mongo) Health(ctx context.Context) (*HealthStatus, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.readTimeout)
+		defer cancel()
+	}
+	var status = &HealthStatus{
+		State:     HealthUnhealthy,
+		CheckedAt: time.Now().UTC(),
+		Pool:      m.pool.snapshot(),
+	}
+
+	var start = time.Now()
+	err := m.conn.Ping(ctx, readpref.Primary())
+	status.Latency = time.Since(start)
+	if err != nil {
+		status.LastError = err.Error()
+		return status, err
+	}
+
+	topology, err := m.topology(ctx)
+	if err != nil {
+		status.LastError = err.Error()
+		return status, err
+	}
+	status.Topology = *topology
+	status.State = HealthHealthy
+	return status, nil
+}
+
+func (m *Mongo) topology(ctx context.Context) (*Topology, error) {
+	var isMaster struct {
+		IsMaster  bool     `bson:"ismaster"`
+		Secondary bool     `bson:"secondary"`
+		SetName   string   `bson:"setName"`
+		Hosts     []string `bson:"hosts"`
+		Primary   string   `bson:"primary"`
+		Me        string   `bson:"me"`
+	}
+	err := m.conn.Database("admin").RunCommand(ctx, bson.M{"isMaster": 1}).Decode(&isMaster)
+	if err != nil {
+		return nil, errors.New("isMaster command failed, got error: " + err.Error())
+	}
+	var buildInfo struct {
+		Version string `bson:"version"`
+	}
+	err = m.conn.Database("admin").RunCommand(ctx, bson.M{"buildInfo": 1}).Decode(&buildInfo)
+	if err != nil {
+		return nil, errors.New("buildInfo command failed, got error: " + err.Error())
+	}
+
+	var topology = &Topology{
+		ReplicaSet:    isMaster.SetName,
+		Primary:       isMaster.Primary,
+		Me:            isMaster.Me,
+		IsPrimary:     isMaster.IsMaster,
+		IsSecondary:   isMaster.Secondary,
+		ServerVersion: buildInfo.Version,
+	}
+	for _, host := range isMaster.Hosts {
+		if host != isMaster.Primary {
+			topology.Secondaries = append(topology.Secondaries, host)
+		}
+	}
+	return topology, nil
+}
+
+// HealthCheckerConfig configures a HealthChecker. Zero values fall back to
+// a 10 second Interval and a Timeout equal to the instance's read timeout.
+type HealthCheckerConfig struct {
+	Interval time.Duration
+	Timeout  time.Duration
+	// OnChange is called whenever the state changes, including the first
+	// transition out of HealthUnknown. It runs on the checker's goroutine.
+	OnChange func(previous, current *HealthStatus)
+}
+
+// HealthChecker periodically calls Health() in the background and keeps
+// the latest status around, it is also the source for the liveness and
+// readiness http handlers.
+type HealthChecker struct {
+	m      *Mongo
+	config HealthCheckerConfig
+
+	mu     sync.RWMutex
+	status *HealthStatus
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewHealthChecker creates a checker for m. Call Start() to begin checking.
+func NewHealthChecker(m *Mongo, config HealthCheckerConfig) *HealthChecker {
+	if config.Interval <= 0 {
+		config.Interval = 10 * time.Second
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = m.readTimeout
+	}
+	return &HealthChecker{
+		m:      m,
+		config: config,
+		status: &HealthStatus{State: HealthUnknown},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Start runs a first check synchronously and then keeps checking every
+// Interval until Stop() is called
+func (h *HealthChecker) Start() {
+	h.Check()
+	go func() {
+		defer close(h.done)
+		ticker := time.NewTicker(h.config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				h.Check()
+			case <-h.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background checks and waits for the running one to finish.
+// It must only be called after Start().
+func (h *HealthChecker) Stop() {
+	h.stopOnce.Do(func() {
+		close(h.stop)
+	})
+	<-h.done
+}
+
+// Check runs a health check immediately, records it and returns it
+func (h *HealthChecker) Check() *HealthStatus {
+	ctx, cancel := context.WithTimeout(context.Background(), h.config.Timeout)
+	defer cancel()
+	current, _ := h.m.Health(ctx)
+
+	h.mu.Lock()
+	var previous = h.status
+	h.status = current
+	h.mu.Unlock()
+
+	if previous.State != current.State && h.config.OnChange != nil {
+		h.config.OnChange(previous, current)
+	}
+	return current
+}
+
+// Status returns the latest recorded status
+func (h *HealthChecker) Status() *HealthStatus {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.status
+}
+
+// LivenessHandler always answers 200 as long as the process is able to
+// serve http, the body contains the latest status for diagnostics
+func (h *HealthChecker) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealthStatus(w, http.StatusOK, h.Status())
+	})
+}
+
+// ReadinessHandler answers 200 when the latest check was healthy and
+// 503 otherwise, including before the first check has completed
+func (h *HealthChecker) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var status = h.Status()
+		var code = http.StatusOK
+		if !status.Healthy() {
+			code = http.StatusServiceUnavailable
+		}
+		writeHealthStatus(w, code, status)
+	})
+}
+
+// Handler serves the liveness handler on /live and the readiness
+// handler on /ready
+func (h *HealthChecker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/live", h.LivenessHandler())
+	mux.Handle("/ready", h.ReadinessHandler())
+	return mux
+}
+
+func writeHealthStatus(w http.ResponseWriter, code int, status *HealthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,57 @@
+package mongoadapter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMongo_Health(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	status, err := m.Health(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, status.Healthy())
+	assert.NotEmpty(t, status.Topology.ServerVersion)
+	assert.True(t, status.Topology.IsPrimary)
+	assert.True(t, status.Latency > 0)
+	assert.True(t, status.Pool.Created > 0)
+}
+
+func TestHealthChecker_mustCallOnChangeOnFirstCheck(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	var transitions []HealthState
+	checker := NewHealthChecker(m, HealthCheckerConfig{
+		Interval: 50 * time.Millisecond,
+		OnChange: func(previous, current *HealthStatus) {
+			transitions = append(transitions, previous.State, current.State)
+		},
+	})
+	checker.Start()
+	time.Sleep(200 * time.Millisecond)
+	checker.Stop()
+	assert.Equal(t, []HealthState{HealthUnknown, HealthHealthy}, transitions)
+	assert.True(t, checker.Status().Healthy())
+}
+
+func TestHealthChecker_Handler(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	checker := NewHealthChecker(m, HealthCheckerConfig{})
+
+	rec := httptest.NewRecorder()
+	checker.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	checker.Check()
+	rec = httptest.NewRecorder()
+	checker.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	checker.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/live", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"state":"healthy"`)
+}
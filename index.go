@@ -0,0 +1,392 @@
+package mongoadapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// namespaceNotFound is the server error code of a missing collection
+const namespaceNotFound = 26
+
+// CreateIndexes creates the given indexes on the collection and returns
+// their names, indexes that already exist with the same options are kept
+func (m *Mongo) CreateIndexes(db, coll string, models ...mongo.IndexModel) ([]string, error) {
+	ctx, cancel := context.WithTimeout(m.context(), m.writeTimeout)
+	defer cancel()
+	return m.collection(db, coll).Indexes().CreateMany(ctx, models)
+}
+
+// ListIndexes returns the specifications of the indexes of the collection,
+// e.g. {"v": 2, "key": {"_id": 1}, "name": "_id_"}
+func (m *Mongo) ListIndexes(db, coll string) ([]bson.M, error) {
+	ctx, cancel := context.WithTimeout(m.context(), m.readTimeout)
+	defer cancel()
+	cur, err := m.collection(db, coll).Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	var indexes []bson.M
+	for cur.Next(ctx) {
+		var index bson.M
+		if err := cur.Decode(&index); err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, cur.Err()
+}
+
+// DropIndex drops the named index of the collection
+func (m *Mongo) DropIndex(db, coll, name string) error {
+	ctx, cancel := context.WithTimeout(m.context(), m.writeTimeout)
+	defer cancel()
+	_, err := m.collection(db, coll).Indexes().DropOne(ctx, name)
+	return err
+}
+
+// IndexSpec declares an index for SyncIndexes(), it is decoded from the
+// same fields as the specifications listed by the server:
+// {"key": {"email": 1}, "unique": true, "collation": {"locale": "fr"}}
+type IndexSpec struct {
+	// Name defaults to the server's naming, e.g. "email_1"
+	Name               string `bson:"name,omitempty"`
+	Key                bson.D `bson:"key"`
+	Unique             bool   `bson:"unique,omitempty"`
+	Sparse             bool   `bson:"sparse,omitempty"`
+	ExpireAfterSeconds *int32 `bson:"expireAfterSeconds,omitempty"`
+	// Options holds the other options as the server names them, e.g.
+	// partialFilterExpression, collation, weights or default_language
+	Options bson.M `bson:",inline"`
+}
+
+// IndexChanges lists the indexes created and dropped by SyncIndexes(), an
+// index whose options changed is in both
+type IndexChanges struct {
+	Created []string
+	Dropped []string
+}
+
+// serverIndexOptions are the options the server adds to the listed
+// indexes, they only matter when they are declared
+var serverIndexOptions = map[string]bool{
+	"v":                    true,
+	"ns":                   true,
+	"textIndexVersion":     true,
+	"2dsphereIndexVersion": true,
+	"default_language":     true,
+	"language_override":    true,
+	"weights":              true,
+}
+
+// name returns the name of the index, the one given or the server's default
+func (s IndexSpec) name() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	var parts = make([]string, 0, len(s.Key))
+	for _, e := range s.Key {
+		parts = append(parts, e.Key+"_"+indexKeyValue(e.Value))
+	}
+	return strings.Join(parts, "_")
+}
+
+// document returns the specification sent to the createIndexes command
+func (s IndexSpec) document() bson.D {
+	var doc = bson.D{{Key: "key", Value: s.Key}, {Key: "name", Value: s.name()}}
+	if s.Unique {
+		doc = append(doc, bson.E{Key: "unique", Value: true})
+	}
+	if s.Sparse {
+		doc = append(doc, bson.E{Key: "sparse", Value: true})
+	}
+	if s.ExpireAfterSeconds != nil {
+		doc = append(doc, bson.E{Key: "expireAfterSeconds", Value: *s.ExpireAfterSeconds})
+	}
+	var keys = make([]string, 0, len(s.Options))
+	for key := range s.Options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		doc = append(doc, bson.E{Key: key, Value: s.Options[key]})
+	}
+	return doc
+}
+
+// sameAs reports whether s and live describe the same index. Key values
+// are compared as numbers since the server may store 1 as a double, text
+// indexes by their fields and collations by the fields s declares.
+func (s IndexSpec) sameAs(live IndexSpec) bool {
+	if s.name() != live.name() || s.Unique != live.Unique || s.Sparse != live.Sparse {
+		return false
+	}
+	if (s.ExpireAfterSeconds == nil) != (live.ExpireAfterSeconds == nil) ||
+		(s.ExpireAfterSeconds != nil && *s.ExpireAfterSeconds != *live.ExpireAfterSeconds) {
+		return false
+	}
+	if !sameIndexKey(s.declaredKey(), live.declaredKey()) {
+		return false
+	}
+	for key, value := range s.Options {
+		if !sameIndexValue(value, live.Options[key], key == "collation") {
+			return false
+		}
+	}
+	for key := range live.Options {
+		if _, declared := s.Options[key]; !declared && !serverIndexOptions[key] {
+			return false
+		}
+	}
+	// unless declared, the weights of a text index are 1
+	if _, declared := s.Options["weights"]; !declared {
+		for _, e := range indexDocument(live.Options["weights"]) {
+			if indexKeyValue(e.Value) != "1" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// declaredKey returns the key of the index as it is declared. The server
+// lists the key {"title": "text"} as {"_fts": "text", "_ftsx": 1} with
+// the text fields in the weights, they are put back as "text" fields.
+func (s IndexSpec) declaredKey() bson.D {
+	var key = make(bson.D, 0, len(s.Key))
+	for _, e := range s.Key {
+		switch e.Key {
+		case "_fts":
+			for _, w := range indexDocument(s.Options["weights"]) {
+				key = append(key, bson.E{Key: w.Key, Value: "text"})
+			}
+		case "_ftsx":
+		default:
+			key = append(key, e)
+		}
+	}
+	return key
+}
+
+// sameIndexKey compares keys in order, except for the text fields which
+// are compared as a set
+func sameIndexKey(a, b bson.D) bool {
+	var textA, textB []string
+	var restA, restB bson.D
+	for _, e := range a {
+		if e.Value == "text" {
+			textA = append(textA, e.Key)
+		} else {
+			restA = append(restA, e)
+		}
+	}
+	for _, e := range b {
+		if e.Value == "text" {
+			textB = append(textB, e.Key)
+		} else {
+			restB = append(restB, e)
+		}
+	}
+	sort.Strings(textA)
+	sort.Strings(textB)
+	if len(textA) != len(textB) || len(restA) != len(restB) {
+		return false
+	}
+	for i := range textA {
+		if textA[i] != textB[i] {
+			return false
+		}
+	}
+	for i, e := range restA {
+		if e.Key != restB[i].Key || indexKeyValue(e.Value) != indexKeyValue(restB[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameIndexValue compares option values, numbers by value and documents
+// by field. With subset, fields of live that a doesn't declare are
+// ignored, like the defaults the server fills in a collation.
+func sameIndexValue(a, live interface{}, subset bool) bool {
+	if a == nil || live == nil {
+		return a == nil && live == nil
+	}
+	if docA, ok := toIndexDocument(a); ok {
+		docLive, ok := toIndexDocument(live)
+		if !ok || (!subset && len(docA) != len(docLive)) {
+			return false
+		}
+		for _, e := range docA {
+			value, found := lookup(docLive, e.Key)
+			if !found || !sameIndexValue(e.Value, value, subset) {
+				return false
+			}
+		}
+		return true
+	}
+	if arrA, ok := a.(bson.A); ok {
+		arrLive, ok := live.(bson.A)
+		if !ok || len(arrA) != len(arrLive) {
+			return false
+		}
+		for i := range arrA {
+			if !sameIndexValue(arrA[i], arrLive[i], subset) {
+				return false
+			}
+		}
+		return true
+	}
+	return indexKeyValue(a) == indexKeyValue(live)
+}
+
+// toIndexDocument converts the documents of the declared and listed
+// options to a bson.D
+func toIndexDocument(v interface{}) (bson.D, bool) {
+	switch d := v.(type) {
+	case bson.D:
+		return d, true
+	case bson.M:
+		var doc = make(bson.D, 0, len(d))
+		for key, value := range d {
+			doc = append(doc, bson.E{Key: key, Value: value})
+		}
+		return doc, true
+	case map[string]interface{}:
+		return toIndexDocument(bson.M(d))
+	}
+	return nil, false
+}
+
+func indexDocument(v interface{}) bson.D {
+	doc, _ := toIndexDocument(v)
+	return doc
+}
+
+func indexKeyValue(v interface{}) string {
+	switch n := v.(type) {
+	case int:
+		return strconv.Itoa(n)
+	case int32:
+		return strconv.Itoa(int(n))
+	case int64:
+		return strconv.FormatInt(n, 10)
+	case float64:
+		return strconv.FormatFloat(n, 'g', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// createIndexSpecs creates the indexes with all their options
+func (m *Mongo) createIndexSpecs(db, coll string, specs ...IndexSpec) error {
+	ctx, cancel := context.WithTimeout(m.context(), m.writeTimeout)
+	defer cancel()
+	var indexes = make(bson.A, len(specs))
+	for i, spec := range specs {
+		indexes[i] = spec.document()
+	}
+	return m.conn.Database(db).RunCommand(ctx, bson.D{
+		{Key: "createIndexes", Value: coll},
+		{Key: "indexes", Value: indexes},
+	}).Err()
+}
+
+// SyncIndexes makes the indexes of the collection match specs: missing
+// indexes are created, changed ones are recreated and the ones that are
+// not declared are dropped, except the _id index. With dryRun the changes
+// are only returned.
+//
+// The missing indexes are created before anything is dropped. A changed
+// index has to be dropped before it is recreated under its name, if that
+// fails the previous index is restored.
+func (m *Mongo) SyncIndexes(db, coll string, specs []IndexSpec, dryRun bool) (*IndexChanges, error) {
+	live, err := m.liveIndexes(db, coll)
+	if err != nil {
+		return nil, err
+	}
+	var changes = &IndexChanges{}
+	var declared = make(map[string]bool, len(specs))
+	var missing, changed []IndexSpec
+	for _, spec := range specs {
+		var name = spec.name()
+		declared[name] = true
+		if current, ok := live[name]; ok {
+			if spec.sameAs(current) {
+				continue
+			}
+			changes.Dropped = append(changes.Dropped, name)
+			changed = append(changed, spec)
+		} else {
+			missing = append(missing, spec)
+		}
+		changes.Created = append(changes.Created, name)
+	}
+	var unused []string
+	for name := range live {
+		if name != "_id_" && !declared[name] {
+			unused = append(unused, name)
+			changes.Dropped = append(changes.Dropped, name)
+		}
+	}
+	sort.Strings(changes.Dropped)
+	if dryRun {
+		return changes, nil
+	}
+	if len(missing) > 0 {
+		if err := m.createIndexSpecs(db, coll, missing...); err != nil {
+			return nil, err
+		}
+	}
+	for _, spec := range changed {
+		if err := m.DropIndex(db, coll, spec.name()); err != nil {
+			return nil, err
+		}
+		if err := m.createIndexSpecs(db, coll, spec); err != nil {
+			var previous = live[spec.name()]
+			delete(previous.Options, "ns")
+			if restoreErr := m.createIndexSpecs(db, coll, previous); restoreErr != nil {
+				return nil, errors.New("failed to recreate the index " + spec.name() + ", got error: " + err.Error() +
+					", and to restore it, got error: " + restoreErr.Error())
+			}
+			return nil, err
+		}
+	}
+	sort.Strings(unused)
+	for _, name := range unused {
+		if err := m.DropIndex(db, coll, name); err != nil {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
+
+// liveIndexes returns the indexes of the collection by name, none if the
+// collection doesn't exist
+func (m *Mongo) liveIndexes(db, coll string) (map[string]IndexSpec, error) {
+	ctx, cancel := context.WithTimeout(m.context(), m.readTimeout)
+	defer cancel()
+	var live = map[string]IndexSpec{}
+	cur, err := m.collection(db, coll).Indexes().List(ctx)
+	if err != nil {
+		if e, ok := err.(mongo.CommandError); ok && e.Code == namespaceNotFound {
+			return live, nil
+		}
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var spec IndexSpec
+		if err := cur.Decode(&spec); err != nil {
+			return nil, err
+		}
+		live[spec.Name] = spec
+	}
+	return live, cur.Err()
+}
@@ -0,0 +1,103 @@
+package mongoadapter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestMongo_CreateIndexes(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	var coll = "indexed"
+	_ = m.conn.Database(mongoDatabase).Collection(coll).Drop(context.Background())
+
+	names, err := m.CreateIndexes(mongoDatabase, coll,
+		mongo.IndexModel{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+		mongo.IndexModel{Keys: bson.D{{Key: "city", Value: 1}, {Key: "age", Value: -1}}},
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"email_1", "city_1_age_-1"}, names)
+
+	indexes, err := m.ListIndexes(mongoDatabase, coll)
+	assert.Nil(t, err)
+	assert.Len(t, indexes, 3)
+
+	assert.Nil(t, m.DropIndex(mongoDatabase, coll, "email_1"))
+	indexes, err = m.ListIndexes(mongoDatabase, coll)
+	assert.Nil(t, err)
+	assert.Len(t, indexes, 2)
+	assert.Error(t, m.DropIndex(mongoDatabase, coll, "email_1"))
+}
+
+func TestIndexSpec_sameAs(t *testing.T) {
+	var spec = IndexSpec{Key: bson.D{{Key: "city", Value: int32(1)}, {Key: "age", Value: int32(-1)}}}
+	assert.Equal(t, "city_1_age_-1", spec.name())
+	var live = IndexSpec{Name: "city_1_age_-1", Key: bson.D{{Key: "city", Value: 1.0}, {Key: "age", Value: -1.0}}}
+	assert.True(t, spec.sameAs(live))
+	live.Unique = true
+	assert.False(t, spec.sameAs(live))
+}
+
+func TestIndexSpec_sameAs_options(t *testing.T) {
+	// as listed by the server
+	raw, err := bson.Marshal(bson.D{
+		{Key: "v", Value: int32(2)},
+		{Key: "key", Value: bson.D{{Key: "_fts", Value: "text"}, {Key: "_ftsx", Value: int32(1)}}},
+		{Key: "name", Value: "title_text_body_text"},
+		{Key: "weights", Value: bson.D{{Key: "body", Value: int32(1)}, {Key: "title", Value: int32(1)}}},
+		{Key: "default_language", Value: "english"},
+		{Key: "language_override", Value: "language"},
+		{Key: "textIndexVersion", Value: int32(3)},
+	})
+	assert.Nil(t, err)
+	var live IndexSpec
+	assert.Nil(t, bson.Unmarshal(raw, &live))
+	var text = IndexSpec{Key: bson.D{{Key: "title", Value: "text"}, {Key: "body", Value: "text"}}}
+	assert.True(t, text.sameAs(live), "text indexes are compared by their fields")
+	text.Options = bson.M{"default_language": "french"}
+	assert.False(t, text.sameAs(live))
+
+	var partial = IndexSpec{
+		Key:     bson.D{{Key: "email", Value: 1}},
+		Options: bson.M{"partialFilterExpression": bson.M{"age": bson.M{"$gt": 18}}, "collation": bson.M{"locale": "fr"}},
+	}
+	var listed = IndexSpec{Name: "email_1", Key: bson.D{{Key: "email", Value: int32(1)}}, Options: bson.M{
+		"v":                       int32(2),
+		"partialFilterExpression": bson.D{{Key: "age", Value: bson.D{{Key: "$gt", Value: 18.0}}}},
+		"collation":               bson.D{{Key: "locale", Value: "fr"}, {Key: "strength", Value: int32(3)}},
+	}}
+	assert.True(t, partial.sameAs(listed), "the server fills in the collation")
+	listed.Options["partialFilterExpression"] = bson.D{{Key: "age", Value: bson.D{{Key: "$gt", Value: 21}}}}
+	assert.False(t, partial.sameAs(listed))
+	delete(partial.Options, "partialFilterExpression")
+	assert.False(t, partial.sameAs(listed), "an option that is no longer declared is a change")
+}
+
+func TestMongo_SyncIndexes(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	var coll = "syncIndexes"
+	_ = m.conn.Database(mongoDatabase).Collection(coll).Drop(context.Background())
+	_, err := m.AddUniqueIndex(mongoDatabase, coll, "legacy")
+	assert.Nil(t, err)
+
+	var specs = []IndexSpec{
+		{Key: bson.D{{Key: "email", Value: 1}}, Unique: true},
+		{Key: bson.D{{Key: "city", Value: 1}, {Key: "age", Value: -1}}},
+		{Key: bson.D{{Key: "bio", Value: "text"}}},
+	}
+	changes, err := m.SyncIndexes(mongoDatabase, coll, specs, true)
+	assert.Nil(t, err)
+	assert.Equal(t, &IndexChanges{Created: []string{"email_1", "city_1_age_-1", "bio_text"}, Dropped: []string{"legacy_1"}}, changes)
+	indexes, _ := m.ListIndexes(mongoDatabase, coll)
+	assert.Len(t, indexes, 2, "a dry run changes nothing")
+
+	_, err = m.SyncIndexes(mongoDatabase, coll, specs, false)
+	assert.Nil(t, err)
+	changes, err = m.SyncIndexes(mongoDatabase, coll, specs, false)
+	assert.Nil(t, err)
+	assert.Equal(t, &IndexChanges{}, changes)
+}
@@ -0,0 +1,316 @@
+package mongoadapter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx"
+)
+
+// Codec encodes the values of a KVStore
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// Codecs available for KVStore
+var (
+	BSONCodec Codec = bsonCodec{}
+	JSONCodec Codec = jsonCodec{}
+	// RawCodec stores []byte and string values as they are
+	RawCodec Codec = rawCodec{}
+)
+
+type bsonCodec struct{}
+
+// Marshal wraps v in a document, so that values other than documents can be stored
+func (bsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return bson.Marshal(bson.M{"v": v})
+}
+
+func (bsonCodec) Unmarshal(data []byte, v interface{}) error {
+	value, err := bson.Raw(data).LookupErr("v")
+	if err != nil {
+		return err
+	}
+	return value.Unmarshal(v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch value := v.(type) {
+	case []byte:
+		return value, nil
+	case string:
+		return []byte(value), nil
+	}
+	return nil, errors.New("raw codec only stores []byte and string values")
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	switch value := v.(type) {
+	case *[]byte:
+		*value = append([]byte(nil), data...)
+		return nil
+	case *string:
+		*value = string(data)
+		return nil
+	}
+	return errors.New("raw codec only reads into *[]byte and *string")
+}
+
+// KVOptions configures NewKVStore()
+type KVOptions struct {
+	// Collection keeps the entries, defaults to "kv"
+	Collection string
+	// Codec encodes the values, defaults to BSONCodec
+	Codec Codec
+}
+
+// KVStore is a key-value store for small shared values. Expired entries are
+// removed by a TTL index and, since the index only runs every minute,
+// ignored on read.
+type KVStore struct {
+	m       *Mongo
+	db      string
+	options KVOptions
+}
+
+// KVItem is an entry returned by GetMany()
+type KVItem struct {
+	Key       string
+	ExpiresAt time.Time
+	value     bson.RawValue
+	codec     Codec
+}
+
+// Decode decodes the value of the entry into v
+func (item *KVItem) Decode(v interface{}) error {
+	return decodeKV(item.value, item.codec, v)
+}
+
+type kvDocument struct {
+	Key       string        `bson:"_id"`
+	Value     bson.RawValue `bson:"value"`
+	ExpiresAt *time.Time    `bson:"expiresAt,omitempty"`
+}
+
+// kvWrite is the stored form of a value encoded by the codec
+type kvWrite struct {
+	Key       string           `bson:"_id"`
+	Value     primitive.Binary `bson:"value"`
+	ExpiresAt *time.Time       `bson:"expiresAt,omitempty"`
+}
+
+// NewKVStore creates a KVStore on the given database and its TTL index
+func NewKVStore(m *Mongo, db string, opts KVOptions) (*KVStore, error) {
+	if opts.Collection == "" {
+		opts.Collection = "kv"
+	}
+	if opts.Codec == nil {
+		opts.Codec = BSONCodec
+	}
+	ctx, cancel := context.WithTimeout(m.context(), m.writeTimeout)
+	defer cancel()
+	_, err := m.collection(db, opts.Collection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bsonx.Doc{{Key: "expiresAt", Value: bsonx.Int32(1)}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &KVStore{m: m, db: db, options: opts}, nil
+}
+
+func (s *KVStore) coll() *mongo.Collection {
+	return s.m.collection(s.db, s.options.Collection)
+}
+
+// Get decodes the value of key into v, it returns mongo.ErrNoDocuments if
+// the key does not exist or expired
+func (s *KVStore) Get(ctx context.Context, key string, v interface{}) error {
+	var doc kvDocument
+	err := s.coll().FindOne(ctx, andFilter(bson.M{"_id": key}, notExpired())).Decode(&doc)
+	if err != nil {
+		return err
+	}
+	return decodeKV(doc.Value, s.options.Codec, v)
+}
+
+// Set stores v under key, a zero ttl keeps it until it is deleted
+func (s *KVStore) Set(ctx context.Context, key string, v interface{}, ttl time.Duration) error {
+	doc, err := s.document(key, v, ttl)
+	if err != nil {
+		return err
+	}
+	_, err = s.coll().ReplaceOne(ctx, bson.M{"_id": key}, doc, options.Replace().SetUpsert(true))
+	return err
+}
+
+// SetNX stores v under key only if the key does not exist or expired, it
+// reports whether the value was stored
+func (s *KVStore) SetNX(ctx context.Context, key string, v interface{}, ttl time.Duration) (bool, error) {
+	doc, err := s.document(key, v, ttl)
+	if err != nil {
+		return false, err
+	}
+	var update = bson.M{"$set": bson.M{"value": doc.Value}}
+	if doc.ExpiresAt != nil {
+		update["$set"].(bson.M)["expiresAt"] = doc.ExpiresAt
+	} else {
+		update["$unset"] = bson.M{"expiresAt": ""}
+	}
+	// keys without a TTL never match, the upsert then fails on their _id
+	var filter = bson.M{"_id": key, "expiresAt": bson.M{"$lte": time.Now().UTC()}}
+	_, err = s.coll().UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if s.m.IsDupError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Incr adds delta to the integer stored under key and returns the result.
+// A missing or expired key starts from 0 and has no TTL. Values written by
+// Incr are stored as BSON integers whatever the codec, and can be read with
+// Get() into any integer type.
+func (s *KVStore) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	var doc kvDocument
+	var find = func() error {
+		return s.coll().FindOneAndUpdate(ctx,
+			andFilter(bson.M{"_id": key}, notExpired()),
+			bson.M{"$inc": bson.M{"value": delta}},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+		).Decode(&doc)
+	}
+	err := find()
+	if isDuplicateKey(s.m, err) {
+		// the key expired but was not removed yet
+		_, err = s.coll().UpdateOne(ctx,
+			bson.M{"_id": key, "expiresAt": bson.M{"$lte": time.Now().UTC()}},
+			bson.M{"$set": bson.M{"value": int64(0)}, "$unset": bson.M{"expiresAt": ""}})
+		if err == nil {
+			err = find()
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	var value int64
+	err = doc.Value.Unmarshal(&value)
+	return value, err
+}
+
+// Expire sets the TTL of an existing key, a zero ttl removes it
+func (s *KVStore) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	var update = bson.M{"$unset": bson.M{"expiresAt": ""}}
+	if ttl > 0 {
+		update = bson.M{"$set": bson.M{"expiresAt": time.Now().UTC().Add(ttl)}}
+	}
+	res, err := s.coll().UpdateOne(ctx, andFilter(bson.M{"_id": key}, notExpired()), update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// Delete removes the given keys, missing keys are ignored
+func (s *KVStore) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := s.coll().DeleteMany(ctx, bson.M{"_id": bson.M{"$in": keys}})
+	return err
+}
+
+// GetMany returns the entries of the given keys that exist and did not expire
+func (s *KVStore) GetMany(ctx context.Context, keys []string) (map[string]*KVItem, error) {
+	var items = make(map[string]*KVItem, len(keys))
+	if len(keys) == 0 {
+		return items, nil
+	}
+	cur, err := s.coll().Find(ctx, andFilter(bson.M{"_id": bson.M{"$in": keys}}, notExpired()))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var doc kvDocument
+		if err := cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+		var item = &KVItem{Key: doc.Key, value: doc.Value, codec: s.options.Codec}
+		if doc.ExpiresAt != nil {
+			item.ExpiresAt = *doc.ExpiresAt
+		}
+		items[doc.Key] = item
+	}
+	return items, cur.Err()
+}
+
+// SetMany stores all the values in a single round-trip
+func (s *KVStore) SetMany(ctx context.Context, values map[string]interface{}, ttl time.Duration) error {
+	if len(values) == 0 {
+		return nil
+	}
+	var models = make([]mongo.WriteModel, 0, len(values))
+	for key, v := range values {
+		doc, err := s.document(key, v, ttl)
+		if err != nil {
+			return err
+		}
+		models = append(models, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"_id": key}).SetReplacement(doc).SetUpsert(true))
+	}
+	_, err := s.coll().BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+func (s *KVStore) document(key string, v interface{}, ttl time.Duration) (*kvWrite, error) {
+	data, err := s.options.Codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc = &kvWrite{Key: key, Value: primitive.Binary{Data: data}}
+	if ttl > 0 {
+		var expiresAt = time.Now().UTC().Add(ttl)
+		doc.ExpiresAt = &expiresAt
+	}
+	return doc, nil
+}
+
+func notExpired() bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"expiresAt": nil},
+		bson.M{"expiresAt": bson.M{"$gt": time.Now().UTC()}},
+	}}
+}
+
+// decodeKV decodes binary values with the codec and the integers of Incr() directly
+func decodeKV(value bson.RawValue, codec Codec, v interface{}) error {
+	if value.Type == bsontype.Binary {
+		_, data := value.Binary()
+		return codec.Unmarshal(data, v)
+	}
+	return value.Unmarshal(v)
+}
@@ -0,0 +1,92 @@
+package mongoadapter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createTestKVStore(t *testing.T, codec Codec) *KVStore {
+	m, _ := NewMongo(mongoConfig)
+	_ = m.conn.Database(mongoDatabase).Collection("testKV").Drop(context.Background())
+	store, err := NewKVStore(m, mongoDatabase, KVOptions{Collection: "testKV", Codec: codec})
+	assert.Nil(t, err)
+	return store
+}
+
+func TestKVStore_Set(t *testing.T) {
+	store := createTestKVStore(t, nil)
+	m := store.m
+	ctx := context.Background()
+
+	assert.Nil(t, store.Set(ctx, "user", DummyUser{Name: "sara", Email: "sara@example.com"}, 0))
+	var user DummyUser
+	assert.Nil(t, store.Get(ctx, "user", &user))
+	assert.Equal(t, "sara", user.Name)
+
+	assert.Nil(t, store.Set(ctx, "short", "lived", 50*time.Millisecond))
+	time.Sleep(100 * time.Millisecond)
+	var value string
+	assert.True(t, m.NoDocument(store.Get(ctx, "short", &value)))
+
+	ok, err := store.SetNX(ctx, "short", "again", 0)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = store.SetNX(ctx, "short", "twice", 0)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Nil(t, store.Get(ctx, "short", &value))
+	assert.Equal(t, "again", value)
+
+	assert.Nil(t, store.Delete(ctx, "user", "short"))
+	assert.True(t, m.NoDocument(store.Get(ctx, "user", &user)))
+}
+
+func TestKVStore_Incr(t *testing.T) {
+	store := createTestKVStore(t, JSONCodec)
+	ctx := context.Background()
+
+	n, err := store.Incr(ctx, "hits", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), n)
+	n, _ = store.Incr(ctx, "hits", 5)
+	assert.Equal(t, int64(6), n)
+	var hits int
+	assert.Nil(t, store.Get(ctx, "hits", &hits))
+	assert.Equal(t, 6, hits)
+
+	// an expired counter restarts from 0
+	assert.Nil(t, store.Expire(ctx, "hits", 50*time.Millisecond))
+	time.Sleep(100 * time.Millisecond)
+	n, err = store.Incr(ctx, "hits", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), n)
+}
+
+func TestKVStore_SetMany(t *testing.T) {
+	store := createTestKVStore(t, RawCodec)
+	ctx := context.Background()
+
+	assert.Nil(t, store.SetMany(ctx, map[string]interface{}{"a": "1", "b": []byte("2")}, time.Minute))
+	items, err := store.GetMany(ctx, []string{"a", "b", "c"})
+	assert.Nil(t, err)
+	assert.Len(t, items, 2)
+	var value string
+	assert.Nil(t, items["b"].Decode(&value))
+	assert.Equal(t, "2", value)
+	assert.False(t, items["a"].ExpiresAt.IsZero())
+}
+
+func TestCodecs(t *testing.T) {
+	for _, codec := range []Codec{BSONCodec, JSONCodec} {
+		data, err := codec.Marshal(map[string]int{"a": 1})
+		assert.Nil(t, err)
+		var out map[string]int
+		assert.Nil(t, codec.Unmarshal(data, &out))
+		assert.Equal(t, map[string]int{"a": 1}, out)
+	}
+	_, err := RawCodec.Marshal(42)
+	assert.Error(t, err)
+}
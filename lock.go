@@ -0,0 +1,269 @@
+package mongoadapter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx"
+)
+
+// ErrLockHeld is returned by TryAcquire when another owner holds the lock
+var ErrLockHeld = errors.New("lock is held by another owner")
+
+// ErrLockLost is returned when a lease expired and was taken over, or was
+// released, before being refreshed or validated
+var ErrLockLost = errors.New("lock is not held anymore")
+
+// LockerOptions configures NewLocker()
+type LockerOptions struct {
+	// Collection keeps the locks, defaults to "locks". Fencing counters are
+	// kept in Collection+"_fencing".
+	Collection string
+	// TTL is how long a lease lasts without being refreshed, defaults to 30 seconds
+	TTL time.Duration
+	// RefreshInterval is how often leases are renewed in the background,
+	// defaults to a third of TTL. A negative value disables renewal.
+	RefreshInterval time.Duration
+	// RetryInterval is how often Acquire() retries, defaults to a second
+	RetryInterval time.Duration
+}
+
+// Locker hands out named, mutually exclusive leases stored in a collection.
+// Expiry is checked against the clock of the process acquiring the lock,
+// so TTL must be well above the clock skew between the replicas.
+type Locker struct {
+	m       *Mongo
+	db      string
+	options LockerOptions
+	// acquired is called between winning a lock and drawing its token, tests
+	// use it to stall an acquisition
+	acquired func(name string)
+}
+
+// Lease is a held lock. Token is the fencing token of the lease: it grows
+// with every acquisition of the same lock, so a resource that remembers the
+// highest token it has seen can reject the writes of stale holders.
+type Lease struct {
+	Name      string
+	Owner     string
+	Token     int64
+	ExpiresAt time.Time
+
+	locker *Locker
+	mu     sync.Mutex
+	stop   chan struct{}
+	lost   chan struct{}
+	done   sync.Once
+}
+
+type lockDocument struct {
+	Name      string    `bson:"_id"`
+	Owner     string    `bson:"owner"`
+	Token     int64     `bson:"token"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+// NewLocker creates a Locker on the given database and the TTL index that
+// removes locks a day after they expired
+func NewLocker(m *Mongo, db string, opts LockerOptions) (*Locker, error) {
+	if opts.Collection == "" {
+		opts.Collection = "locks"
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = 30 * time.Second
+	}
+	if opts.RefreshInterval == 0 {
+		opts.RefreshInterval = opts.TTL / 3
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = time.Second
+	}
+	ctx, cancel := context.WithTimeout(m.context(), m.writeTimeout)
+	defer cancel()
+	_, err := m.collection(db, opts.Collection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bsonx.Doc{{Key: "expiresAt", Value: bsonx.Int32(1)}},
+		Options: options.Index().SetExpireAfterSeconds(24 * 60 * 60),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Locker{m: m, db: db, options: opts}, nil
+}
+
+// TryAcquire takes the named lock if it is free or expired, it returns
+// ErrLockHeld otherwise. The lease is renewed in the background until
+// Release() is called or renewal fails, see Lease.Lost().
+func (l *Locker) TryAcquire(ctx context.Context, name string) (*Lease, error) {
+	var owner = xid.New().String()
+	var now = time.Now().UTC()
+	var expiresAt = now.Add(l.options.TTL)
+	var filter = bson.M{"_id": name, "expiresAt": bson.M{"$lte": now}}
+	// the token of the previous holder is removed along with its ownership,
+	// so that it fails Validate() until the new token is set
+	var update = bson.M{"$set": bson.M{"owner": owner, "expiresAt": expiresAt}, "$unset": bson.M{"token": ""}}
+	_, err := l.m.collection(l.db, l.options.Collection).UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if l.m.IsDupError(err) {
+		return nil, ErrLockHeld
+	}
+	if err != nil {
+		return nil, err
+	}
+	if l.acquired != nil {
+		l.acquired(name)
+	}
+
+	// the token is drawn once the lock is won and only stored while the lock
+	// is still ours: a holder overtaken in between draws a token that is
+	// never used, so the tokens of the leases follow the acquisitions
+	token, err := l.nextToken(ctx, name)
+	if err != nil {
+		_ = l.release(ctx, name, owner)
+		return nil, err
+	}
+	res, err := l.m.collection(l.db, l.options.Collection).UpdateOne(ctx,
+		bson.M{"_id": name, "owner": owner}, bson.M{"$set": bson.M{"token": token}})
+	if err == nil && res.MatchedCount == 0 {
+		err = ErrLockLost
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var lease = &Lease{
+		Name:      name,
+		Owner:     owner,
+		Token:     token,
+		ExpiresAt: expiresAt,
+		locker:    l,
+		stop:      make(chan struct{}),
+		lost:      make(chan struct{}),
+	}
+	if l.options.RefreshInterval > 0 {
+		go lease.keepAlive()
+	}
+	return lease, nil
+}
+
+// Acquire waits until the named lock can be taken or ctx is done
+func (l *Locker) Acquire(ctx context.Context, name string) (*Lease, error) {
+	for {
+		lease, err := l.TryAcquire(ctx, name)
+		if err != ErrLockHeld {
+			return lease, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(l.options.RetryInterval):
+		}
+	}
+}
+
+// Validate checks that token is the token of the current, unexpired holder
+// of the named lock, it returns ErrLockLost otherwise
+func (l *Locker) Validate(ctx context.Context, name string, token int64) error {
+	var lock lockDocument
+	err := l.m.collection(l.db, l.options.Collection).FindOne(ctx, bson.M{"_id": name}).Decode(&lock)
+	if l.m.NoDocument(err) {
+		return ErrLockLost
+	}
+	if err != nil {
+		return err
+	}
+	// a lock without token is still being acquired
+	if lock.Token == 0 || lock.Token != token || !lock.ExpiresAt.After(time.Now()) {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// nextToken increments the fencing counter of the lock. The counter is kept
+// apart from the lock document so that it survives its TTL removal.
+func (l *Locker) nextToken(ctx context.Context, name string) (int64, error) {
+	var counter struct {
+		Value int64 `bson:"value"`
+	}
+	err := l.m.collection(l.db, l.options.Collection+"_fencing").FindOneAndUpdate(ctx,
+		bson.M{"_id": name},
+		bson.M{"$inc": bson.M{"value": int64(1)}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	return counter.Value, err
+}
+
+func (l *Locker) release(ctx context.Context, name, owner string) error {
+	var past = time.Unix(0, 0).UTC()
+	_, err := l.m.collection(l.db, l.options.Collection).UpdateOne(ctx,
+		bson.M{"_id": name, "owner": owner}, bson.M{"$set": bson.M{"expiresAt": past, "owner": ""}})
+	return err
+}
+
+// Refresh extends the lease by the locker's TTL, it returns ErrLockLost if
+// the lease expired and was taken over or was released
+func (lease *Lease) Refresh(ctx context.Context) error {
+	lease.mu.Lock()
+	defer lease.mu.Unlock()
+	var l = lease.locker
+	var now = time.Now().UTC()
+	var expiresAt = now.Add(l.options.TTL)
+	res, err := l.m.collection(l.db, l.options.Collection).UpdateOne(ctx,
+		bson.M{"_id": lease.Name, "owner": lease.Owner, "token": lease.Token},
+		bson.M{"$set": bson.M{"expiresAt": expiresAt}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrLockLost
+	}
+	lease.ExpiresAt = expiresAt
+	return nil
+}
+
+// Release gives the lock up and stops the background renewal
+func (lease *Lease) Release(ctx context.Context) error {
+	lease.stopRenewal()
+	return lease.locker.release(ctx, lease.Name, lease.Owner)
+}
+
+// Lost is closed when the background renewal fails to refresh the lease,
+// the holder must then stop working on the protected resource
+func (lease *Lease) Lost() <-chan struct{} {
+	return lease.lost
+}
+
+func (lease *Lease) stopRenewal() {
+	lease.done.Do(func() {
+		close(lease.stop)
+	})
+}
+
+func (lease *Lease) keepAlive() {
+	var l = lease.locker
+	ticker := time.NewTicker(l.options.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-lease.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), l.m.writeTimeout)
+			err := lease.Refresh(ctx)
+			cancel()
+			// transient errors are retried until the lease expires
+			lease.mu.Lock()
+			var expired = !lease.ExpiresAt.After(time.Now())
+			lease.mu.Unlock()
+			if err == ErrLockLost || (err != nil && expired) {
+				close(lease.lost)
+				lease.stopRenewal()
+				return
+			}
+		}
+	}
+}
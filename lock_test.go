@@ -0,0 +1,128 @@
+package mongoadapter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func createTestLocker(t *testing.T, opts LockerOptions) *Locker {
+	m, _ := NewMongo(mongoConfig)
+	opts.Collection = "testLocks"
+	_ = m.conn.Database(mongoDatabase).Collection(opts.Collection).Drop(context.Background())
+	locker, err := NewLocker(m, mongoDatabase, opts)
+	assert.Nil(t, err)
+	return locker
+}
+
+func TestLocker_TryAcquire(t *testing.T) {
+	locker := createTestLocker(t, LockerOptions{})
+	ctx := context.Background()
+
+	lease, err := locker.TryAcquire(ctx, "cron")
+	assert.Nil(t, err)
+	_, err = locker.TryAcquire(ctx, "cron")
+	assert.Equal(t, ErrLockHeld, err)
+	assert.Nil(t, locker.Validate(ctx, "cron", lease.Token))
+	assert.Nil(t, lease.Refresh(ctx))
+
+	assert.Nil(t, lease.Release(ctx))
+	assert.Equal(t, ErrLockLost, lease.Refresh(ctx))
+	next, err := locker.TryAcquire(ctx, "cron")
+	assert.Nil(t, err)
+	assert.True(t, next.Token > lease.Token)
+	assert.Equal(t, ErrLockLost, locker.Validate(ctx, "cron", lease.Token))
+	_ = next.Release(ctx)
+}
+
+func TestLocker_mustTakeOverExpiredLease(t *testing.T) {
+	locker := createTestLocker(t, LockerOptions{TTL: 100 * time.Millisecond, RefreshInterval: -1})
+	ctx := context.Background()
+
+	stale, err := locker.TryAcquire(ctx, "job")
+	assert.Nil(t, err)
+	time.Sleep(150 * time.Millisecond)
+	fresh, err := locker.TryAcquire(ctx, "job")
+	assert.Nil(t, err)
+	assert.Equal(t, ErrLockLost, stale.Refresh(ctx))
+	assert.Equal(t, ErrLockLost, locker.Validate(ctx, "job", stale.Token))
+	_ = fresh.Release(ctx)
+}
+
+func TestLocker_Acquire_mustWaitAndKeepAlive(t *testing.T) {
+	locker := createTestLocker(t, LockerOptions{TTL: 300 * time.Millisecond, RetryInterval: 50 * time.Millisecond})
+	ctx := context.Background()
+
+	lease, err := locker.Acquire(ctx, "singleton")
+	assert.Nil(t, err)
+	// the lease outlives its TTL thanks to the background renewal
+	time.Sleep(time.Second)
+	assert.Nil(t, locker.Validate(ctx, "singleton", lease.Token))
+
+	waitCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	_, err = locker.Acquire(waitCtx, "singleton")
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = lease.Release(ctx)
+	}()
+	next, err := locker.Acquire(ctx, "singleton")
+	assert.Nil(t, err)
+	_ = next.Release(ctx)
+}
+
+func TestLease_Lost(t *testing.T) {
+	locker := createTestLocker(t, LockerOptions{TTL: 200 * time.Millisecond})
+	lease, err := locker.TryAcquire(context.Background(), "stolen")
+	assert.Nil(t, err)
+	_, _ = locker.m.conn.Database(mongoDatabase).Collection("testLocks").UpdateOne(context.Background(),
+		bson.M{"_id": "stolen"}, bson.M{"$set": bson.M{"owner": "someone-else"}})
+	select {
+	case <-lease.Lost():
+	case <-time.After(time.Second):
+		t.Error("lease was not reported as lost")
+	}
+}
+
+func TestLocker_tokensMustFollowAcquisitions(t *testing.T) {
+	locker := createTestLocker(t, LockerOptions{TTL: 100 * time.Millisecond, RefreshInterval: -1})
+	ctx := context.Background()
+	var stalled, resume = make(chan struct{}), make(chan struct{})
+	// only the first acquisition stalls
+	var first = make(chan struct{}, 1)
+	first <- struct{}{}
+	locker.acquired = func(name string) {
+		select {
+		case <-first:
+			close(stalled)
+			<-resume
+		default:
+		}
+	}
+
+	var errA = make(chan error, 1)
+	go func() {
+		_, err := locker.TryAcquire(ctx, "fenced")
+		errA <- err
+	}()
+	<-stalled
+	// A won the lock but stalls before getting its token, until its lease expired
+	time.Sleep(150 * time.Millisecond)
+	b, err := locker.TryAcquire(ctx, "fenced")
+	assert.Nil(t, err)
+	close(resume)
+	assert.Equal(t, ErrLockLost, <-errA, "a holder overtaken before getting its token must not get a lease")
+	assert.Nil(t, locker.Validate(ctx, "fenced", b.Token))
+
+	assert.Nil(t, b.Release(ctx))
+	c, err := locker.TryAcquire(ctx, "fenced")
+	assert.Nil(t, err)
+	assert.True(t, c.Token > b.Token, "tokens must rise in acquisition order")
+	assert.Equal(t, ErrLockLost, locker.Validate(ctx, "fenced", b.Token))
+	_ = c.Release(ctx)
+}
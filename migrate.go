@@ -0,0 +1,379 @@
+package mongoadapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MigrationFunc applies (or reverts) a single change on the given database
+type MigrationFunc func(ctx context.Context, m *Mongo, db string) error
+
+// Migration is a versioned schema/data change. Versions must be unique and
+// are applied in ascending order, a common choice is a timestamp like 20191024120000.
+// Down may be nil for irreversible migrations, rolling them back fails.
+type Migration struct {
+	Version     uint64
+	Description string
+	Up          MigrationFunc
+	Down        MigrationFunc
+}
+
+// MigrationStatus describes a registered migration and whether it is applied
+type MigrationStatus struct {
+	Version     uint64
+	Description string
+	Applied     bool
+	AppliedAt   time.Time
+}
+
+// appliedMigration is the document stored in the migrations collection
+type appliedMigration struct {
+	Version     int64     `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"appliedAt"`
+}
+
+// ErrMigrationLocked is returned when another Migrator holds the lock
+var ErrMigrationLocked = errors.New("migrations are locked by another instance")
+
+var registeredMigrationsMu sync.Mutex
+var registeredMigrations []Migration
+
+// RegisterMigration adds a migration to the global registry used by
+// NewMigrator. It is meant to be called from init() functions of the
+// packages holding the migrations and panics on an invalid or duplicate version.
+func RegisterMigration(migration Migration) {
+	registeredMigrationsMu.Lock()
+	defer registeredMigrationsMu.Unlock()
+	if migration.Version == 0 || migration.Up == nil {
+		panic("mongoadapter: migration must have a non-zero version and an Up function")
+	}
+	for _, v := range registeredMigrations {
+		if v.Version == migration.Version {
+			panic("mongoadapter: duplicate migration version " + strconv.FormatUint(migration.Version, 10))
+		}
+	}
+	registeredMigrations = append(registeredMigrations, migration)
+}
+
+// RegisteredMigrations returns a copy of the global registry sorted by version
+func RegisteredMigrations() []Migration {
+	registeredMigrationsMu.Lock()
+	defer registeredMigrationsMu.Unlock()
+	var migrations = make([]Migration, len(registeredMigrations))
+	copy(migrations, registeredMigrations)
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations
+}
+
+// MigratorConfig configures a Migrator, only DB is required
+type MigratorConfig struct {
+	DB string
+	// Collection keeps the applied versions, defaults to "migrations"
+	Collection string
+	// LockCollection keeps the lock document, defaults to Collection+"_lock"
+	LockCollection string
+	// LockTTL is how long the lock is held without being renewed, it is
+	// renewed before each migration, defaults to 10 minutes
+	LockTTL time.Duration
+	// Migrations defaults to RegisteredMigrations()
+	Migrations []Migration
+}
+
+// Migrator applies and rolls back migrations on a database, keeping track of
+// the applied versions in a collection. Only one Migrator across all instances
+// of the application can run at a time, the others fail with ErrMigrationLocked.
+type Migrator struct {
+	m          *Mongo
+	config     MigratorConfig
+	owner      string
+	migrations []Migration
+}
+
+// NewMigrator validates the config and returns a Migrator
+func NewMigrator(m *Mongo, config MigratorConfig) (*Migrator, error) {
+	if config.DB == "" {
+		return nil, errors.New("no database specified for migrator")
+	}
+	if config.Collection == "" {
+		config.Collection = "migrations"
+	}
+	if config.LockCollection == "" {
+		config.LockCollection = config.Collection + "_lock"
+	}
+	if config.LockTTL <= 0 {
+		config.LockTTL = 10 * time.Minute
+	}
+	if config.Migrations == nil {
+		config.Migrations = RegisteredMigrations()
+	}
+	var migrations = make([]Migration, len(config.Migrations))
+	copy(migrations, config.Migrations)
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, v := range migrations {
+		if v.Version == 0 || v.Up == nil {
+			return nil, errors.New("migration must have a non-zero version and an Up function")
+		}
+		if v.Version > uint64(1<<63-1) {
+			return nil, fmt.Errorf("migration version %v is too big", v.Version)
+		}
+		if i > 0 && migrations[i-1].Version == v.Version {
+			return nil, fmt.Errorf("duplicate migration version %v", v.Version)
+		}
+	}
+	return &Migrator{
+		m:          m,
+		config:     config,
+		owner:      xid.New().String(),
+		migrations: migrations,
+	}, nil
+}
+
+// Status returns every known migration with its applied state, including
+// applied versions that are no longer registered
+func (g *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := g.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var result = make([]MigrationStatus, 0, len(g.migrations))
+	for _, v := range g.migrations {
+		var status = MigrationStatus{Version: v.Version, Description: v.Description}
+		if a, ok := applied[v.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = a.AppliedAt
+			delete(applied, v.Version)
+		}
+		result = append(result, status)
+	}
+	for version, a := range applied {
+		result = append(result, MigrationStatus{
+			Version:     version,
+			Description: a.Description,
+			Applied:     true,
+			AppliedAt:   a.AppliedAt,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Version < result[j].Version
+	})
+	return result, nil
+}
+
+// Version returns the highest applied version, 0 when none is applied
+func (g *Migrator) Version(ctx context.Context) (uint64, error) {
+	applied, err := g.applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var max uint64
+	for version := range applied {
+		if version > max {
+			max = version
+		}
+	}
+	return max, nil
+}
+
+// Up applies all pending migrations in ascending order
+func (g *Migrator) Up(ctx context.Context) error {
+	return g.To(ctx, ^uint64(0))
+}
+
+// Down rolls back the last n applied migrations in descending order
+func (g *Migrator) Down(ctx context.Context, n int) error {
+	return g.withLock(ctx, func() error {
+		applied, err := g.applied(ctx)
+		if err != nil {
+			return err
+		}
+		for i := len(g.migrations) - 1; i >= 0 && n > 0; i-- {
+			if _, ok := applied[g.migrations[i].Version]; !ok {
+				continue
+			}
+			if err := g.revert(ctx, g.migrations[i]); err != nil {
+				return err
+			}
+			n--
+		}
+		return nil
+	})
+}
+
+// To applies every pending migration up to and including version and rolls
+// back every applied migration above it
+func (g *Migrator) To(ctx context.Context, version uint64) error {
+	return g.withLock(ctx, func() error {
+		applied, err := g.applied(ctx)
+		if err != nil {
+			return err
+		}
+		for i := len(g.migrations) - 1; i >= 0; i-- {
+			var v = g.migrations[i]
+			if _, ok := applied[v.Version]; ok && v.Version > version {
+				if err := g.revert(ctx, v); err != nil {
+					return err
+				}
+			}
+		}
+		for _, v := range g.migrations {
+			if _, ok := applied[v.Version]; !ok && v.Version <= version {
+				if err := g.apply(ctx, v); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (g *Migrator) apply(ctx context.Context, migration Migration) error {
+	if err := g.refreshLock(ctx); err != nil {
+		return err
+	}
+	if err := migration.Up(ctx, g.m, g.config.DB); err != nil {
+		return fmt.Errorf("migration %v up failed, got error: %v", migration.Version, err)
+	}
+	_, err := g.m.collection(g.config.DB, g.config.Collection).InsertOne(ctx, appliedMigration{
+		Version:     int64(migration.Version),
+		Description: migration.Description,
+		AppliedAt:   time.Now().UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("migration %v applied but recording it failed, got error: %v", migration.Version, err)
+	}
+	return nil
+}
+
+func (g *Migrator) revert(ctx context.Context, migration Migration) error {
+	if migration.Down == nil {
+		return fmt.Errorf("migration %v is irreversible", migration.Version)
+	}
+	if err := g.refreshLock(ctx); err != nil {
+		return err
+	}
+	if err := migration.Down(ctx, g.m, g.config.DB); err != nil {
+		return fmt.Errorf("migration %v down failed, got error: %v", migration.Version, err)
+	}
+	_, err := g.m.collection(g.config.DB, g.config.Collection).DeleteOne(ctx, bson.M{"_id": int64(migration.Version)})
+	if err != nil {
+		return fmt.Errorf("migration %v reverted but recording it failed, got error: %v", migration.Version, err)
+	}
+	return nil
+}
+
+func (g *Migrator) applied(ctx context.Context) (map[uint64]appliedMigration, error) {
+	cur, err := g.m.collection(g.config.DB, g.config.Collection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	var result = make(map[uint64]appliedMigration)
+	for cur.Next(ctx) {
+		var v appliedMigration
+		if err := cur.Decode(&v); err != nil {
+			return nil, err
+		}
+		result[uint64(v.Version)] = v
+	}
+	return result, cur.Err()
+}
+
+// withLock runs fn while holding the migrations lock
+func (g *Migrator) withLock(ctx context.Context, fn func() error) error {
+	if err := g.refreshLock(ctx); err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = g.m.collection(g.config.DB, g.config.LockCollection).DeleteOne(context.Background(),
+			bson.M{"_id": g.config.Collection, "owner": g.owner})
+	}()
+	return fn()
+}
+
+// refreshLock takes the lock if it is free, expired or already ours, and
+// extends its expiry. A concurrent holder makes the upsert hit the unique
+// _id index, which is reported as ErrMigrationLocked.
+func (g *Migrator) refreshLock(ctx context.Context) error {
+	var now = time.Now().UTC()
+	var filter = bson.M{
+		"_id": g.config.Collection,
+		"$or": bson.A{
+			bson.M{"owner": g.owner},
+			bson.M{"expiresAt": bson.M{"$lt": now}},
+		},
+	}
+	var update = bson.M{"$set": bson.M{"owner": g.owner, "expiresAt": now.Add(g.config.LockTTL)}}
+	_, err := g.m.collection(g.config.DB, g.config.LockCollection).UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if g.m.IsDupError(err) {
+		return ErrMigrationLocked
+	}
+	return err
+}
+
+// RunMigrationCommand executes a migration command as typed on a command
+// line and writes a human readable report to out. Supported commands are:
+//
+//	up                apply all pending migrations
+//	down [n]          roll back the last n migrations, 1 by default
+//	to <version>      migrate up or down to the given version
+//	status            list migrations and their state
+func RunMigrationCommand(ctx context.Context, g *Migrator, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("no migration command specified, expected one of up, down, to or status")
+	}
+	var err error
+	switch args[0] {
+	case "up":
+		err = g.Up(ctx)
+	case "down":
+		var n = 1
+		if len(args) > 1 {
+			if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+				return errors.New("invalid number of migrations to roll back: " + args[1])
+			}
+		}
+		err = g.Down(ctx, n)
+	case "to":
+		if len(args) < 2 {
+			return errors.New("no target version specified")
+		}
+		version, perr := strconv.ParseUint(args[1], 10, 64)
+		if perr != nil {
+			return errors.New("invalid target version: " + args[1])
+		}
+		err = g.To(ctx, version)
+	case "status":
+	default:
+		return errors.New("unknown migration command " + strconv.Quote(args[0]))
+	}
+	if err != nil {
+		return err
+	}
+	status, err := g.Status(ctx)
+	if err != nil {
+		return err
+	}
+	for _, v := range status {
+		var state = "pending"
+		if v.Applied {
+			state = "applied " + v.AppliedAt.Format(time.RFC3339)
+		}
+		_, _ = fmt.Fprintf(out, "%-20v %-28v %v\n", v.Version, state, v.Description)
+	}
+	return nil
+}
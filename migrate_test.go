@@ -0,0 +1,115 @@
+package mongoadapter
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func createTestMigrations(coll string) []Migration {
+	return []Migration{
+		{
+			Version:     1,
+			Description: "insert first",
+			Up: func(ctx context.Context, m *Mongo, db string) error {
+				_, err := m.InsertOne(db, coll, bson.M{"_id": "first"})
+				return err
+			},
+			Down: func(ctx context.Context, m *Mongo, db string) error {
+				_, err := m.DeleteOne(db, coll, bson.M{"_id": "first"})
+				return err
+			},
+		},
+		{
+			Version:     2,
+			Description: "insert second",
+			Up: func(ctx context.Context, m *Mongo, db string) error {
+				_, err := m.InsertOne(db, coll, bson.M{"_id": "second"})
+				return err
+			},
+			Down: func(ctx context.Context, m *Mongo, db string) error {
+				_, err := m.DeleteOne(db, coll, bson.M{"_id": "second"})
+				return err
+			},
+		},
+	}
+}
+
+func TestMigrator_UpDownTo(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	var coll = "migrated"
+	_ = m.conn.Database(mongoDatabase).Collection(coll).Drop(context.Background())
+	_ = m.conn.Database(mongoDatabase).Collection("test_migrations").Drop(context.Background())
+	migrator, err := NewMigrator(m, MigratorConfig{
+		DB:         mongoDatabase,
+		Collection: "test_migrations",
+		Migrations: createTestMigrations(coll),
+	})
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	assert.Nil(t, migrator.Up(ctx))
+	version, err := migrator.Version(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), version)
+	count, _ := m.Count(mongoDatabase, coll, bson.M{})
+	assert.Equal(t, int64(2), count)
+
+	assert.Nil(t, migrator.Down(ctx, 1))
+	version, _ = migrator.Version(ctx)
+	assert.Equal(t, uint64(1), version)
+
+	assert.Nil(t, migrator.To(ctx, 0))
+	count, _ = m.Count(mongoDatabase, coll, bson.M{})
+	assert.Equal(t, int64(0), count)
+
+	var out bytes.Buffer
+	assert.Nil(t, RunMigrationCommand(ctx, migrator, []string{"to", "1"}, &out))
+	status, _ := migrator.Status(ctx)
+	assert.True(t, status[0].Applied)
+	assert.False(t, status[1].Applied)
+	assert.Contains(t, out.String(), "pending")
+}
+
+func TestMigrator_mustAssertErrWhenLocked(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	_ = m.conn.Database(mongoDatabase).Collection("locked_migrations_lock").Drop(context.Background())
+	first, _ := NewMigrator(m, MigratorConfig{DB: mongoDatabase, Collection: "locked_migrations", Migrations: []Migration{}})
+	second, _ := NewMigrator(m, MigratorConfig{DB: mongoDatabase, Collection: "locked_migrations", Migrations: []Migration{}})
+
+	err := first.withLock(context.Background(), func() error {
+		return second.Up(context.Background())
+	})
+	assert.Equal(t, ErrMigrationLocked, err)
+	assert.Nil(t, second.Up(context.Background()))
+}
+
+func TestMigrator_mustAssertErrOnIrreversible(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	_ = m.conn.Database(mongoDatabase).Collection("irreversible_migrations").Drop(context.Background())
+	migrator, _ := NewMigrator(m, MigratorConfig{
+		DB:         mongoDatabase,
+		Collection: "irreversible_migrations",
+		LockTTL:    time.Minute,
+		Migrations: []Migration{{
+			Version: 1,
+			Up: func(ctx context.Context, m *Mongo, db string) error {
+				return nil
+			},
+		}},
+	})
+	assert.Nil(t, migrator.Up(context.Background()))
+	assert.Error(t, migrator.Down(context.Background(), 1))
+}
+
+func TestNewMigrator_mustAssertErrOnDuplicateVersion(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	var migrations = createTestMigrations("any")
+	migrations[1].Version = 1
+	_, err := NewMigrator(m, MigratorConfig{DB: mongoDatabase, Migrations: migrations})
+	assert.Error(t, err)
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/x/bsonx"
 
@@ -16,6 +17,9 @@ import (
 	//"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
 type MongoConfig struct {
@@ -23,12 +27,40 @@ type MongoConfig struct {
 	Port int
 	Username string
 	Password string
+	// The timeouts are durations, e.g. 5*time.Second. Values below a
+	// millisecond are read as a number of seconds for the configurations
+	// written before, so ReadTimeout: 5 is still five seconds.
 	ConnTimeout 	time.Duration
 	ReadTimeout 	time.Duration
 	WriteTimeout 	time.Duration
 	MaxConnIdleTime	time.Duration
+	// ServerSelectionTimeout bounds the wait for a suitable member,
+	// SocketTimeout a read or write on a connection and HeartbeatInterval
+	// is the time between two checks of the members. 0 keeps the driver's
+	// default.
+	ServerSelectionTimeout	time.Duration
+	SocketTimeout	time.Duration
+	HeartbeatInterval	time.Duration
+	// CountTimeout bounds counts, AggregateTimeout aggregations and
+	// searches, and BulkTimeout InsertMany, UpdateMany and DeleteMany.
+	// 0 falls back to ReadTimeout for counts and aggregations, and to
+	// WriteTimeout for bulk writes.
+	CountTimeout	time.Duration
+	AggregateTimeout	time.Duration
+	BulkTimeout		time.Duration
 	MaxPoolSize		uint64
 	MinPoolSize		uint64
+	// ReadPreference is one of primary, primaryPreferred, secondary,
+	// secondaryPreferred or nearest, empty means primary
+	ReadPreference	string
+	// ReadConcern is one of local, majority, available, linearizable
+	// or snapshot, empty means the server default
+	ReadConcern		string
+	// WriteConcern is "majority", the number of members that must
+	// acknowledge a write or a tag set name, empty means the server default
+	WriteConcern	string
+	// Journal requires writes to be acknowledged after the journal commit
+	Journal			bool
 }
 
 type Mongo struct {
@@ -36,6 +68,22 @@ type Mongo struct {
 	conn         *mongo.Client
 	readTimeout  time.Duration
 	writeTimeout time.Duration
+	// opTimeouts overrides readTimeout and writeTimeout per operation
+	opTimeouts   operationTimeouts
+	pool         *poolStats
+	readPref     *readpref.ReadPref
+	readConcern  *readconcern.ReadConcern
+	writeConcern *writeconcern.WriteConcern
+	registry     *settingsRegistry
+	ctx          context.Context
+	// includeDeleted makes reads return soft-deleted documents
+	includeDeleted bool
+	// skipAudit is set on the view used by the audit for its own writes
+	skipAudit bool
+	// chunkSize is the GridFS chunk size of the view's uploads
+	chunkSize int32
+	// codecs replaces the driver's default registry when set
+	codecs *bsoncodec.Registry
 }
 
 type TotalCount struct {
@@ -84,14 +132,59 @@ func NewMongo(Config *MongoConfig) (*Mongo, error) {
 	var mainErr error
 
 	mongoOnceCollection[uri].Do(func() {
-		ctx, cancel := context.WithTimeout(context.Background(), Config.ConnTimeout*time.Second)
+		var connTimeout = legacyDuration(Config.ConnTimeout)
+		if connTimeout == 0 {
+			connTimeout = defaultTimeout
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), connTimeout)
 		defer cancel()
 		var uid = xid.New()
 		var mongoUri = fmt.Sprintf("mongodb://%v%v:%v", auth, Config.Host, Config.Port)
-		clientOptions := options.Client().ApplyURI(mongoUri)
+		var pool = &poolStats{}
+		clientOptions := options.Client().ApplyURI(mongoUri).SetPoolMonitor(pool.monitor()).
+			SetConnectTimeout(connTimeout)
+
+		if d := legacyDuration(Config.ServerSelectionTimeout); d != 0 {
+			clientOptions.SetServerSelectionTimeout(d)
+		}
+
+		if d := legacyDuration(Config.SocketTimeout); d != 0 {
+			clientOptions.SetSocketTimeout(d)
+		}
+
+		if d := legacyDuration(Config.HeartbeatInterval); d != 0 {
+			clientOptions.SetHeartbeatInterval(d)
+		}
+
+		if Config.ReadPreference != "" {
+			rp, err := parseReadPreference(Config.ReadPreference)
+			if err != nil {
+				mainErr = err
+				return
+			}
+			clientOptions.SetReadPreference(rp)
+		}
+
+		if Config.ReadConcern != "" {
+			rc, err := parseReadConcern(Config.ReadConcern)
+			if err != nil {
+				mainErr = err
+				return
+			}
+			clientOptions.SetReadConcern(rc)
+		}
+
+		if Config.WriteConcern != "" || Config.Journal {
+			wc, err := parseWriteConcern(Config.WriteConcern, Config.Journal)
+			if err != nil {
+				mainErr = err
+				return
+			}
+			clientOptions.SetWriteConcern(wc)
+		}
 
 		if Config.MaxConnIdleTime != 0 {
-			maxConnIdleTime := Config.MaxConnIdleTime * time.Second
+			maxConnIdleTime := legacyDuration(Config.MaxConnIdleTime)
 			clientOptions.MaxConnIdleTime = &maxConnIdleTime
 		}
 
@@ -111,7 +204,8 @@ func NewMongo(Config *MongoConfig) (*Mongo, error) {
 		}
 
 		// Check the connection
-		var ctx2, _ = context.WithTimeout(context.Background(), Config.ConnTimeout*time.Second)
+		ctx2, cancel2 := context.WithTimeout(context.Background(), connTimeout)
+		defer cancel2()
 		err = client.Ping(ctx2, nil)
 
 		if err != nil {
@@ -119,19 +213,27 @@ func NewMongo(Config *MongoConfig) (*Mongo, error) {
 			return
 		}
 
-		if Config.ReadTimeout == 0 {
-			Config.ReadTimeout = 5
+		var readTimeout, writeTimeout = legacyDuration(Config.ReadTimeout), legacyDuration(Config.WriteTimeout)
+		if readTimeout == 0 {
+			readTimeout = defaultTimeout
 		}
 
-		if Config.WriteTimeout == 0 {
-			Config.WriteTimeout = 5
+		if writeTimeout == 0 {
+			writeTimeout = defaultTimeout
 		}
 
 		mongoInstanceCollection[uri] = &Mongo {
 			ID:			uid.String(),
-			readTimeout:  time.Duration(Config.ReadTimeout),
-			writeTimeout: time.Duration(Config.WriteTimeout),
+			readTimeout:  readTimeout,
+			writeTimeout: writeTimeout,
+			opTimeouts:   operationTimeouts{
+				count:     legacyDuration(Config.CountTimeout),
+				aggregate: legacyDuration(Config.AggregateTimeout),
+				bulk:      legacyDuration(Config.BulkTimeout),
+			},
 			conn:         client,
+			pool:         pool,
+			registry:     newSettingsRegistry(),
 		}
 
 		return
@@ -169,14 +271,16 @@ func (m *Mongo) NoDocument(err error) bool {
 }
 
 func (m *Mongo) FindOne(db, coll string, filter interface{}, options ...*options.FindOneOptions) *mongo.SingleResult {
-	ctx, _ := context.WithTimeout(context.Background(), m.readTimeout*time.Second)
-	return m.conn.Database(db).Collection(coll).FindOne(ctx, filter, options...)
+	ctx, cancel := context.WithTimeout(m.context(), m.readTimeout)
+	// the result is decoded from the first batch, the context isn't used after
+	defer cancel()
+	return m.collection(db, coll).FindOne(ctx, m.readFilter(db, coll, filter), options...)
 }
 
 func (m *Mongo) FindMany(db, coll string, filter interface{}, options ...*options.FindOptions) (*mongo.Cursor, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), m.readTimeout*time.Second)
+	ctx, cancel := context.WithTimeout(m.context(), m.readTimeout)
 	defer cancel()
-	return m.conn.Database(db).Collection(coll).Find(ctx, filter, options...)
+	return m.collection(db, coll).Find(ctx, m.readFilter(db, coll, filter), options...)
 }
 
 // FindWhereIn given a set of column and values, it search using $in or $nin operator.
@@ -200,47 +304,157 @@ func (m *Mongo) FindWhereIn(db, coll string, negate bool, vars ...[]string) (*mo
 	var conditions = bson.D{{
 		"$or", subConditions,
 	}}
-	ctx, cancel := context.WithTimeout(context.Background(), m.readTimeout*time.Second)
+	ctx, cancel := context.WithTimeout(m.context(), m.readTimeout)
 	defer cancel()
-	return m.conn.Database(db).Collection(coll).Find(ctx, conditions)
+	return m.collection(db, coll).Find(ctx, m.readFilter(db, coll, conditions))
 }
 
 // Inserts one record into the given collection of given db
 func (m *Mongo) InsertOne(db, coll string, doc interface{}) (*mongo.InsertOneResult, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), m.writeTimeout*time.Second)
+	defer m.invalidateCache(db, coll)
+	ctx, cancel := context.WithTimeout(m.context(), m.writeTimeout)
 	defer cancel()
-	return m.conn.Database(db).Collection(coll).InsertOne(ctx, doc)
+	var s = m.settings(db, coll)
+	docs, err := m.prepareInsert(s, []interface{}{doc})
+	if err != nil {
+		return nil, err
+	}
+	var res *mongo.InsertOneResult
+	err = m.insertAudited(db, coll, s, docs, func(docs []interface{}) (err error) {
+		res, err = m.collection(db, coll).InsertOne(ctx, docs[0])
+		return err
+	})
+	return res, validationError("insert", db, coll, nil, []interface{}{doc}, err)
 }
 
 // Inserts an array of record into the given collection of given db
 func (m *Mongo) InsertMany(db, coll string, docs []interface{}, options ...*options.InsertManyOptions) (*mongo.InsertManyResult, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), m.writeTimeout*time.Second)
+	defer m.invalidateCache(db, coll)
+	ctx, cancel := context.WithTimeout(m.context(), m.bulkTimeout())
 	defer cancel()
-	return m.conn.Database(db).Collection(coll).InsertMany(ctx, docs, options...)
+	var s = m.settings(db, coll)
+	prepared, err := m.prepareInsert(s, docs)
+	if err != nil {
+		return nil, err
+	}
+	var res *mongo.InsertManyResult
+	err = m.insertAudited(db, coll, s, prepared, func(docs []interface{}) (err error) {
+		res, err = m.collection(db, coll).InsertMany(ctx, docs, options...)
+		return err
+	})
+	return res, validationError("insert", db, coll, nil, docs, err)
 }
 
 func (m *Mongo) UpdateOne(db, coll string, filter interface{}, data interface{}, options... *options.UpdateOptions) (*mongo.UpdateResult, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), m.writeTimeout*time.Second)
+	defer m.invalidateCache(db, coll)
+	if audit := m.audited(m.settings(db, coll)); audit != nil {
+		var res *mongo.UpdateResult
+		err := m.auditWrite(db, coll, audit, AuditUpdate, filter, false, func(v *Mongo, filter interface{}) (interface{}, error) {
+			var err error
+			if res, err = v.UpdateOne(db, coll, filter, data, options...); err != nil {
+				return nil, err
+			}
+			return res.UpsertedID, nil
+		})
+		return res, err
+	}
+	ctx, cancel := context.WithTimeout(m.context(), m.writeTimeout)
 	defer cancel()
-	return m.conn.Database(db).Collection(coll).UpdateOne(ctx, filter, data, options...)
+	update, err := m.prepareUpdate(m.settings(db, coll), data, options)
+	if err != nil {
+		return nil, err
+	}
+	res, err := m.collection(db, coll).UpdateOne(ctx, filter, update, options...)
+	return res, validationError("update", db, coll, filter, []interface{}{data}, err)
 }
 
 func (m *Mongo) UpdateMany(db, coll string, filter interface{}, data interface{}, options... *options.UpdateOptions) (*mongo.UpdateResult, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), m.writeTimeout*time.Second)
+	defer m.invalidateCache(db, coll)
+	if audit := m.audited(m.settings(db, coll)); audit != nil {
+		var res *mongo.UpdateResult
+		err := m.auditWrite(db, coll, audit, AuditUpdate, filter, true, func(v *Mongo, filter interface{}) (interface{}, error) {
+			var err error
+			if res, err = v.UpdateMany(db, coll, filter, data, options...); err != nil {
+				return nil, err
+			}
+			return res.UpsertedID, nil
+		})
+		return res, err
+	}
+	ctx, cancel := context.WithTimeout(m.context(), m.bulkTimeout())
+	defer cancel()
+	update, err := m.prepareUpdate(m.settings(db, coll), data, options)
+	if err != nil {
+		return nil, err
+	}
+	res, err := m.collection(db, coll).UpdateMany(ctx, filter, update, options...)
+	return res, validationError("update", db, coll, filter, []interface{}{data}, err)
+}
+
+// ReplaceOne replaces the first document matching filter with replacement
+func (m *Mongo) ReplaceOne(db, coll string, filter interface{}, replacement interface{}, options... *options.ReplaceOptions) (*mongo.UpdateResult, error) {
+	defer m.invalidateCache(db, coll)
+	if audit := m.audited(m.settings(db, coll)); audit != nil {
+		var res *mongo.UpdateResult
+		err := m.auditWrite(db, coll, audit, AuditReplace, filter, false, func(v *Mongo, filter interface{}) (interface{}, error) {
+			var err error
+			if res, err = v.ReplaceOne(db, coll, filter, replacement, options...); err != nil {
+				return nil, err
+			}
+			return res.UpsertedID, nil
+		})
+		return res, err
+	}
+	ctx, cancel := context.WithTimeout(m.context(), m.writeTimeout)
 	defer cancel()
-	return m.conn.Database(db).Collection(coll).UpdateMany(ctx, filter, data, options...)
+	doc, err := m.prepareReplace(m.settings(db, coll), replacement)
+	if err != nil {
+		return nil, err
+	}
+	res, err := m.collection(db, coll).ReplaceOne(ctx, filter, doc, options...)
+	return res, validationError("replace", db, coll, filter, []interface{}{replacement}, err)
 }
 
 func (m *Mongo) DeleteOne(db, coll string, filter interface{}, options... *options.DeleteOptions) (*mongo.DeleteResult, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), m.writeTimeout*time.Second)
+	defer m.invalidateCache(db, coll)
+	if audit := m.audited(m.settings(db, coll)); audit != nil {
+		var res *mongo.DeleteResult
+		err := m.auditWrite(db, coll, audit, AuditDelete, filter, false, func(v *Mongo, filter interface{}) (interface{}, error) {
+			var err error
+			if res, err = v.DeleteOne(db, coll, filter, options...); err != nil {
+				return nil, err
+			}
+			return nil, nil
+		})
+		return res, err
+	}
+	ctx, cancel := context.WithTimeout(m.context(), m.writeTimeout)
 	defer cancel()
-	return m.conn.Database(db).Collection(coll).DeleteOne(ctx, filter, options...)
+	if s := m.settings(db, coll); s.softDelete != nil {
+		return m.softDelete(db, coll, s, filter, false, options)
+	}
+	return m.collection(db, coll).DeleteOne(ctx, filter, options...)
 }
 
 func (m *Mongo) DeleteMany(db, coll string, filter interface{}, options... *options.DeleteOptions) (*mongo.DeleteResult, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), m.writeTimeout*time.Second)
+	defer m.invalidateCache(db, coll)
+	if audit := m.audited(m.settings(db, coll)); audit != nil {
+		var res *mongo.DeleteResult
+		err := m.auditWrite(db, coll, audit, AuditDelete, filter, true, func(v *Mongo, filter interface{}) (interface{}, error) {
+			var err error
+			if res, err = v.DeleteMany(db, coll, filter, options...); err != nil {
+				return nil, err
+			}
+			return nil, nil
+		})
+		return res, err
+	}
+	ctx, cancel := context.WithTimeout(m.context(), m.bulkTimeout())
 	defer cancel()
-	return m.conn.Database(db).Collection(coll).DeleteMany(ctx, filter, options...)
+	if s := m.settings(db, coll); s.softDelete != nil {
+		return m.softDelete(db, coll, s, filter, true, options)
+	}
+	return m.collection(db, coll).DeleteMany(ctx, filter, options...)
 }
 
 // returns the string of a mongoDb's ObjectID
@@ -262,36 +476,36 @@ func (m *Mongo) ToSliceString(data bson.A) []string {
 }
 
 func (m *Mongo) AddUniqueIndex(db, coll , indexKey string) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), m.writeTimeout*time.Second)
+	ctx, cancel := context.WithTimeout(m.context(), m.writeTimeout)
 	defer cancel()
 	indexModel := mongo.IndexModel{
 		Keys: bsonx.Doc{{indexKey, bsonx.Int32(1)}},
 		Options: options.Index().SetUnique(true),
 	}
-	return m.conn.Database(db).Collection(coll).Indexes().CreateOne(ctx, indexModel)
+	return m.collection(db, coll).Indexes().CreateOne(ctx, indexModel)
 }
 
 func (m *Mongo) AddTextV3Index(db, coll , indexKey string) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), m.writeTimeout*time.Second)
+	ctx, cancel := context.WithTimeout(m.context(), m.writeTimeout)
 	defer cancel()
 	indexModel := mongo.IndexModel{
 		Keys: bsonx.Doc{{indexKey, bsonx.Int32(1)}},
 		Options: options.Index().SetTextVersion(3),
 	}
-	return m.conn.Database(db).Collection(coll).Indexes().CreateOne(ctx, indexModel)
+	return m.collection(db, coll).Indexes().CreateOne(ctx, indexModel)
 }
 
 func (m *Mongo) Count(db, coll string, filters interface{}, opts ...*options.CountOptions) (int64, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), m.readTimeout*time.Second)
+	ctx, cancel := context.WithTimeout(m.context(), m.countTimeout())
 	defer cancel()
 
-	return m.conn.Database(db).Collection(coll).CountDocuments(ctx, filters, opts...)
+	return m.collection(db, coll).CountDocuments(ctx, m.readFilter(db, coll, filters), opts...)
 }
 func (m *Mongo) EstimatedCount(db, coll string, opts ...*options.EstimatedDocumentCountOptions) (int64, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), m.readTimeout*time.Second)
+	ctx, cancel := context.WithTimeout(m.context(), m.countTimeout())
 	defer cancel()
 
-	return m.conn.Database(db).Collection(coll).EstimatedDocumentCount(ctx, opts...)
+	return m.collection(db, coll).EstimatedDocumentCount(ctx, opts...)
 }
 
 // Search does an aggregation query on mongo db. It supports searching with $match and sorting with $sort
@@ -300,23 +514,20 @@ func (m *Mongo) EstimatedCount(db, coll string, opts ...*options.EstimatedDocume
 // country fields named italy, you should pass: map[string][]string{"country" : {"italy", "eq"}}
 // You can also pass several fields. Currenly, you cannot use $or, $in etc. and other operators.
 func (m *Mongo) Search(db, coll string, filters map[string][]string, sorting map[string]int, limit, skip int64) (*mongo.Cursor, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), m.readTimeout*time.Second)
+	ctx, cancel := context.WithTimeout(m.context(), m.aggregateTimeout())
 	defer cancel()
 	var rules []bson.M
 
 	// $match operator must come as the first stage in the pipelines
 	// this also has performance benefits, such as utilizing the index
 	// like FindOne() and FindMany()
-	var filteringRule = make(bson.M, len(filters))
+	var filteringRule = searchFilter(filters)
 	var filteringStmt bson.M
-	if filters != nil && len(filters) > 0 {
-		for k, v := range filters {
-			if v[1] == "like" {
-				filteringRule[k] = bson.M{"$regex" : v[0]}
-			} else if v[1] == "eq" {
-				filteringRule[k] = v[0]
-			}
-		}
+	// soft-deleted documents are excluded in the same $match stage
+	for k, v := range m.notDeleted(m.settings(db, coll)) {
+		filteringRule[k] = v
+	}
+	if len(filteringRule) > 0 {
 		filteringStmt = bson.M{"$match" : filteringRule}
 		rules = append(rules, filteringStmt)
 	}
@@ -344,28 +555,42 @@ func (m *Mongo) Search(db, coll string, filters map[string][]string, sorting map
 
 
 
-	return m.conn.Database(db).Collection(coll).Aggregate(ctx, rules)
+	return m.collection(db, coll).Aggregate(ctx, rules)
+}
+
+// searchFilter builds the $match condition of Search() from its filters,
+// "like" values are regular expressions and "eq" values are matched as is
+func searchFilter(filters map[string][]string) bson.M {
+	var filter = make(bson.M, len(filters))
+	for k, v := range filters {
+		if len(v) < 2 {
+			continue
+		}
+		if v[1] == "like" {
+			filter[k] = bson.M{"$regex": v[0]}
+		} else if v[1] == "eq" {
+			filter[k] = v[0]
+		}
+	}
+	return filter
 }
 
 // it is the same as Search(), but only returns the total count of search
 func (m *Mongo) SearchCount(db, coll string, filters map[string][]string) (int64, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), m.readTimeout*time.Second)
+	ctx, cancel := context.WithTimeout(m.context(), m.countTimeout())
 	defer cancel()
 	var rules []bson.M
 
 	// $match operator must come as the first stage in the pipeline
 	// this also has performance benefits, such as utilizing the index
 	// like FindOne() and FindMany()
-	var filteringRule = make(bson.M, len(filters))
+	var filteringRule = searchFilter(filters)
 	var filteringStmt bson.M
-	if filters != nil && len(filters) > 0 {
-		for k, v := range filters {
-			if v[1] == "like" {
-				filteringRule[k] = bson.M{"$regex" : v[0]}
-			} else if v[1] == "eq" {
-				filteringRule[k] = v[0]
-			}
-		}
+	// soft-deleted documents are excluded in the same $match stage
+	for k, v := range m.notDeleted(m.settings(db, coll)) {
+		filteringRule[k] = v
+	}
+	if len(filteringRule) > 0 {
 		filteringStmt = bson.M{"$match" : filteringRule}
 		rules = append(rules, filteringStmt)
 	}
@@ -374,7 +599,7 @@ func (m *Mongo) SearchCount(db, coll string, filters map[string][]string) (int64
 	rules = append(rules, bson.M{"$count" : "totalCount"})
 
 
-	res, err := m.conn.Database(db).Collection(coll).Aggregate(ctx, rules)
+	res, err := m.collection(db, coll).Aggregate(ctx, rules)
 	if err != nil {
 		return 0, err
 	}
@@ -391,9 +616,9 @@ func (m *Mongo) SearchCount(db, coll string, filters map[string][]string) (int64
 }
 
 func (m *Mongo) Aggregate(db, coll string, pipeline interface{}, options ...*options.AggregateOptions) (*mongo.Cursor, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), m.readTimeout*time.Second)
+	ctx, cancel := context.WithTimeout(m.context(), m.aggregateTimeout())
 	defer cancel()
-	return m.conn.Database(db).Collection(coll).Aggregate(ctx, pipeline, options...)
+	return m.collection(db, coll).Aggregate(ctx, pipeline, options...)
 }
 
 // checks to see if an error is duplicate error or not
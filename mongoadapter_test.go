@@ -22,17 +22,6 @@ import (
 
 var mongoDatabase string
 var mongoColl string
-var mongoHost string
-var mongoPort int
-var mongo2Host string
-var mongo2Port int
-var mongoReadTimeout time.Duration
-var mongoWriteTimeout time.Duration
-var mongoConnectionTimeout time.Duration
-var mongoMaxConnIdleTime time.Duration
-var mongoMaxPoolSize uint64
-var mongoMinPoolSize uint64
-
 var mongoConfig, mongo2Config *MongoConfig
 
 func CountCursor(cur *mongo.Cursor) int {
@@ -50,45 +39,15 @@ func TestMain(m *testing.M) {
 	}
 	mongoDatabase = os.Getenv("GOTEST_MONGO_DB")
 	mongoColl = os.Getenv("GOTEST_MONGO_COLL")
-	mongoHost = os.Getenv("GOTEST_MONGO_HOST")
-	mongoPort, err = strconv.Atoi(os.Getenv("GOTEST_MONGO_PORT"))
-	mongo2Host = os.Getenv("GOTEST_MONGO2_HOST")
-	mongo2Port, err = strconv.Atoi(os.Getenv("GOTEST_MONGO2_PORT"))
-	t, _ := strconv.Atoi(os.Getenv("GOTEST_MONGO_READ_TIMEOUT"))
-	mongoReadTimeout = time.Duration(t) * time.Second
-	t, _ = strconv.Atoi(os.Getenv("GOTEST_MONGO_READ_TIMEOUT"))
-	mongoWriteTimeout = time.Duration(t) * time.Second
-	t, _ = strconv.Atoi(os.Getenv("GOTEST_MONGO_CONN_TIMEOUT"))
-	mongoConnectionTimeout = time.Duration(t) * time.Second
-
-	t, _ = strconv.Atoi(os.Getenv("GOTEST_MONGO_MAX_CONN_IDLE_TIME"))
-	mongoMaxConnIdleTime = time.Duration(t) * time.Second
-	t, _ = strconv.Atoi(os.Getenv("GOTEST_MONGO_MAX_POOL_SIZE"))
-	mongoMaxPoolSize = uint64(t)
-	t, _ = strconv.Atoi(os.Getenv("GOTEST_MONGO_MIN_POOL_SIZE"))
-	mongoMinPoolSize = uint64(t)
-
-
-	mongoConfig = &MongoConfig{
-		Host: mongoHost,
-		Port: mongoPort,
-		ReadTimeout: mongoReadTimeout,
-		WriteTimeout: mongoWriteTimeout,
-		ConnTimeout: mongoConnectionTimeout,
-		MaxConnIdleTime: mongoMaxConnIdleTime,
-		MaxPoolSize: mongoMaxPoolSize,
-		MinPoolSize: mongoMinPoolSize,
+	mongoConfig, err = LoadMongoConfig("GOTEST_MONGO_")
+	if err != nil {
+		log.Panic(err)
 	}
-
-	mongo2Config = &MongoConfig{
-		Host: mongo2Host,
-		Port: mongo2Port,
-		ReadTimeout: mongoReadTimeout,
-		WriteTimeout: mongoWriteTimeout,
-		ConnTimeout: mongoConnectionTimeout,
-		MaxConnIdleTime: mongoMaxConnIdleTime,
-		MaxPoolSize: mongoMaxPoolSize,
-		MinPoolSize: mongoMinPoolSize,
+	// the second server only differs by its address
+	var config2 = *mongoConfig
+	mongo2Config = &config2
+	if err = mongo2Config.LoadEnv("GOTEST_MONGO2_"); err != nil {
+		log.Panic(err)
 	}
 
 	mongo := getMongoConnection(mongoConfig)
@@ -446,3 +405,8 @@ func TestMongo_SearchCount(t *testing.T) {
 	assert.Equal(t, int64(999), count)
 }
 
+
+func TestSearchFilter(t *testing.T) {
+	var filter = searchFilter(map[string][]string{"country": {"italy", "eq"}, "name": {"^sa", "like"}, "city": {"rome"}})
+	assert.Equal(t, bson.M{"country": "italy", "name": bson.M{"$regex": "^sa"}}, filter)
+}
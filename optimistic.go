@@ -0,0 +1,227 @@
+package mongoadapter
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrConflict is matched (with errors.Is) by every *ConflictError
+var ErrConflict = errors.New("version conflict")
+
+// ErrVersionedUpsert is returned by the versioned updates called with
+// upsert, on a version mismatch the upsert would insert a second document
+var ErrVersionedUpsert = errors.New("versioned updates cannot upsert")
+
+// ConflictError is returned by the versioned updates when the document
+// exists but its version is not the expected one
+type ConflictError struct {
+	DB       string
+	Coll     string
+	Expected int64
+	Current  int64
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("version conflict on %v.%v, expected version %v but the current version is %v",
+		e.DB, e.Coll, e.Expected, e.Current)
+}
+
+// Is makes errors.Is(err, ErrConflict) true for a *ConflictError
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// IsConflict checks to see if an error is a version conflict error or not
+func (m *Mongo) IsConflict(err error) bool {
+	return errors.Is(err, ErrConflict)
+}
+
+// EnableOptimisticLocking sets the version field used by the versioned
+// updates of the collection, "version" is used when it is not enabled.
+// If timestamps are enabled for the collection they must use the same field.
+func (m *Mongo) EnableOptimisticLocking(db, coll, versionField string) {
+	if versionField == "" {
+		versionField = "version"
+	}
+	m.updateSettings(db, coll, func(s *collectionSettings) {
+		s.versionField = versionField
+	})
+}
+
+func (s collectionSettings) lockVersionField() string {
+	if s.versionField != "" {
+		return s.versionField
+	}
+	return "version"
+}
+
+// versionFilter restricts filter to documents at the given version, a
+// missing version field counts as version 0
+func versionFilter(filter interface{}, field string, version int64) bson.M {
+	var condition interface{} = bson.M{field: version}
+	if version == 0 {
+		condition = bson.M{"$or": bson.A{
+			bson.M{field: int64(0)},
+			bson.M{field: bson.M{"$exists": false}},
+		}}
+	}
+	return bson.M{"$and": bson.A{filter, condition}}
+}
+
+// UpdateOneVersioned updates the first document matching filter only if its
+// version field equals version, and increments the version atomically.
+// It returns a *ConflictError if the document exists at another version and
+// mongo.ErrNoDocuments if no document matches filter. Upserts are refused
+// with ErrVersionedUpsert.
+func (m *Mongo) UpdateOneVersioned(db, coll string, filter interface{}, version int64, data interface{}, options ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	if isUpsert(options) {
+		return nil, ErrVersionedUpsert
+	}
+	var field = m.settings(db, coll).lockVersionField()
+	update, err := incrementVersion(data, field, version)
+	if err != nil {
+		return nil, err
+	}
+	res, err := m.UpdateOne(db, coll, versionFilter(filter, field, version), update, options...)
+	if err != nil {
+		return res, err
+	}
+	if res.MatchedCount == 0 {
+		return res, m.conflict(db, coll, filter, field, version)
+	}
+	return res, nil
+}
+
+// incrementVersion adds the version increment to an update document, or a
+// $set stage of the next version to an update pipeline
+func incrementVersion(data interface{}, field string, version int64) (interface{}, error) {
+	if isUpdatePipeline(data) {
+		stages, err := parsePipeline(data)
+		if err != nil {
+			return nil, err
+		}
+		var pipeline = make(bson.A, 0, len(stages)+1)
+		for _, stage := range stages {
+			pipeline = append(pipeline, stage)
+		}
+		return append(pipeline, bson.D{{Key: "$set", Value: bson.D{{Key: field, Value: version + 1}}}}), nil
+	}
+	update, err := toDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	return setOperatorField(update, "$inc", field, int64(1))
+}
+
+// ReplaceOneVersioned replaces the first document matching filter only if
+// its version field equals version, the replacement is stored with version+1.
+// Errors are reported like UpdateOneVersioned().
+func (m *Mongo) ReplaceOneVersioned(db, coll string, filter interface{}, version int64, replacement interface{}, options ...*options.ReplaceOptions) (*mongo.UpdateResult, error) {
+	if isReplaceUpsert(options) {
+		return nil, ErrVersionedUpsert
+	}
+	var field = m.settings(db, coll).lockVersionField()
+	doc, err := toDocument(replacement)
+	if err != nil {
+		return nil, err
+	}
+	doc = set(doc, field, version+1)
+	res, err := m.ReplaceOne(db, coll, versionFilter(filter, field, version), doc, options...)
+	if err != nil {
+		return res, err
+	}
+	if res.MatchedCount == 0 {
+		return res, m.conflict(db, coll, filter, field, version)
+	}
+	return res, nil
+}
+
+// isReplaceUpsert is isUpsert() for replace options
+func isReplaceUpsert(opts []*options.ReplaceOptions) bool {
+	var upsert bool
+	for _, v := range opts {
+		if v != nil && v.Upsert != nil {
+			upsert = *v.Upsert
+		}
+	}
+	return upsert
+}
+
+// conflict finds out why a versioned write did not match anything
+func (m *Mongo) conflict(db, coll string, filter interface{}, field string, expected int64) error {
+	var current bson.M
+	err := m.FindOne(db, coll, filter, options.FindOne().SetProjection(bson.M{field: 1})).Decode(&current)
+	if err != nil {
+		return err
+	}
+	version, _ := toInt64(current[field])
+	return &ConflictError{DB: db, Coll: coll, Expected: expected, Current: version}
+}
+
+// ModifyOne loads the document matching filter into doc (a pointer, usually
+// to a struct), calls modify and saves doc back with ReplaceOneVersioned().
+// On a version conflict the document is reloaded and modify is called again,
+// up to attempts times, after which the last *ConflictError is returned.
+// Errors returned by modify abort the loop and are returned as is.
+func (m *Mongo) ModifyOne(db, coll string, filter interface{}, doc interface{}, modify func() error, attempts int) error {
+	var target = reflect.ValueOf(doc)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return errors.New("ModifyOne() expects a non-nil pointer to decode the document into")
+	}
+	if attempts < 1 {
+		attempts = 1
+	}
+	var field = m.settings(db, coll).lockVersionField()
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			// give the concurrent writer a chance to finish
+			time.Sleep(time.Duration(i*i) * 5 * time.Millisecond)
+		}
+		raw, ferr := m.FindOne(db, coll, filter).DecodeBytes()
+		if ferr != nil {
+			return ferr
+		}
+		target.Elem().Set(reflect.Zero(target.Elem().Type()))
+		if err = bson.Unmarshal(raw, doc); err != nil {
+			return err
+		}
+		var version int64
+		if v, lerr := raw.LookupErr(field); lerr == nil {
+			_ = v.Unmarshal(&version)
+		}
+		if err = modify(); err != nil {
+			return err
+		}
+		// save by _id, modify may change the fields filter relies on
+		var saveFilter = filter
+		if id, lerr := raw.LookupErr("_id"); lerr == nil {
+			saveFilter = bson.M{"_id": id}
+		}
+		_, err = m.ReplaceOneVersioned(db, coll, saveFilter, version, doc)
+		if !m.IsConflict(err) {
+			return err
+		}
+	}
+	return err
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case float64:
+		return int64(n), true
+	}
+	return 0, false
+}
@@ -0,0 +1,97 @@
+package mongoadapter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type VersionedAccount struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty"`
+	Owner   string             `bson:"owner"`
+	Balance int                `bson:"balance"`
+	Version int64              `bson:"version"`
+}
+
+func TestMongo_UpdateOneVersioned(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	var coll = "versionedAccounts"
+	_ = m.conn.Database(mongoDatabase).Collection(coll).Drop(context.Background())
+	_, _ = m.InsertOne(mongoDatabase, coll, bson.M{"owner": "sara", "balance": 10})
+
+	var filter = bson.M{"owner": "sara"}
+	res, err := m.UpdateOneVersioned(mongoDatabase, coll, filter, 0, bson.M{"$inc": bson.M{"balance": 5}})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), res.ModifiedCount)
+
+	_, err = m.UpdateOneVersioned(mongoDatabase, coll, filter, 0, bson.M{"$inc": bson.M{"balance": 5}})
+	assert.True(t, m.IsConflict(err))
+	var conflict *ConflictError
+	assert.True(t, errors.As(err, &conflict))
+	assert.Equal(t, int64(1), conflict.Current)
+
+	_, err = m.UpdateOneVersioned(mongoDatabase, coll, bson.M{"owner": "nobody"}, 0, bson.M{"$set": bson.M{"balance": 1}})
+	assert.True(t, m.NoDocument(err))
+
+	var account VersionedAccount
+	assert.Nil(t, m.FindOne(mongoDatabase, coll, filter).Decode(&account))
+	account.Balance = 100
+	_, err = m.ReplaceOneVersioned(mongoDatabase, coll, filter, account.Version, account)
+	assert.Nil(t, err)
+	_, err = m.ReplaceOneVersioned(mongoDatabase, coll, filter, account.Version, account)
+	assert.True(t, m.IsConflict(err))
+}
+
+func TestMongo_ModifyOne_mustRetryOnConflict(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	var coll = "modifiedAccounts"
+	_ = m.conn.Database(mongoDatabase).Collection(coll).Drop(context.Background())
+	_, _ = m.InsertOne(mongoDatabase, coll, VersionedAccount{Owner: "robert"})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var account VersionedAccount
+			err := m.ModifyOne(mongoDatabase, coll, bson.M{"owner": "robert"}, &account, func() error {
+				account.Balance++
+				return nil
+			}, 50)
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+
+	var account VersionedAccount
+	assert.Nil(t, m.FindOne(mongoDatabase, coll, bson.M{"owner": "robert"}).Decode(&account))
+	assert.Equal(t, 10, account.Balance)
+	assert.Equal(t, int64(10), account.Version)
+}
+
+func TestMongo_UpdateOneVersioned_mustRefuseUpsert(t *testing.T) {
+	m := &Mongo{registry: newSettingsRegistry()}
+	_, err := m.UpdateOneVersioned(mongoDatabase, "versionedAccounts", bson.M{"owner": "sara"}, 1,
+		bson.M{"$set": bson.M{"balance": 10}}, options.Update().SetUpsert(true))
+	assert.Equal(t, ErrVersionedUpsert, err)
+	_, err = m.ReplaceOneVersioned(mongoDatabase, "versionedAccounts", bson.M{"owner": "sara"}, 1,
+		VersionedAccount{Owner: "sara"}, options.Replace().SetUpsert(true))
+	assert.Equal(t, ErrVersionedUpsert, err)
+}
+
+func TestIncrementVersion_pipeline(t *testing.T) {
+	update, err := incrementVersion(bson.A{bson.M{"$set": bson.M{"balance": 5}}}, "version", 3)
+	assert.Nil(t, err)
+	assert.Equal(t, bson.D{{Key: "$set", Value: bson.D{{Key: "version", Value: int64(4)}}}}, update.(bson.A)[1])
+
+	update, err = incrementVersion(bson.M{"$set": bson.M{"balance": 5}}, "version", 3)
+	assert.Nil(t, err)
+	inc, _ := lookup(update.(bson.D), "$inc")
+	assert.Equal(t, bson.D{{Key: "version", Value: int64(1)}}, inc)
+}
@@ -0,0 +1,418 @@
+package mongoadapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx"
+)
+
+// Job states
+const (
+	JobReady   = "ready"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobDead    = "dead"
+)
+
+// ErrNoJob is returned by Claim when no job is ready
+var ErrNoJob = errors.New("no job is ready")
+
+// ErrDuplicateJob is returned by Enqueue when an unfinished job with the
+// same dedupe key exists in the queue
+var ErrDuplicateJob = errors.New("a job with the same dedupe key is already queued")
+
+// ErrJobLost is returned by Ack, Nack and Extend when the visibility timeout
+// of the job expired and it was claimed again
+var ErrJobLost = errors.New("job is not claimed by this worker anymore")
+
+// Job is a unit of work stored in the queue collection.
+// For running jobs RunAt is the end of the visibility timeout.
+type Job struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	Queue       string             `bson:"queue"`
+	Payload     bson.RawValue      `bson:"payload"`
+	Priority    int                `bson:"priority"`
+	DedupeKey   string             `bson:"dedupeKey,omitempty"`
+	Status      string             `bson:"status"`
+	Attempts    int                `bson:"attempts"`
+	MaxAttempts int                `bson:"maxAttempts"`
+	RunAt       time.Time          `bson:"runAt"`
+	ClaimedBy   string             `bson:"claimedBy,omitempty"`
+	LastError   string             `bson:"lastError,omitempty"`
+	CreatedAt   time.Time          `bson:"createdAt"`
+	UpdatedAt   time.Time          `bson:"updatedAt"`
+}
+
+// DecodePayload unmarshals the payload of the job into v
+func (j *Job) DecodePayload(v interface{}) error {
+	return j.Payload.Unmarshal(v)
+}
+
+// QueueOptions configures NewQueue()
+type QueueOptions struct {
+	// Collection keeps the jobs, defaults to "jobs", several queues can share it
+	Collection string
+	// VisibilityTimeout is how long a claimed job stays invisible to other
+	// workers before it is considered abandoned, defaults to 30 seconds
+	VisibilityTimeout time.Duration
+	// MaxAttempts is the default number of attempts before a job is dead-lettered, defaults to 5
+	MaxAttempts int
+	// Backoff returns the delay before retrying a job that failed for the
+	// given attempt, defaults to an exponential backoff starting at a second
+	Backoff func(attempt int) time.Duration
+}
+
+// EnqueueOptions configures a single job
+type EnqueueOptions struct {
+	// Priority, higher priorities are claimed first
+	Priority int
+	// Delay postpones the first run of the job
+	Delay time.Duration
+	// DedupeKey rejects the job with ErrDuplicateJob while another unfinished
+	// job of the queue has the same key
+	DedupeKey string
+	// MaxAttempts overrides the queue's default
+	MaxAttempts int
+}
+
+// Queue is a durable work queue stored in a collection. Jobs are claimed
+// atomically with FindOneAndUpdate, so any number of workers in any number
+// of processes can share a queue.
+type Queue struct {
+	m       *Mongo
+	db      string
+	name    string
+	options QueueOptions
+}
+
+// NewQueue creates the named queue on the given database and its indexes
+func NewQueue(m *Mongo, db, name string, opts QueueOptions) (*Queue, error) {
+	if opts.Collection == "" {
+		opts.Collection = "jobs"
+	}
+	if opts.VisibilityTimeout <= 0 {
+		opts.VisibilityTimeout = 30 * time.Second
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.Backoff == nil {
+		opts.Backoff = exponentialBackoff
+	}
+	ctx, cancel := context.WithTimeout(m.context(), m.writeTimeout)
+	defer cancel()
+	_, err := m.collection(db, opts.Collection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bsonx.Doc{
+				{Key: "queue", Value: bsonx.Int32(1)},
+				{Key: "status", Value: bsonx.Int32(1)},
+				{Key: "priority", Value: bsonx.Int32(-1)},
+				{Key: "runAt", Value: bsonx.Int32(1)},
+			},
+		},
+		{
+			// the dedupe key is removed once a job is finished
+			Keys: bsonx.Doc{
+				{Key: "queue", Value: bsonx.Int32(1)},
+				{Key: "dedupeKey", Value: bsonx.Int32(1)},
+			},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"dedupeKey": bson.M{"$exists": true}}),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Queue{m: m, db: db, name: name, options: opts}, nil
+}
+
+func exponentialBackoff(attempt int) time.Duration {
+	if attempt > 16 {
+		attempt = 16
+	}
+	return time.Duration(1<<uint(attempt-1)) * time.Second
+}
+
+func (q *Queue) coll() *mongo.Collection {
+	return q.m.collection(q.db, q.options.Collection)
+}
+
+// Enqueue adds a job carrying payload to the queue
+func (q *Queue) Enqueue(ctx context.Context, payload interface{}, opts EnqueueOptions) (*Job, error) {
+	// the driver has no MarshalValue, the payload is wrapped in a document
+	wrapped, err := bson.Marshal(bson.M{"payload": payload})
+	if err != nil {
+		return nil, errors.New("failed to marshal the job payload, got error: " + err.Error())
+	}
+	var now = time.Now().UTC()
+	var job = &Job{
+		ID:          primitive.NewObjectID(),
+		Queue:       q.name,
+		Payload:     bson.Raw(wrapped).Lookup("payload"),
+		Priority:    opts.Priority,
+		DedupeKey:   opts.DedupeKey,
+		Status:      JobReady,
+		MaxAttempts: opts.MaxAttempts,
+		RunAt:       now.Add(opts.Delay),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if job.MaxAttempts <= 0 {
+		job.MaxAttempts = q.options.MaxAttempts
+	}
+	_, err = q.coll().InsertOne(ctx, job)
+	if q.m.IsDupError(err) {
+		return nil, ErrDuplicateJob
+	}
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Claim atomically takes the ready job with the highest priority, it
+// returns ErrNoJob if there is none. Jobs whose visibility timeout expired
+// are claimed again, or dead-lettered when they ran out of attempts.
+func (q *Queue) Claim(ctx context.Context, worker string) (*Job, error) {
+	for {
+		var now = time.Now().UTC()
+		var filter = bson.M{
+			"queue":  q.name,
+			"status": bson.M{"$in": bson.A{JobReady, JobRunning}},
+			"runAt":  bson.M{"$lte": now},
+		}
+		var update = bson.M{
+			"$set": bson.M{
+				"status":    JobRunning,
+				"claimedBy": worker,
+				"runAt":     now.Add(q.options.VisibilityTimeout),
+				"updatedAt": now,
+			},
+			"$inc": bson.M{"attempts": 1},
+		}
+		var opts = options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "priority", Value: -1}, {Key: "runAt", Value: 1}}).
+			SetReturnDocument(options.After)
+		var job Job
+		err := q.coll().FindOneAndUpdate(ctx, filter, update, opts).Decode(&job)
+		if q.m.NoDocument(err) {
+			return nil, ErrNoJob
+		}
+		if err != nil {
+			return nil, err
+		}
+		if job.Attempts <= job.MaxAttempts {
+			return &job, nil
+		}
+		// an abandoned job that used all its attempts
+		if err := q.finish(ctx, &job, JobDead, "visibility timeout expired on the last attempt"); err != nil && err != ErrJobLost {
+			return nil, err
+		}
+	}
+}
+
+// Ack marks a claimed job as done
+func (q *Queue) Ack(ctx context.Context, job *Job) error {
+	return q.finish(ctx, job, JobDone, "")
+}
+
+// Nack reports a failed attempt: the job is retried after the backoff
+// delay, or dead-lettered if it has no attempts left
+func (q *Queue) Nack(ctx context.Context, job *Job, cause error) error {
+	var message = ""
+	if cause != nil {
+		message = cause.Error()
+	}
+	if job.Attempts >= job.MaxAttempts {
+		return q.finish(ctx, job, JobDead, message)
+	}
+	var now = time.Now().UTC()
+	var runAt = now.Add(q.options.Backoff(job.Attempts))
+	return q.updateClaimed(ctx, job, bson.M{
+		"$set":   bson.M{"status": JobReady, "runAt": runAt, "lastError": message, "updatedAt": now},
+		"$unset": bson.M{"claimedBy": ""},
+	}, func() {
+		job.Status, job.RunAt, job.LastError, job.ClaimedBy = JobReady, runAt, message, ""
+	})
+}
+
+// Extend pushes the visibility timeout of a claimed job d from now, for
+// handlers that need more time than the queue's visibility timeout
+func (q *Queue) Extend(ctx context.Context, job *Job, d time.Duration) error {
+	var now = time.Now().UTC()
+	return q.updateClaimed(ctx, job, bson.M{"$set": bson.M{"runAt": now.Add(d), "updatedAt": now}}, func() {
+		job.RunAt = now.Add(d)
+	})
+}
+
+// Dead returns the dead-lettered jobs of the queue, most recent first
+func (q *Queue) Dead(ctx context.Context, limit int64) ([]Job, error) {
+	var opts = options.Find().SetSort(bson.M{"updatedAt": -1}).SetLimit(limit)
+	cur, err := q.coll().Find(ctx, bson.M{"queue": q.name, "status": JobDead}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	var jobs []Job
+	for cur.Next(ctx) {
+		var job Job
+		if err := cur.Decode(&job); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, cur.Err()
+}
+
+// Retry puts a dead-lettered job back into the queue with a fresh set of attempts
+func (q *Queue) Retry(ctx context.Context, id primitive.ObjectID) error {
+	var now = time.Now().UTC()
+	res, err := q.coll().UpdateOne(ctx, bson.M{"_id": id, "queue": q.name, "status": JobDead}, bson.M{
+		"$set": bson.M{"status": JobReady, "attempts": 0, "runAt": now, "updatedAt": now},
+	})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (q *Queue) finish(ctx context.Context, job *Job, status, message string) error {
+	var now = time.Now().UTC()
+	var set = bson.M{"status": status, "updatedAt": now}
+	if message != "" {
+		set["lastError"] = message
+	}
+	// dedupe keys only apply to unfinished jobs
+	var update = bson.M{"$set": set, "$unset": bson.M{"dedupeKey": ""}}
+	return q.updateClaimed(ctx, job, update, func() {
+		job.Status, job.DedupeKey = status, ""
+		if message != "" {
+			job.LastError = message
+		}
+	})
+}
+
+// updateClaimed applies update to the job as long as it is still claimed
+// by the same worker for the same attempt
+func (q *Queue) updateClaimed(ctx context.Context, job *Job, update bson.M, applied func()) error {
+	var filter = bson.M{
+		"_id":       job.ID,
+		"status":    JobRunning,
+		"claimedBy": job.ClaimedBy,
+		"attempts":  job.Attempts,
+	}
+	res, err := q.coll().UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrJobLost
+	}
+	applied()
+	return nil
+}
+
+// JobHandler processes a claimed job, returning an error nacks it
+type JobHandler func(ctx context.Context, job *Job) error
+
+// WorkerOptions configures Work()
+type WorkerOptions struct {
+	// Concurrency is the number of jobs processed at the same time, defaults to 1
+	Concurrency int
+	// PollInterval is how long an idle worker waits before claiming again, defaults to a second
+	PollInterval time.Duration
+	// ID identifies the worker in the claimedBy field, defaults to a random id
+	ID string
+	// OnError is called with the errors of the queue itself (not of the
+	// handler), e.g. a failed claim or ack
+	OnError func(err error)
+}
+
+// Work claims jobs and runs handler on them until ctx is cancelled, then
+// waits for the running handlers to return. Handlers get a context that
+// expires with the visibility timeout of their job and is not cancelled by
+// the graceful stop. A panicking handler nacks its job.
+func (q *Queue) Work(ctx context.Context, opts WorkerOptions, handler JobHandler) error {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.ID == "" {
+		opts.ID = xid.New().String()
+	}
+	if opts.OnError == nil {
+		opts.OnError = func(err error) {}
+	}
+
+	var wg sync.WaitGroup
+	var slots = make(chan struct{}, opts.Concurrency)
+	defer wg.Wait()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case slots <- struct{}{}:
+		}
+
+		claimCtx, cancel := context.WithTimeout(context.Background(), q.m.readTimeout)
+		job, err := q.Claim(claimCtx, opts.ID)
+		cancel()
+		if err != nil {
+			<-slots
+			if err != ErrNoJob {
+				opts.OnError(err)
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(opts.PollInterval):
+			}
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			q.run(job, opts, handler)
+		}()
+	}
+}
+
+func (q *Queue) run(job *Job, opts WorkerOptions, handler JobHandler) {
+	ctx, cancel := context.WithDeadline(context.Background(), job.RunAt)
+	defer cancel()
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("job handler panicked: %v", r)
+			}
+		}()
+		return handler(ctx, job)
+	}()
+
+	finishCtx, finishCancel := context.WithTimeout(context.Background(), q.m.writeTimeout)
+	defer finishCancel()
+	if err != nil {
+		err = q.Nack(finishCtx, job, err)
+	} else {
+		err = q.Ack(finishCtx, job)
+	}
+	if err != nil {
+		opts.OnError(err)
+	}
+}
@@ -0,0 +1,140 @@
+package mongoadapter
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func createTestQueue(t *testing.T, opts QueueOptions) *Queue {
+	m, _ := NewMongo(mongoConfig)
+	opts.Collection = "testJobs"
+	_ = m.conn.Database(mongoDatabase).Collection(opts.Collection).Drop(context.Background())
+	queue, err := NewQueue(m, mongoDatabase, "emails", opts)
+	assert.Nil(t, err)
+	return queue
+}
+
+func TestQueue_Claim(t *testing.T) {
+	queue := createTestQueue(t, QueueOptions{})
+	ctx := context.Background()
+
+	_, err := queue.Enqueue(ctx, bson.M{"to": "low"}, EnqueueOptions{})
+	assert.Nil(t, err)
+	_, err = queue.Enqueue(ctx, bson.M{"to": "later"}, EnqueueOptions{Priority: 10, Delay: time.Hour})
+	assert.Nil(t, err)
+	_, err = queue.Enqueue(ctx, bson.M{"to": "high"}, EnqueueOptions{Priority: 5, DedupeKey: "high"})
+	assert.Nil(t, err)
+	_, err = queue.Enqueue(ctx, bson.M{"to": "high"}, EnqueueOptions{DedupeKey: "high"})
+	assert.Equal(t, ErrDuplicateJob, err)
+
+	job, err := queue.Claim(ctx, "worker")
+	assert.Nil(t, err)
+	var payload struct {
+		To string `bson:"to"`
+	}
+	assert.Nil(t, job.DecodePayload(&payload))
+	assert.Equal(t, "high", payload.To)
+	assert.Equal(t, 1, job.Attempts)
+	assert.Nil(t, queue.Ack(ctx, job))
+	// the dedupe key is released once the job is done
+	_, err = queue.Enqueue(ctx, bson.M{"to": "high"}, EnqueueOptions{DedupeKey: "high"})
+	assert.Nil(t, err)
+
+	_, err = queue.Claim(ctx, "worker")
+	assert.Nil(t, err)
+	_, err = queue.Claim(ctx, "worker")
+	assert.Nil(t, err)
+	_, err = queue.Claim(ctx, "worker")
+	assert.Equal(t, ErrNoJob, err)
+}
+
+func TestQueue_Nack_mustRetryThenDeadLetter(t *testing.T) {
+	queue := createTestQueue(t, QueueOptions{Backoff: func(int) time.Duration { return 0 }})
+	ctx := context.Background()
+
+	enqueued, err := queue.Enqueue(ctx, "payload", EnqueueOptions{MaxAttempts: 2})
+	assert.Nil(t, err)
+	job, err := queue.Claim(ctx, "worker")
+	assert.Nil(t, err)
+	assert.Nil(t, queue.Nack(ctx, job, errors.New("smtp is down")))
+	assert.Equal(t, JobReady, job.Status)
+
+	job, err = queue.Claim(ctx, "worker")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, job.Attempts)
+	assert.Equal(t, "smtp is down", job.LastError)
+	assert.Nil(t, queue.Nack(ctx, job, errors.New("still down")))
+	assert.Equal(t, JobDead, job.Status)
+	_, err = queue.Claim(ctx, "worker")
+	assert.Equal(t, ErrNoJob, err)
+
+	dead, err := queue.Dead(ctx, 10)
+	assert.Nil(t, err)
+	assert.Len(t, dead, 1)
+	assert.Nil(t, queue.Retry(ctx, enqueued.ID))
+	_, err = queue.Claim(ctx, "worker")
+	assert.Nil(t, err)
+}
+
+func TestQueue_Claim_mustReclaimAfterVisibilityTimeout(t *testing.T) {
+	queue := createTestQueue(t, QueueOptions{VisibilityTimeout: 100 * time.Millisecond})
+	ctx := context.Background()
+
+	_, err := queue.Enqueue(ctx, "payload", EnqueueOptions{})
+	assert.Nil(t, err)
+	abandoned, err := queue.Claim(ctx, "crashed")
+	assert.Nil(t, err)
+	_, err = queue.Claim(ctx, "other")
+	assert.Equal(t, ErrNoJob, err)
+
+	time.Sleep(150 * time.Millisecond)
+	job, err := queue.Claim(ctx, "other")
+	assert.Nil(t, err)
+	assert.Equal(t, abandoned.ID, job.ID)
+	assert.Equal(t, ErrJobLost, queue.Ack(ctx, abandoned))
+	assert.Nil(t, queue.Ack(ctx, job))
+}
+
+func TestQueue_Work(t *testing.T) {
+	queue := createTestQueue(t, QueueOptions{Backoff: func(int) time.Duration { return 0 }})
+	for i := 0; i < 10; i++ {
+		_, err := queue.Enqueue(context.Background(), i, EnqueueOptions{})
+		assert.Nil(t, err)
+	}
+
+	var processed, running, maxRunning int32
+	ctx, cancel := context.WithCancel(context.Background())
+	var done = make(chan error)
+	go func() {
+		done <- queue.Work(ctx, WorkerOptions{Concurrency: 3, PollInterval: 10 * time.Millisecond}, func(ctx context.Context, job *Job) error {
+			current := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				max := atomic.LoadInt32(&maxRunning)
+				if current <= max || atomic.CompareAndSwapInt32(&maxRunning, max, current) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			var n int
+			_ = job.DecodePayload(&n)
+			if n == 0 && job.Attempts == 1 {
+				panic("first attempt fails")
+			}
+			atomic.AddInt32(&processed, 1)
+			return nil
+		})
+	}()
+
+	time.Sleep(time.Second)
+	cancel()
+	assert.Nil(t, <-done)
+	assert.Equal(t, int32(10), atomic.LoadInt32(&processed))
+	assert.True(t, atomic.LoadInt32(&maxRunning) <= 3)
+}
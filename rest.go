@@ -0,0 +1,435 @@
+package mongoadapter
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RESTCollection exposes a collection through RESTHandler()
+type RESTCollection struct {
+	DB         string
+	Collection string
+	// Name is the path segment of the collection, defaults to Collection
+	Name string
+	// ReadFields are the fields returned and filterable, empty means all.
+	// _id is always returned.
+	ReadFields []string
+	// WriteFields are the fields accepted by create and patch, empty
+	// means all but _id
+	WriteFields []string
+	// ReadOnly refuses create, patch and delete
+	ReadOnly bool
+	// MaxLimit caps the page size of lists, defaults to 100
+	MaxLimit int64
+}
+
+// RESTOptions configures RESTHandler()
+type RESTOptions struct {
+	Collections []RESTCollection
+	// Prefix is stripped from the request paths, e.g. "/admin/api"
+	Prefix string
+	// Auth wraps the handler, e.g. to check a token and set the actor
+	// with WithActor() on the request context
+	Auth func(http.Handler) http.Handler
+	// DefaultLimit is the page size of lists without limit, defaults to 20
+	DefaultLimit int64
+	// MaxBodySize limits the request bodies, defaults to 1 MiB
+	MaxBodySize int64
+}
+
+// Pagination is the metadata of the pages returned by lists
+type Pagination struct {
+	Total   int64 `json:"total"`
+	Limit   int64 `json:"limit"`
+	Skip    int64 `json:"skip"`
+	HasMore bool  `json:"hasMore"`
+}
+
+type restGateway struct {
+	m           *Mongo
+	opts        RESTOptions
+	collections map[string]RESTCollection
+}
+
+// RESTHandler returns an http.Handler exposing the collections as JSON:
+//
+//	GET    /{name}?field=value&field:like=regex&sort=name,-age&limit=20&skip=0
+//	GET    /{name}/{id}
+//	POST   /{name}
+//	PATCH  /{name}/{id}
+//	DELETE /{name}/{id}
+//
+// Lists filter like Search(), field=value matches equal values and
+// field:like=regex matches a regular expression. They respond with
+// {"data": [...], "pagination": {...}}, the others with {"data": {...}}.
+// Documents are Extended JSON, ids are ObjectIDs when they parse as one.
+// Errors respond with {"error": "..."}.
+func (m *Mongo) RESTHandler(opts RESTOptions) (http.Handler, error) {
+	if opts.DefaultLimit <= 0 {
+		opts.DefaultLimit = 20
+	}
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = 1 << 20
+	}
+	opts.Prefix = strings.TrimSuffix(opts.Prefix, "/")
+	var g = &restGateway{m: m, opts: opts, collections: make(map[string]RESTCollection)}
+	for _, c := range opts.Collections {
+		if c.DB == "" || c.Collection == "" {
+			return nil, errors.New("the database and the collection of an exposed collection are required")
+		}
+		if c.Name == "" {
+			c.Name = c.Collection
+		}
+		if strings.Contains(c.Name, "/") {
+			return nil, errors.New("invalid collection name " + strconv.Quote(c.Name))
+		}
+		if _, ok := g.collections[c.Name]; ok {
+			return nil, errors.New("collection " + strconv.Quote(c.Name) + " is exposed twice")
+		}
+		if c.MaxLimit <= 0 {
+			c.MaxLimit = 100
+		}
+		g.collections[c.Name] = c
+	}
+	var handler http.Handler = g
+	if opts.Auth != nil {
+		handler = opts.Auth(handler)
+	}
+	return handler, nil
+}
+
+// restError is an error with the HTTP status it responds with
+type restError struct {
+	status  int
+	message string
+}
+
+func (e *restError) Error() string {
+	return e.message
+}
+
+func (g *restGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var path = strings.TrimPrefix(r.URL.Path, g.opts.Prefix)
+	if len(path) == len(r.URL.Path) && g.opts.Prefix != "" || !strings.HasPrefix(path, "/") {
+		writeRESTError(w, &restError{http.StatusNotFound, "not found"})
+		return
+	}
+	var parts = strings.Split(strings.Trim(path, "/"), "/")
+	c, ok := g.collections[parts[0]]
+	if !ok || len(parts) > 2 {
+		writeRESTError(w, &restError{http.StatusNotFound, "not found"})
+		return
+	}
+	var m = g.m.With(Context(r.Context()))
+	var err error
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		err = g.list(m, c, w, r)
+	case len(parts) == 1 && r.Method == http.MethodPost && !c.ReadOnly:
+		err = g.create(m, c, w, r)
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		err = g.get(m, c, w, parseRESTID(parts[1]))
+	case len(parts) == 2 && r.Method == http.MethodPatch && !c.ReadOnly:
+		err = g.patch(m, c, w, r, parseRESTID(parts[1]))
+	case len(parts) == 2 && r.Method == http.MethodDelete && !c.ReadOnly:
+		err = g.delete(m, c, w, parseRESTID(parts[1]))
+	default:
+		err = &restError{http.StatusMethodNotAllowed, "method not allowed"}
+	}
+	if err != nil {
+		writeRESTError(w, g.restError(err))
+	}
+}
+
+func (g *restGateway) list(m *Mongo, c RESTCollection, w http.ResponseWriter, r *http.Request) error {
+	var query = r.URL.Query()
+	var page = Pagination{Limit: g.opts.DefaultLimit}
+	var err error
+	if v := query.Get("limit"); v != "" {
+		if page.Limit, err = strconv.ParseInt(v, 10, 64); err != nil || page.Limit <= 0 {
+			return &restError{http.StatusBadRequest, "invalid limit"}
+		}
+	}
+	if page.Limit > c.MaxLimit {
+		page.Limit = c.MaxLimit
+	}
+	if v := query.Get("skip"); v != "" {
+		if page.Skip, err = strconv.ParseInt(v, 10, 64); err != nil || page.Skip < 0 {
+			return &restError{http.StatusBadRequest, "invalid skip"}
+		}
+	}
+
+	var filters = map[string][]string{}
+	for key, values := range query {
+		if key == "limit" || key == "skip" || key == "sort" {
+			continue
+		}
+		var field, op = key, "eq"
+		if strings.HasSuffix(key, ":like") {
+			field, op = strings.TrimSuffix(key, ":like"), "like"
+		}
+		if !readable(c, field) || strings.HasPrefix(field, "$") {
+			return &restError{http.StatusBadRequest, "unknown field " + strconv.Quote(field)}
+		}
+		filters[field] = []string{values[0], op}
+	}
+	sorting, err := restSort(c, query.Get("sort"))
+	if err != nil {
+		return err
+	}
+
+	var filter = searchFilter(filters)
+	if page.Total, err = m.Count(c.DB, c.Collection, filter); err != nil {
+		return err
+	}
+	cur, err := m.FindMany(c.DB, c.Collection, filter,
+		options.Find().SetSort(sorting).SetSkip(page.Skip).SetLimit(page.Limit))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(r.Context())
+	var data = []json.RawMessage{}
+	for cur.Next(r.Context()) {
+		doc, err := restDocument(c, cur.Current)
+		if err != nil {
+			return err
+		}
+		data = append(data, doc)
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+	page.HasMore = page.Skip+int64(len(data)) < page.Total
+	writeRESTResponse(w, http.StatusOK, struct {
+		Data       []json.RawMessage `json:"data"`
+		Pagination Pagination        `json:"pagination"`
+	}{data, page})
+	return nil
+}
+
+// restSort parses sort=name,-age into an ordered sort ending with _id, so
+// that the pages of documents with equal sort fields don't overlap
+func restSort(c RESTCollection, v string) (bson.D, error) {
+	var sorting = bson.D{}
+	if v != "" {
+		for _, field := range strings.Split(v, ",") {
+			var direction = 1
+			if strings.HasPrefix(field, "-") {
+				field, direction = field[1:], -1
+			}
+			if field == "" || !readable(c, field) {
+				return nil, &restError{http.StatusBadRequest, "unknown sort field " + strconv.Quote(field)}
+			}
+			if _, ok := lookup(sorting, field); !ok {
+				sorting = append(sorting, bson.E{Key: field, Value: direction})
+			}
+		}
+	}
+	if _, ok := lookup(sorting, "_id"); !ok {
+		sorting = append(sorting, bson.E{Key: "_id", Value: 1})
+	}
+	return sorting, nil
+}
+
+func (g *restGateway) get(m *Mongo, c RESTCollection, w http.ResponseWriter, id interface{}) error {
+	doc, err := g.find(m, c, id)
+	if err != nil {
+		return err
+	}
+	writeRESTData(w, http.StatusOK, doc)
+	return nil
+}
+
+// find returns the readable fields of the document as stored, with the
+// fields set by the adapter such as timestamps
+func (g *restGateway) find(m *Mongo, c RESTCollection, id interface{}) (json.RawMessage, error) {
+	raw, err := m.FindOne(c.DB, c.Collection, bson.M{"_id": id}).DecodeBytes()
+	if err != nil {
+		return nil, err
+	}
+	return restDocument(c, raw)
+}
+
+func (g *restGateway) create(m *Mongo, c RESTCollection, w http.ResponseWriter, r *http.Request) error {
+	doc, err := g.readBody(c, w, r)
+	if err != nil {
+		return err
+	}
+	res, err := m.InsertOne(c.DB, c.Collection, doc)
+	if err != nil {
+		return err
+	}
+	data, err := g.find(m, c, res.InsertedID)
+	if err != nil {
+		return err
+	}
+	if id, ok := res.InsertedID.(primitive.ObjectID); ok {
+		w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+id.Hex())
+	}
+	writeRESTData(w, http.StatusCreated, data)
+	return nil
+}
+
+func (g *restGateway) patch(m *Mongo, c RESTCollection, w http.ResponseWriter, r *http.Request, id interface{}) error {
+	doc, err := g.readBody(c, w, r)
+	if err != nil {
+		return err
+	}
+	if _, ok := lookup(doc, "_id"); ok {
+		return &restError{http.StatusBadRequest, "_id can't be changed"}
+	}
+	if len(doc) == 0 {
+		return &restError{http.StatusBadRequest, "nothing to update"}
+	}
+	res, err := m.UpdateOne(c.DB, c.Collection, bson.M{"_id": id}, bson.D{{Key: "$set", Value: doc}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return g.get(m, c, w, id)
+}
+
+func (g *restGateway) delete(m *Mongo, c RESTCollection, w http.ResponseWriter, id interface{}) error {
+	res, err := m.DeleteOne(c.DB, c.Collection, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// readBody decodes the Extended JSON document of the request, refusing
+// the fields that are not writable
+func (g *restGateway) readBody(c RESTCollection, w http.ResponseWriter, r *http.Request) (bson.D, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, g.opts.MaxBodySize))
+	if err != nil {
+		return nil, &restError{http.StatusRequestEntityTooLarge, "request body too large"}
+	}
+	var doc bson.D
+	if err := bson.UnmarshalExtJSON(body, false, &doc); err != nil {
+		return nil, &restError{http.StatusBadRequest, "invalid JSON document, got error: " + err.Error()}
+	}
+	var refused []string
+	for _, e := range doc {
+		if !writable(c, e.Key) {
+			refused = append(refused, e.Key)
+		}
+	}
+	if len(refused) > 0 {
+		sort.Strings(refused)
+		return nil, &restError{http.StatusBadRequest, "fields not writable: " + strings.Join(refused, ", ")}
+	}
+	return doc, nil
+}
+
+func readable(c RESTCollection, field string) bool {
+	if len(c.ReadFields) == 0 || field == "_id" {
+		return true
+	}
+	for _, f := range c.ReadFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+func writable(c RESTCollection, field string) bool {
+	// operators and paths would reach around the whitelist in updates
+	if strings.HasPrefix(field, "$") || strings.Contains(field, ".") {
+		return false
+	}
+	if len(c.WriteFields) == 0 {
+		return field != "_id"
+	}
+	for _, f := range c.WriteFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// restDocument returns the readable fields of doc as relaxed Extended JSON
+func restDocument(c RESTCollection, doc bson.Raw) (json.RawMessage, error) {
+	if len(c.ReadFields) > 0 {
+		elements, err := doc.Elements()
+		if err != nil {
+			return nil, err
+		}
+		var visible = bson.D{}
+		for _, e := range elements {
+			if readable(c, e.Key()) {
+				visible = append(visible, bson.E{Key: e.Key(), Value: e.Value()})
+			}
+		}
+		if doc, err = bson.Marshal(visible); err != nil {
+			return nil, err
+		}
+	}
+	data, err := bson.MarshalExtJSON(doc, false, false)
+	return json.RawMessage(data), err
+}
+
+// parseRESTID returns the ObjectID of a path segment, or the segment itself
+func parseRESTID(segment string) interface{} {
+	if id, err := primitive.ObjectIDFromHex(segment); err == nil {
+		return id
+	}
+	return segment
+}
+
+// restError maps the errors of the adapter to HTTP statuses
+func (g *restGateway) restError(err error) *restError {
+	switch e := err.(type) {
+	case *restError:
+		return e
+	case *ValidationError:
+		return &restError{http.StatusUnprocessableEntity, e.Error()}
+	}
+	switch {
+	case g.m.NoDocument(err):
+		return &restError{http.StatusNotFound, "not found"}
+	case g.m.IsDupError(err) || isDuplicateKey(g.m, err):
+		return &restError{http.StatusConflict, "duplicate key"}
+	case g.m.IsConflict(err):
+		return &restError{http.StatusConflict, err.Error()}
+	}
+	// the details of internal errors are not leaked to clients
+	return &restError{http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError)}
+}
+
+func writeRESTData(w http.ResponseWriter, status int, doc json.RawMessage) {
+	writeRESTResponse(w, status, struct {
+		Data json.RawMessage `json:"data"`
+	}{doc})
+}
+
+// writeRESTResponse writes v as JSON, the status is already sent when
+// encoding fails so there is nothing left to report
+func writeRESTResponse(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeRESTError(w http.ResponseWriter, e *restError) {
+	writeRESTResponse(w, e.status, struct {
+		Error string `json:"error"`
+	}{e.message})
+}
@@ -0,0 +1,124 @@
+package mongoadapter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func restRequest(h http.Handler, method, path, body string) (int, map[string]interface{}) {
+	var r = httptest.NewRequest(method, path, strings.NewReader(body))
+	var w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	var response map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response
+}
+
+func TestRESTHandler_guards(t *testing.T) {
+	m := &Mongo{registry: newSettingsRegistry()}
+	h, err := m.RESTHandler(RESTOptions{
+		Prefix: "/api/",
+		Collections: []RESTCollection{
+			{DB: "app", Collection: "users", ReadFields: []string{"name"}, WriteFields: []string{"name"}},
+			{DB: "app", Collection: "audit", ReadOnly: true},
+		},
+	})
+	assert.Nil(t, err)
+
+	code, _ := restRequest(h, "GET", "/api/unknown", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = restRequest(h, "GET", "/apiusers", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = restRequest(h, "DELETE", "/api/audit/1", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+	code, response := restRequest(h, "POST", "/api/users", `{"name": "sara", "role": "admin"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "fields not writable: role", response["error"])
+	code, _ = restRequest(h, "POST", "/api/users", `{"$where": "1"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = restRequest(h, "GET", "/api/users?password=x", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = restRequest(h, "GET", "/api/users?limit=-1", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	_, err = m.RESTHandler(RESTOptions{Collections: []RESTCollection{{DB: "app", Collection: "a", Name: "x"}, {DB: "app", Collection: "b", Name: "x"}}})
+	assert.Error(t, err)
+}
+
+func TestRestSort(t *testing.T) {
+	var c = RESTCollection{ReadFields: []string{"name", "age", "city"}}
+	sorting, err := restSort(c, "name,-age,city")
+	assert.Nil(t, err)
+	assert.Equal(t, bson.D{{Key: "name", Value: 1}, {Key: "age", Value: -1}, {Key: "city", Value: 1}, {Key: "_id", Value: 1}}, sorting)
+	sorting, err = restSort(c, "")
+	assert.Nil(t, err)
+	assert.Equal(t, bson.D{{Key: "_id", Value: 1}}, sorting)
+	_, err = restSort(c, "name,-password")
+	assert.Error(t, err)
+}
+
+func TestRESTHandler(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	var coll = "restUsers"
+	_ = m.conn.Database(mongoDatabase).Collection(coll).Drop(context.Background())
+	var authorized = func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Token") == "" && r.Method != http.MethodGet {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	h, err := m.RESTHandler(RESTOptions{
+		Auth:        authorized,
+		Collections: []RESTCollection{{DB: mongoDatabase, Collection: coll, Name: "users", ReadFields: []string{"name", "city"}}},
+	})
+	assert.Nil(t, err)
+
+	code, _ := restRequest(h, "POST", "/users", `{"name": "sara"}`)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	var ids []string
+	for _, body := range []string{`{"name": "sara", "city": "rome", "secret": "x"}`, `{"name": "sam", "city": "rome"}`, `{"name": "john", "city": "oslo"}`} {
+		var r = httptest.NewRequest("POST", "/users", strings.NewReader(body))
+		r.Header.Set("X-Token", "t")
+		var w = httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var created struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &created))
+		assert.NotContains(t, created.Data, "secret")
+		ids = append(ids, created.Data["_id"].(map[string]interface{})["$oid"].(string))
+	}
+
+	code, response := restRequest(h, "GET", "/users?city=rome&name:like=^sa&sort=-name&limit=1", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, response["data"], 1)
+	assert.Equal(t, "sara", response["data"].([]interface{})[0].(map[string]interface{})["name"])
+	assert.Equal(t, map[string]interface{}{"total": 2.0, "limit": 1.0, "skip": 0.0, "hasMore": true}, response["pagination"])
+
+	var r = httptest.NewRequest("PATCH", "/users/"+ids[2], strings.NewReader(`{"city": "rome"}`))
+	r.Header.Set("X-Token", "t")
+	var w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"city":"rome"`)
+
+	r = httptest.NewRequest("DELETE", "/users/"+ids[0], nil)
+	r.Header.Set("X-Token", "t")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	code, _ = restRequest(h, "GET", "/users/"+ids[0], "")
+	assert.Equal(t, http.StatusNotFound, code)
+}
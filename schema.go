@@ -0,0 +1,360 @@
+package mongoadapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// documentValidationFailure is the server error code of a write
+// rejected by the collection's validator
+const documentValidationFailure = 121
+
+// Validation levels and actions accepted by CollectionSchema
+const (
+	ValidationLevelOff      = "off"
+	ValidationLevelStrict   = "strict"
+	ValidationLevelModerate = "moderate"
+
+	ValidationActionError = "error"
+	ValidationActionWarn  = "warn"
+)
+
+// CollectionSchema is the declared validator of a collection.
+// Validator is usually bson.M{"$jsonSchema": schema}, see JSONSchemaFromStruct().
+// Empty Level and Action mean strict and error, the server defaults.
+type CollectionSchema struct {
+	Validator bson.M
+	Level     string
+	Action    string
+}
+
+// SchemaDrift describes the differences between a declared schema and the
+// validator currently set on the collection
+type SchemaDrift struct {
+	Exists           bool
+	ValidatorDiffers bool
+	LevelDiffers     bool
+	ActionDiffers    bool
+	Live             CollectionSchema
+}
+
+// InSync reports whether the live collection matches the declared schema
+func (d *SchemaDrift) InSync() bool {
+	return d.Exists && !d.ValidatorDiffers && !d.LevelDiffers && !d.ActionDiffers
+}
+
+// ValidationError is returned by the write methods when the server rejects
+// a document because it does not pass the collection's validator
+type ValidationError struct {
+	Op       string
+	DB       string
+	Coll     string
+	Filter   interface{}
+	Document interface{}
+	Message  string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%v on %v.%v failed document validation: %v", e.Op, e.DB, e.Coll, e.Message)
+}
+
+// IsValidationError checks to see if an error is a document validation error
+func (m *Mongo) IsValidationError(err error) bool {
+	_, ok := err.(*ValidationError)
+	return ok
+}
+
+// ApplySchema creates the collection with the given validator, or updates
+// the validator of an existing one using collMod
+func (m *Mongo) ApplySchema(db, coll string, schema CollectionSchema) error {
+	ctx, cancel := context.WithTimeout(m.context(), m.writeTimeout)
+	defer cancel()
+	schema = schema.withDefaults()
+	_, exists, err := m.liveSchema(ctx, db, coll)
+	if err != nil {
+		return err
+	}
+	var command = "collMod"
+	if !exists {
+		command = "create"
+	}
+	var cmd = bson.D{
+		{Key: command, Value: coll},
+		{Key: "validator", Value: schema.Validator},
+		{Key: "validationLevel", Value: schema.Level},
+		{Key: "validationAction", Value: schema.Action},
+	}
+	return m.conn.Database(db).RunCommand(ctx, cmd).Err()
+}
+
+// SchemaDrift compares the declared schema with the validator set on the collection
+func (m *Mongo) SchemaDrift(db, coll string, schema CollectionSchema) (*SchemaDrift, error) {
+	ctx, cancel := context.WithTimeout(m.context(), m.readTimeout)
+	defer cancel()
+	schema = schema.withDefaults()
+	live, exists, err := m.liveSchema(ctx, db, coll)
+	if err != nil {
+		return nil, err
+	}
+	var drift = &SchemaDrift{Exists: exists, Live: live}
+	if !exists {
+		return drift, nil
+	}
+	declared, err := normalizeBSON(schema.Validator)
+	if err != nil {
+		return nil, err
+	}
+	current, err := normalizeBSON(live.Validator)
+	if err != nil {
+		return nil, err
+	}
+	drift.ValidatorDiffers = !reflect.DeepEqual(declared, current)
+	drift.LevelDiffers = schema.Level != live.Level
+	drift.ActionDiffers = schema.Action != live.Action
+	return drift, nil
+}
+
+func (s CollectionSchema) withDefaults() CollectionSchema {
+	if s.Validator == nil {
+		s.Validator = bson.M{}
+	}
+	if s.Level == "" {
+		s.Level = ValidationLevelStrict
+	}
+	if s.Action == "" {
+		s.Action = ValidationActionError
+	}
+	return s
+}
+
+func (m *Mongo) liveSchema(ctx context.Context, db, coll string) (CollectionSchema, bool, error) {
+	cur, err := m.conn.Database(db).ListCollections(ctx, bson.M{"name": coll})
+	if err != nil {
+		return CollectionSchema{}, false, err
+	}
+	defer cur.Close(ctx)
+	if !cur.Next(ctx) {
+		return CollectionSchema{}, false, cur.Err()
+	}
+	var info struct {
+		Options struct {
+			Validator        bson.M `bson:"validator"`
+			ValidationLevel  string `bson:"validationLevel"`
+			ValidationAction string `bson:"validationAction"`
+		} `bson:"options"`
+	}
+	if err := cur.Decode(&info); err != nil {
+		return CollectionSchema{}, false, err
+	}
+	var live = CollectionSchema{
+		Validator: info.Options.Validator,
+		Level:     info.Options.ValidationLevel,
+		Action:    info.Options.ValidationAction,
+	}
+	return live.withDefaults(), true, nil
+}
+
+// normalizeBSON round-trips v through bson and converts every document to
+// a map, so that two validators can be compared regardless of key order
+func normalizeBSON(v interface{}) (interface{}, error) {
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	return normalizeValue(doc), nil
+}
+
+func normalizeValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case bson.M:
+		var result = make(map[string]interface{}, len(val))
+		for k, e := range val {
+			result[k] = normalizeValue(e)
+		}
+		return result
+	case bson.D:
+		var result = make(map[string]interface{}, len(val))
+		for _, e := range val {
+			result[e.Key] = normalizeValue(e.Value)
+		}
+		return result
+	case bson.A:
+		var result = make([]interface{}, len(val))
+		for i, e := range val {
+			result[i] = normalizeValue(e)
+		}
+		return result
+	case int32:
+		return int64(val)
+	}
+	return v
+}
+
+// validationError converts a document validation failure returned by the
+// driver into a *ValidationError, other errors are returned untouched.
+// docs are the documents of the write, in the order they were sent.
+func validationError(op, db, coll string, filter interface{}, docs []interface{}, err error) error {
+	var writeErrors []mongo.WriteError
+	switch e := err.(type) {
+	case mongo.WriteException:
+		writeErrors = e.WriteErrors
+	case mongo.BulkWriteException:
+		for _, v := range e.WriteErrors {
+			writeErrors = append(writeErrors, v.WriteError)
+		}
+	default:
+		return err
+	}
+	for _, v := range writeErrors {
+		if v.Code != documentValidationFailure {
+			continue
+		}
+		var validationErr = &ValidationError{Op: op, DB: db, Coll: coll, Filter: filter, Message: v.Message}
+		if v.Index >= 0 && v.Index < len(docs) {
+			validationErr.Document = docs[v.Index]
+		}
+		return validationErr
+	}
+	return err
+}
+
+// JSONSchemaFromStruct builds a $jsonSchema document from the bson tags and
+// field types of a struct (or pointer to struct). Fields without omitempty
+// are required, pointers are nullable, interface{} fields accept any type.
+// The result is meant to be used as CollectionSchema{Validator: bson.M{"$jsonSchema": schema}}.
+func JSONSchemaFromStruct(v interface{}) (bson.M, error) {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, errors.New("JSONSchemaFromStruct() expects a struct or a pointer to struct")
+	}
+	return structSchema(t), nil
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+	dateTimeType = reflect.TypeOf(primitive.DateTime(0))
+	decimalType  = reflect.TypeOf(primitive.Decimal128{})
+	bytesType    = reflect.TypeOf([]byte(nil))
+)
+
+func structSchema(t reflect.Type) bson.M {
+	var properties = bson.M{}
+	var required = bson.A{}
+	collectStructFields(t, properties, &required)
+	var schema = bson.M{"bsonType": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func collectStructFields(t reflect.Type, properties bson.M, required *bson.A) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		name, opts := parseBSONTag(field)
+		if name == "-" {
+			continue
+		}
+		ft := field.Type
+		if opts["inline"] {
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				collectStructFields(ft, properties, required)
+			}
+			continue
+		}
+		properties[name] = typeSchema(ft)
+		if !opts["omitempty"] {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// parseBSONTag follows the driver's rules: the key defaults to the
+// lowercased field name
+func parseBSONTag(field reflect.StructField) (string, map[string]bool) {
+	var tag = field.Tag.Get("bson")
+	var parts = strings.Split(tag, ",")
+	var name = parts[0]
+	var opts = make(map[string]bool, len(parts))
+	for _, v := range parts[1:] {
+		opts[v] = true
+	}
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name, opts
+}
+
+func typeSchema(t reflect.Type) bson.M {
+	if t.Kind() == reflect.Ptr {
+		var schema = typeSchema(t.Elem())
+		if bsonType, ok := schema["bsonType"]; ok {
+			schema["bsonType"] = nullableType(bsonType)
+		}
+		return schema
+	}
+	switch t {
+	case timeType, dateTimeType:
+		return bson.M{"bsonType": "date"}
+	case objectIDType:
+		return bson.M{"bsonType": "objectId"}
+	case decimalType:
+		return bson.M{"bsonType": "decimal"}
+	case bytesType:
+		return bson.M{"bsonType": "binData"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return bson.M{"bsonType": "string"}
+	case reflect.Bool:
+		return bson.M{"bsonType": "bool"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		// the driver picks int or long depending on the value
+		return bson.M{"bsonType": bson.A{"int", "long"}}
+	case reflect.Float32, reflect.Float64:
+		return bson.M{"bsonType": "double"}
+	case reflect.Slice, reflect.Array:
+		// nil slices are stored as null
+		return bson.M{"bsonType": bson.A{"array", "null"}, "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return bson.M{"bsonType": bson.A{"object", "null"}}
+	case reflect.Struct:
+		return structSchema(t)
+	}
+	return bson.M{}
+}
+
+func nullableType(bsonType interface{}) bson.A {
+	if types, ok := bsonType.(bson.A); ok {
+		for _, v := range types {
+			if v == "null" {
+				return types
+			}
+		}
+		return append(types, "null")
+	}
+	return bson.A{bsonType, "null"}
+}
@@ -0,0 +1,74 @@
+package mongoadapter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SchemaUser struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	Name      string             `bson:"name"`
+	Age       int                `bson:"age"`
+	Tags      []string           `bson:"tags,omitempty"`
+	Manager   *DummyUser         `bson:"manager,omitempty"`
+	CreatedAt time.Time          `bson:"createdAt"`
+	Ignored   string             `bson:"-"`
+}
+
+func TestJSONSchemaFromStruct(t *testing.T) {
+	schema, err := JSONSchemaFromStruct(&SchemaUser{})
+	assert.Nil(t, err)
+	assert.Equal(t, "object", schema["bsonType"])
+	assert.Equal(t, bson.A{"name", "age", "createdAt"}, schema["required"])
+	properties := schema["properties"].(bson.M)
+	assert.Equal(t, bson.M{"bsonType": "objectId"}, properties["_id"])
+	assert.Equal(t, bson.M{"bsonType": "date"}, properties["createdAt"])
+	assert.Equal(t, bson.A{"object", "null"}, properties["manager"].(bson.M)["bsonType"])
+	assert.NotContains(t, properties, "Ignored")
+	assert.NotContains(t, properties, "ignored")
+
+	_, err = JSONSchemaFromStruct("wrong")
+	assert.Error(t, err)
+}
+
+func TestMongo_ApplySchema(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	var coll = "validatedUsers"
+	_ = m.conn.Database(mongoDatabase).Collection(coll).Drop(context.Background())
+	jsonSchema, _ := JSONSchemaFromStruct(SchemaUser{})
+	var schema = CollectionSchema{Validator: bson.M{"$jsonSchema": jsonSchema}}
+
+	drift, err := m.SchemaDrift(mongoDatabase, coll, schema)
+	assert.Nil(t, err)
+	assert.False(t, drift.Exists)
+
+	assert.Nil(t, m.ApplySchema(mongoDatabase, coll, schema))
+	drift, err = m.SchemaDrift(mongoDatabase, coll, schema)
+	assert.Nil(t, err)
+	assert.True(t, drift.InSync())
+
+	_, err = m.InsertOne(mongoDatabase, coll, SchemaUser{Name: "valid", Age: 20, CreatedAt: time.Now()})
+	assert.Nil(t, err)
+
+	var invalid = bson.M{"name": 12}
+	_, err = m.InsertOne(mongoDatabase, coll, invalid)
+	assert.True(t, m.IsValidationError(err))
+	assert.Equal(t, invalid, err.(*ValidationError).Document)
+
+	_, err = m.UpdateOne(mongoDatabase, coll, bson.M{"name": "valid"}, bson.M{"$set": bson.M{"age": "twenty"}})
+	assert.True(t, m.IsValidationError(err))
+	assert.Equal(t, bson.M{"name": "valid"}, err.(*ValidationError).Filter)
+
+	schema.Action = ValidationActionWarn
+	drift, _ = m.SchemaDrift(mongoDatabase, coll, schema)
+	assert.True(t, drift.ActionDiffers)
+	assert.False(t, drift.ValidatorDiffers)
+	assert.Nil(t, m.ApplySchema(mongoDatabase, coll, schema))
+	drift, _ = m.SchemaDrift(mongoDatabase, coll, schema)
+	assert.True(t, drift.InSync())
+}
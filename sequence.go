@@ -0,0 +1,142 @@
+package mongoadapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SequenceOptions configures NewSequence()
+type SequenceOptions struct {
+	// Collection keeps the counters, defaults to "counters", one document per key
+	Collection string
+	// Start is the first value of the sequence, defaults to 1
+	Start int64
+	// BlockSize is how many values are reserved per round-trip and handed out
+	// from memory, defaults to 1. Values of a block that are not used before
+	// the process stops are lost, leaving a gap in the sequence.
+	BlockSize int64
+	// Prefix and Padding are used by NextID() to format the values,
+	// e.g. "ORD-" and 6 give ORD-000042
+	Prefix  string
+	Padding int
+}
+
+// Sequence hands out increasing integers for a key, backed by a counter
+// document updated with $inc. Values are unique across processes but may
+// have gaps, and with blocks they are only increasing within a process.
+type Sequence struct {
+	m       *Mongo
+	db      string
+	key     string
+	options SequenceOptions
+
+	mu    sync.Mutex
+	next  int64
+	limit int64
+}
+
+type counterDocument struct {
+	Key   string `bson:"_id"`
+	Value int64  `bson:"value"`
+}
+
+// NewSequence returns the sequence of key on the given database
+func NewSequence(m *Mongo, db, key string, opts SequenceOptions) *Sequence {
+	if opts.Collection == "" {
+		opts.Collection = "counters"
+	}
+	if opts.Start == 0 {
+		opts.Start = 1
+	}
+	if opts.BlockSize <= 0 {
+		opts.BlockSize = 1
+	}
+	return &Sequence{m: m, db: db, key: key, options: opts}
+}
+
+// Next returns the next value of the sequence
+func (s *Sequence) Next(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.next >= s.limit {
+		first, err := s.reserve(ctx, s.options.BlockSize)
+		if err != nil {
+			return 0, err
+		}
+		s.next, s.limit = first, first+s.options.BlockSize
+	}
+	var value = s.next
+	s.next++
+	return value, nil
+}
+
+// NextID returns the next value formatted with the prefix and padding of the sequence
+func (s *Sequence) NextID(ctx context.Context) (string, error) {
+	value, err := s.Next(ctx)
+	if err != nil {
+		return "", err
+	}
+	return s.Format(value), nil
+}
+
+// Format formats value with the prefix and padding of the sequence
+func (s *Sequence) Format(value int64) string {
+	return fmt.Sprintf("%s%0*d", s.options.Prefix, s.options.Padding, value)
+}
+
+// Reserve allocates n consecutive values in a single round-trip, bypassing
+// the in-memory block, and returns the first of them
+func (s *Sequence) Reserve(ctx context.Context, n int64) (int64, error) {
+	if n <= 0 {
+		return 0, errors.New("the number of values to reserve must be positive")
+	}
+	return s.reserve(ctx, n)
+}
+
+// Current returns the last value allocated by any process, or Start-1 if
+// none was allocated yet
+func (s *Sequence) Current(ctx context.Context) (int64, error) {
+	var counter counterDocument
+	err := s.m.collection(s.db, s.options.Collection).FindOne(ctx, bson.M{"_id": s.key}).Decode(&counter)
+	if err != nil && !s.m.NoDocument(err) {
+		return 0, err
+	}
+	return s.options.Start - 1 + counter.Value, nil
+}
+
+// reserve increments the counter by n. The counter stores the number of
+// allocated values, so that the first upsert does not need to know Start.
+func (s *Sequence) reserve(ctx context.Context, n int64) (int64, error) {
+	var counter counterDocument
+	var find = func() error {
+		return s.m.collection(s.db, s.options.Collection).FindOneAndUpdate(ctx,
+			bson.M{"_id": s.key},
+			bson.M{"$inc": bson.M{"value": n}},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+		).Decode(&counter)
+	}
+	err := find()
+	if isDuplicateKey(s.m, err) {
+		// two processes created the counter at the same time, it exists now
+		err = find()
+	}
+	if err != nil {
+		return 0, err
+	}
+	return s.options.Start - 1 + counter.Value - n + 1, nil
+}
+
+// isDuplicateKey extends IsDupError to the command errors returned by
+// findAndModify
+func isDuplicateKey(m *Mongo, err error) bool {
+	if cmdErr, ok := err.(mongo.CommandError); ok {
+		return cmdErr.Code == 11000
+	}
+	return m.IsDupError(err)
+}
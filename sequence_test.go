@@ -0,0 +1,65 @@
+package mongoadapter
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSequence_Next(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	_ = m.conn.Database(mongoDatabase).Collection("testCounters").Drop(context.Background())
+	ctx := context.Background()
+
+	orders := NewSequence(m, mongoDatabase, "orders", SequenceOptions{Collection: "testCounters", Start: 1000})
+	invoices := NewSequence(m, mongoDatabase, "invoices", SequenceOptions{Collection: "testCounters", Prefix: "INV-", Padding: 5})
+
+	value, err := orders.Next(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1000), value)
+	value, _ = orders.Next(ctx)
+	assert.Equal(t, int64(1001), value)
+
+	id, err := invoices.NextID(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "INV-00001", id)
+
+	first, err := orders.Reserve(ctx, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1002), first)
+	current, err := orders.Current(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1011), current)
+}
+
+func TestSequence_Next_mustBeUniqueWithBlocks(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	_ = m.conn.Database(mongoDatabase).Collection("testCounters").Drop(context.Background())
+
+	var seen sync.Map
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		// each sequence stands for a process with its own blocks
+		seq := NewSequence(m, mongoDatabase, "shared", SequenceOptions{Collection: "testCounters", BlockSize: 5})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				value, err := seq.Next(context.Background())
+				assert.Nil(t, err)
+				_, duplicate := seen.LoadOrStore(value, true)
+				assert.False(t, duplicate)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSequence_Format(t *testing.T) {
+	seq := NewSequence(nil, "", "", SequenceOptions{Prefix: "ORD-", Padding: 6})
+	assert.Equal(t, "ORD-000042", seq.Format(42))
+	assert.Equal(t, "ORD-1234567", seq.Format(1234567))
+	assert.Equal(t, "7", NewSequence(nil, "", "", SequenceOptions{}).Format(7))
+}
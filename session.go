@@ -0,0 +1,330 @@
+package mongoadapter
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx"
+)
+
+// SessionOptions configures NewSessionStore()
+type SessionOptions struct {
+	// Secret signs the session ids in cookies, it is required
+	Secret []byte
+	// Collection keeps the sessions, defaults to "sessions"
+	Collection string
+	// MaxAge is how long a session lives without being used, defaults to 24 hours
+	MaxAge time.Duration
+	// Cookie settings, the name defaults to "session" and the path to "/".
+	// Cookies are always HttpOnly.
+	CookieName string
+	CookiePath string
+	Domain     string
+	Secure     bool
+	SameSite   http.SameSite
+}
+
+// SessionStore persists HTTP sessions in a collection, expired sessions are
+// removed by a TTL index
+type SessionStore struct {
+	m       *Mongo
+	db      string
+	options SessionOptions
+}
+
+// Session is the state kept for a client between requests
+type Session struct {
+	ID        string                 `bson:"_id"`
+	Values    map[string]interface{} `bson:"values"`
+	CreatedAt time.Time              `bson:"createdAt"`
+	ExpiresAt time.Time              `bson:"expiresAt"`
+
+	mu        sync.Mutex
+	isNew     bool
+	modified  bool
+	destroyed bool
+}
+
+// Get returns the value of key, or nil
+func (s *Session) Get(key string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Values[key]
+}
+
+// Set sets the value of key, the session is saved by the middleware
+func (s *Session) Set(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Values[key] = value
+	s.modified = true
+}
+
+// Delete removes key from the session
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Values, key)
+	s.modified = true
+}
+
+// Destroy makes the middleware destroy the session and clear its cookie
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destroyed = true
+}
+
+// NewSessionStore creates a SessionStore on the given database and its TTL index
+func NewSessionStore(m *Mongo, db string, opts SessionOptions) (*SessionStore, error) {
+	if len(opts.Secret) == 0 {
+		return nil, errors.New("session store needs a secret to sign the cookies")
+	}
+	if opts.Collection == "" {
+		opts.Collection = "sessions"
+	}
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = 24 * time.Hour
+	}
+	if opts.CookieName == "" {
+		opts.CookieName = "session"
+	}
+	if opts.CookiePath == "" {
+		opts.CookiePath = "/"
+	}
+	ctx, cancel := context.WithTimeout(m.context(), m.writeTimeout)
+	defer cancel()
+	_, err := m.collection(db, opts.Collection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bsonx.Doc{{Key: "expiresAt", Value: bsonx.Int32(1)}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &SessionStore{m: m, db: db, options: opts}, nil
+}
+
+func (st *SessionStore) coll() *mongo.Collection {
+	return st.m.collection(st.db, st.options.Collection)
+}
+
+// New returns a session with a fresh random id, it is stored on the first Save()
+func (st *SessionStore) New() (*Session, error) {
+	var id = make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	var now = time.Now().UTC()
+	return &Session{
+		ID:        base64.RawURLEncoding.EncodeToString(id),
+		Values:    map[string]interface{}{},
+		CreatedAt: now,
+		ExpiresAt: now.Add(st.options.MaxAge),
+		isNew:     true,
+	}, nil
+}
+
+// Create returns a new session that is already stored
+func (st *SessionStore) Create(ctx context.Context) (*Session, error) {
+	session, err := st.New()
+	if err != nil {
+		return nil, err
+	}
+	return session, st.Save(ctx, session)
+}
+
+// Load returns the session with the given id, it returns
+// mongo.ErrNoDocuments if it does not exist or expired
+func (st *SessionStore) Load(ctx context.Context, id string) (*Session, error) {
+	var session Session
+	err := st.coll().FindOne(ctx, bson.M{"_id": id, "expiresAt": bson.M{"$gt": time.Now().UTC()}}).Decode(&session)
+	if err != nil {
+		return nil, err
+	}
+	if session.Values == nil {
+		session.Values = map[string]interface{}{}
+	}
+	return &session, nil
+}
+
+// Save stores the values of the session and extends its lifetime
+func (st *SessionStore) Save(ctx context.Context, session *Session) error {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	var expiresAt = time.Now().UTC().Add(st.options.MaxAge)
+	_, err := st.coll().UpdateOne(ctx, bson.M{"_id": session.ID}, bson.M{
+		"$set":         bson.M{"values": session.Values, "expiresAt": expiresAt},
+		"$setOnInsert": bson.M{"createdAt": session.CreatedAt},
+	}, options.Update().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+	session.ExpiresAt, session.isNew, session.modified = expiresAt, false, false
+	return nil
+}
+
+// Touch extends the lifetime of the session without changing its values
+func (st *SessionStore) Touch(ctx context.Context, session *Session) error {
+	var expiresAt = time.Now().UTC().Add(st.options.MaxAge)
+	res, err := st.coll().UpdateOne(ctx, bson.M{"_id": session.ID}, bson.M{"$set": bson.M{"expiresAt": expiresAt}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	session.mu.Lock()
+	session.ExpiresAt = expiresAt
+	session.mu.Unlock()
+	return nil
+}
+
+// Destroy removes the session with the given id
+func (st *SessionStore) Destroy(ctx context.Context, id string) error {
+	_, err := st.coll().DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// GC removes the expired sessions without waiting for the TTL monitor and
+// returns how many were removed
+func (st *SessionStore) GC(ctx context.Context) (int64, error) {
+	res, err := st.coll().DeleteMany(ctx, bson.M{"expiresAt": bson.M{"$lte": time.Now().UTC()}})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+// sign returns the cookie value of a session id
+func (st *SessionStore) sign(id string) string {
+	mac := hmac.New(sha256.New, st.options.Secret)
+	_, _ = mac.Write([]byte(id))
+	return id + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify returns the session id of a cookie value if its signature is valid
+func (st *SessionStore) verify(value string) (string, bool) {
+	var i = strings.LastIndex(value, ".")
+	if i < 0 {
+		return "", false
+	}
+	var id = value[:i]
+	return id, hmac.Equal([]byte(st.sign(id)), []byte(value))
+}
+
+// SessionFromContext returns the session put in the request context by
+// the middleware, or nil
+func SessionFromContext(ctx context.Context) *Session {
+	session, _ := ctx.Value(sessionKey).(*Session)
+	return session
+}
+
+// Middleware loads the session of the signed cookie, or starts a new one,
+// and puts it in the request context. A new or modified session is saved,
+// and its cookie set, before the response headers are written; sessions
+// are touched when a tenth of their lifetime has passed.
+func (st *SessionStore) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var session *Session
+		var err error
+		if cookie, cerr := r.Cookie(st.options.CookieName); cerr == nil {
+			if id, ok := st.verify(cookie.Value); ok {
+				session, err = st.Load(r.Context(), id)
+			}
+		}
+		if err != nil && !st.m.NoDocument(err) {
+			http.Error(w, "failed to load the session", http.StatusInternalServerError)
+			return
+		}
+		if session == nil {
+			if session, err = st.New(); err != nil {
+				http.Error(w, "failed to create the session", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		var sw = &sessionWriter{ResponseWriter: w}
+		sw.commit = func() {
+			if err := st.commit(r.Context(), w, session); err != nil {
+				sw.failed = true
+				http.Error(w, "failed to save the session", http.StatusInternalServerError)
+			}
+		}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), sessionKey, session)))
+		sw.once.Do(sw.commit)
+	})
+}
+
+func (st *SessionStore) commit(ctx context.Context, w http.ResponseWriter, session *Session) error {
+	session.mu.Lock()
+	var isNew, modified, destroyed = session.isNew, session.modified, session.destroyed
+	var stale = time.Until(session.ExpiresAt) < st.options.MaxAge-st.options.MaxAge/10
+	session.mu.Unlock()
+
+	var cookie = &http.Cookie{
+		Name:     st.options.CookieName,
+		Value:    st.sign(session.ID),
+		Path:     st.options.CookiePath,
+		Domain:   st.options.Domain,
+		Secure:   st.options.Secure,
+		HttpOnly: true,
+		SameSite: st.options.SameSite,
+		MaxAge:   int(st.options.MaxAge / time.Second),
+	}
+	switch {
+	case destroyed:
+		cookie.Value, cookie.MaxAge = "", -1
+		if !isNew {
+			if err := st.Destroy(ctx, session.ID); err != nil {
+				return err
+			}
+		}
+	case modified:
+		if err := st.Save(ctx, session); err != nil {
+			return err
+		}
+	case !isNew && stale:
+		if err := st.Touch(ctx, session); err != nil {
+			return err
+		}
+	default:
+		// untouched new sessions are not stored
+		return nil
+	}
+	http.SetCookie(w, cookie)
+	return nil
+}
+
+// sessionWriter commits the session right before the headers are written
+type sessionWriter struct {
+	http.ResponseWriter
+	commit func()
+	once   sync.Once
+	failed bool
+}
+
+func (w *sessionWriter) WriteHeader(status int) {
+	w.once.Do(w.commit)
+	if !w.failed {
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *sessionWriter) Write(data []byte) (int, error) {
+	w.once.Do(w.commit)
+	if w.failed {
+		return len(data), nil
+	}
+	return w.ResponseWriter.Write(data)
+}
@@ -0,0 +1,109 @@
+package mongoadapter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createTestSessionStore(t *testing.T, opts SessionOptions) *SessionStore {
+	m, _ := NewMongo(mongoConfig)
+	opts.Collection = "testSessions"
+	opts.Secret = []byte("secret")
+	_ = m.conn.Database(mongoDatabase).Collection(opts.Collection).Drop(context.Background())
+	store, err := NewSessionStore(m, mongoDatabase, opts)
+	assert.Nil(t, err)
+	return store
+}
+
+func TestSessionStore_Create(t *testing.T) {
+	store := createTestSessionStore(t, SessionOptions{MaxAge: time.Hour})
+	ctx := context.Background()
+
+	session, err := store.Create(ctx)
+	assert.Nil(t, err)
+	session.Set("user", "sara")
+	assert.Nil(t, store.Save(ctx, session))
+
+	loaded, err := store.Load(ctx, session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "sara", loaded.Get("user"))
+	assert.Nil(t, store.Touch(ctx, loaded))
+
+	assert.Nil(t, store.Destroy(ctx, session.ID))
+	_, err = store.Load(ctx, session.ID)
+	assert.True(t, store.m.NoDocument(err))
+}
+
+func TestSessionStore_GC(t *testing.T) {
+	store := createTestSessionStore(t, SessionOptions{MaxAge: 50 * time.Millisecond})
+	ctx := context.Background()
+
+	session, err := store.Create(ctx)
+	assert.Nil(t, err)
+	time.Sleep(100 * time.Millisecond)
+	_, err = store.Load(ctx, session.ID)
+	assert.True(t, store.m.NoDocument(err))
+	removed, err := store.GC(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), removed)
+}
+
+func TestSessionStore_Middleware(t *testing.T) {
+	store := createTestSessionStore(t, SessionOptions{})
+	handler := store.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := SessionFromContext(r.Context())
+		switch r.URL.Path {
+		case "/login":
+			session.Set("user", "sara")
+		case "/logout":
+			session.Destroy()
+		}
+		_, _ = fmt.Fprint(w, session.Get("user"))
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	assert.Empty(t, rec.Result().Cookies())
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/login", nil))
+	cookies := rec.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	assert.True(t, cookies[0].HttpOnly)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, "sara", rec.Body.String())
+
+	// a tampered cookie starts a new session
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: cookies[0].Name, Value: cookies[0].Value + "x"})
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, "<nil>", rec.Body.String())
+
+	req = httptest.NewRequest("GET", "/logout", nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, -1, rec.Result().Cookies()[0].MaxAge)
+}
+
+func TestSessionStore_sign(t *testing.T) {
+	store := &SessionStore{options: SessionOptions{Secret: []byte("secret")}}
+	id, ok := store.verify(store.sign("abc"))
+	assert.True(t, ok)
+	assert.Equal(t, "abc", id)
+	_, ok = store.verify("abc.invalid")
+	assert.False(t, ok)
+	_, ok = store.verify("abc")
+	assert.False(t, ok)
+}
@@ -0,0 +1,188 @@
+package mongoadapter
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// collectionSettings holds the opt-in behaviours enabled for a collection
+// through the Enable...() methods of Mongo
+type collectionSettings struct {
+	timestamps   *TimestampOptions
+	versionField string
+	softDelete   *SoftDeleteOptions
+	audit        *AuditOptions
+	cacheTTL     time.Duration
+	encryption   *encryptionSettings
+}
+
+// settingsRegistry is shared by an instance and all of its views, the
+// settings are keyed by db+"."+coll
+type settingsRegistry struct {
+	sync.RWMutex
+	collections map[string]collectionSettings
+	cache       *queryCache
+	keys        KeyProvider
+}
+
+func newSettingsRegistry() *settingsRegistry {
+	return &settingsRegistry{collections: make(map[string]collectionSettings), cache: newQueryCache()}
+}
+
+// settings returns a copy of the settings of the given collection,
+// the zero value if nothing is enabled for it
+func (m *Mongo) settings(db, coll string) collectionSettings {
+	if m.registry == nil {
+		return collectionSettings{}
+	}
+	m.registry.RLock()
+	defer m.registry.RUnlock()
+	return m.registry.collections[db+"."+coll]
+}
+
+// updateSettings applies fn to the settings of the given collection
+func (m *Mongo) updateSettings(db, coll string, fn func(s *collectionSettings)) {
+	m.registry.Lock()
+	defer m.registry.Unlock()
+	var s = m.registry.collections[db+"."+coll]
+	fn(&s)
+	m.registry.collections[db+"."+coll] = s
+}
+
+// toDocument converts a struct, map or document into a bson.D so that the
+// adapter can add or change top-level fields before sending it
+func toDocument(v interface{}) (bson.D, error) {
+	if doc, ok := v.(bson.D); ok {
+		return append(bson.D(nil), doc...), nil
+	}
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil, errors.New("failed to convert the value into a document, got error: " + err.Error())
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, errors.New("failed to convert the value into a document, got error: " + err.Error())
+	}
+	return doc, nil
+}
+
+// isUpdatePipeline reports whether an update is an aggregation pipeline,
+// e.g. a mongo.Pipeline or a bson.A of stages, rather than a document of
+// update operators. It tells them apart the way the driver does.
+func isUpdatePipeline(update interface{}) bool {
+	switch update.(type) {
+	case bson.D, bson.Raw, []byte:
+		return false
+	}
+	var kind = reflect.ValueOf(update).Kind()
+	return kind == reflect.Slice || kind == reflect.Array
+}
+
+// lookup returns the value of the top-level key of doc
+func lookup(doc bson.D, key string) (interface{}, bool) {
+	for _, e := range doc {
+		if e.Key == key {
+			return e.Value, true
+		}
+	}
+	return nil, false
+}
+
+// set sets the top-level key of doc, appending it when missing
+func set(doc bson.D, key string, value interface{}) bson.D {
+	for i, e := range doc {
+		if e.Key == key {
+			doc[i].Value = value
+			return doc
+		}
+	}
+	return append(doc, bson.E{Key: key, Value: value})
+}
+
+// setOperatorField sets field inside the operator document (e.g. $set) of
+// an update, creating the operator document if needed
+func setOperatorField(update bson.D, operator, field string, value interface{}) (bson.D, error) {
+	current, ok := lookup(update, operator)
+	if !ok {
+		return append(update, bson.E{Key: operator, Value: bson.D{{Key: field, Value: value}}}), nil
+	}
+	operatorDoc, err := toDocument(current)
+	if err != nil {
+		return nil, err
+	}
+	return set(update, operator, set(operatorDoc, field, value)), nil
+}
+
+// updatesField reports whether any operator of the update touches field
+func updatesField(update bson.D, field string) bool {
+	for _, e := range update {
+		operatorDoc, err := toDocument(e.Value)
+		if err != nil {
+			continue
+		}
+		if _, ok := lookup(operatorDoc, field); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// prepareInsert applies the enabled behaviours of the collection to
+// documents about to be inserted
+func (m *Mongo) prepareInsert(s collectionSettings, docs []interface{}) ([]interface{}, error) {
+	if s.timestamps == nil && s.encryption == nil {
+		return docs, nil
+	}
+	var now = time.Now().UTC()
+	var result = make([]interface{}, len(docs))
+	for i, doc := range docs {
+		var err error
+		if s.timestamps != nil {
+			if doc, err = s.timestamps.stampInsert(doc, now); err != nil {
+				return nil, err
+			}
+		}
+		if s.encryption != nil {
+			if doc, err = m.encryptDocument(s.encryption, doc); err != nil {
+				return nil, err
+			}
+		}
+		result[i] = doc
+	}
+	return result, nil
+}
+
+// prepareUpdate applies the enabled behaviours of the collection to an
+// update document
+func (m *Mongo) prepareUpdate(s collectionSettings, update interface{}, opts []*options.UpdateOptions) (interface{}, error) {
+	var err error
+	if s.timestamps != nil {
+		if update, err = s.timestamps.stampUpdate(update, isUpsert(opts), time.Now().UTC()); err != nil {
+			return nil, err
+		}
+	}
+	if s.encryption != nil {
+		return m.encryptUpdate(s.encryption, update)
+	}
+	return update, nil
+}
+
+// prepareReplace applies the enabled behaviours of the collection to a
+// replacement document
+func (m *Mongo) prepareReplace(s collectionSettings, replacement interface{}) (interface{}, error) {
+	var err error
+	if s.timestamps != nil {
+		if replacement, err = s.timestamps.stampReplacement(replacement, time.Now().UTC()); err != nil {
+			return nil, err
+		}
+	}
+	if s.encryption != nil {
+		return m.encryptDocument(s.encryption, replacement)
+	}
+	return replacement, nil
+}
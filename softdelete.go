@@ -0,0 +1,128 @@
+package mongoadapter
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SoftDeleteOptions configures EnableSoftDelete(). DeletedAtField defaults
+// to "deletedAt", DeletedByField is only set when not empty and the actor
+// is known, see WithActor().
+type SoftDeleteOptions struct {
+	DeletedAtField string
+	DeletedByField string
+}
+
+// EnableSoftDelete makes DeleteOne and DeleteMany mark documents as deleted
+// instead of removing them, and makes FindOne, FindMany, FindWhereIn, Search,
+// SearchCount and Count skip marked documents. Use a view with
+// IncludeDeleted() to read them, Restore() to unmark them and Purge() to
+// remove them for good.
+func (m *Mongo) EnableSoftDelete(db, coll string, opts SoftDeleteOptions) {
+	if opts.DeletedAtField == "" {
+		opts.DeletedAtField = "deletedAt"
+	}
+	m.updateSettings(db, coll, func(s *collectionSettings) {
+		s.softDelete = &opts
+	})
+}
+
+// DisableSoftDelete makes deletes physical again, already marked documents
+// become visible to reads
+func (m *Mongo) DisableSoftDelete(db, coll string) {
+	m.updateSettings(db, coll, func(s *collectionSettings) {
+		s.softDelete = nil
+	})
+}
+
+// IncludeDeleted makes the reads of the view return soft-deleted documents too
+func IncludeDeleted() Option {
+	return func(m *Mongo) {
+		m.includeDeleted = true
+	}
+}
+
+// notDeleted returns the condition matching documents that are not soft-deleted,
+// nil if soft delete is not enabled for the collection or the view includes them
+func (m *Mongo) notDeleted(s collectionSettings) bson.M {
+	if s.softDelete == nil || m.includeDeleted {
+		return nil
+	}
+	// null also matches a missing field
+	return bson.M{s.softDelete.DeletedAtField: nil}
+}
+
+// readFilter restricts filter to documents that are not soft-deleted
+func (m *Mongo) readFilter(db, coll string, filter interface{}) interface{} {
+	return andFilter(filter, m.notDeleted(m.settings(db, coll)))
+}
+
+// andFilter combines filter with condition, either may be nil
+func andFilter(filter interface{}, condition bson.M) interface{} {
+	if condition == nil {
+		return filter
+	}
+	if filter == nil {
+		return condition
+	}
+	return bson.M{"$and": bson.A{filter, condition}}
+}
+
+// softDelete marks the documents matching filter as deleted, it is used by
+// DeleteOne and DeleteMany when soft delete is enabled
+func (m *Mongo) softDelete(db, coll string, s collectionSettings, filter interface{}, many bool, opts []*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	var fields = bson.M{s.softDelete.DeletedAtField: time.Now().UTC()}
+	if actor := ActorFromContext(m.context()); s.softDelete.DeletedByField != "" && actor != "" {
+		fields[s.softDelete.DeletedByField] = actor
+	}
+	var updateOptions = options.Update()
+	for _, v := range opts {
+		if v != nil && v.Collation != nil {
+			updateOptions.SetCollation(v.Collation)
+		}
+	}
+	var update = bson.M{"$set": fields}
+	var res *mongo.UpdateResult
+	var err error
+	if many {
+		res, err = m.UpdateMany(db, coll, m.readFilter(db, coll, filter), update, updateOptions)
+	} else {
+		res, err = m.UpdateOne(db, coll, m.readFilter(db, coll, filter), update, updateOptions)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &mongo.DeleteResult{DeletedCount: res.ModifiedCount}, nil
+}
+
+// Restore unmarks the soft-deleted documents matching filter
+func (m *Mongo) Restore(db, coll string, filter interface{}) (*mongo.UpdateResult, error) {
+	var s = m.settings(db, coll)
+	if s.softDelete == nil {
+		return &mongo.UpdateResult{}, nil
+	}
+	var unset = bson.M{s.softDelete.DeletedAtField: ""}
+	if s.softDelete.DeletedByField != "" {
+		unset[s.softDelete.DeletedByField] = ""
+	}
+	var deleted = bson.M{s.softDelete.DeletedAtField: bson.M{"$ne": nil}}
+	return m.With(IncludeDeleted()).UpdateMany(db, coll, andFilter(filter, deleted), bson.M{"$unset": unset})
+}
+
+// Purge physically removes the documents that were soft-deleted more than
+// olderThan ago, pass 0 to remove all of them
+func (m *Mongo) Purge(db, coll string, olderThan time.Duration) (*mongo.DeleteResult, error) {
+	var s = m.settings(db, coll)
+	if s.softDelete == nil {
+		return &mongo.DeleteResult{}, nil
+	}
+	defer m.invalidateCache(db, coll)
+	ctx, cancel := context.WithTimeout(m.context(), m.writeTimeout)
+	defer cancel()
+	var filter = bson.M{s.softDelete.DeletedAtField: bson.M{"$lte": time.Now().UTC().Add(-olderThan)}}
+	return m.collection(db, coll).DeleteMany(ctx, filter)
+}
@@ -0,0 +1,54 @@
+package mongoadapter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMongo_EnableSoftDelete(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	var coll = "softDeleted"
+	_ = m.conn.Database(mongoDatabase).Collection(coll).Drop(context.Background())
+	m.EnableSoftDelete(mongoDatabase, coll, SoftDeleteOptions{DeletedByField: "deletedBy"})
+	defer m.DisableSoftDelete(mongoDatabase, coll)
+	_, err := insertDummyUser(mongoDatabase, coll, 5)
+	assert.Nil(t, err)
+	_, _ = m.InsertOne(mongoDatabase, coll, bson.M{"name": "john", "email": "john@email.com"})
+
+	ctx := WithActor(context.Background(), "admin")
+	res, err := m.With(Context(ctx)).DeleteOne(mongoDatabase, coll, bson.M{"name": "john"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), res.DeletedCount)
+
+	assert.True(t, m.NoDocument(m.FindOne(mongoDatabase, coll, bson.M{"name": "john"}).Err()))
+	count, _ := m.Count(mongoDatabase, coll, bson.M{})
+	assert.Equal(t, int64(5), count)
+	cur, _ := m.FindWhereIn(mongoDatabase, coll, false, []string{"name", "john"})
+	assert.Equal(t, 0, CountCursor(cur))
+	searchCount, _ := m.SearchCount(mongoDatabase, coll, nil)
+	assert.Equal(t, int64(5), searchCount)
+
+	var deleted bson.M
+	assert.Nil(t, m.With(IncludeDeleted()).FindOne(mongoDatabase, coll, bson.M{"name": "john"}).Decode(&deleted))
+	assert.Equal(t, "admin", deleted["deletedBy"])
+	assert.Contains(t, deleted, "deletedAt")
+
+	restored, err := m.Restore(mongoDatabase, coll, bson.M{"name": "john"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), restored.ModifiedCount)
+	assert.Nil(t, m.FindOne(mongoDatabase, coll, bson.M{"name": "john"}).Err())
+
+	res, err = m.DeleteMany(mongoDatabase, coll, bson.M{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), res.DeletedCount)
+	purged, err := m.Purge(mongoDatabase, coll, time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), purged.DeletedCount)
+	purged, err = m.Purge(mongoDatabase, coll, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), purged.DeletedCount)
+}
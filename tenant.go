@@ -0,0 +1,430 @@
+package mongoadapter
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TenantStrategy is how the data of the tenants is kept apart
+type TenantStrategy int
+
+const (
+	// TenantDatabase gives every tenant its own database
+	TenantDatabase TenantStrategy = iota
+	// TenantCollectionPrefix prefixes the collections of a shared database
+	// with the tenant
+	TenantCollectionPrefix
+	// TenantField stores the tenant in a field of every document of the
+	// shared collections, reads and writes are restricted to it
+	TenantField
+)
+
+var (
+	// ErrNoTenant is returned when the context carries no tenant
+	ErrNoTenant = errors.New("no tenant in the context, see WithTenant()")
+	// ErrCrossTenant is returned for operations that would read or write
+	// the data of another tenant
+	ErrCrossTenant = errors.New("cross-tenant access")
+)
+
+// tenants are used in database and collection names, so only safe
+// characters are accepted
+var tenantPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,47}$`)
+
+// systemDatabases can't be tenants of TenantDatabase without a prefix
+var systemDatabases = []string{"admin", "local", "config"}
+
+// TenantOptions configures Tenancy()
+type TenantOptions struct {
+	Strategy TenantStrategy
+	// Database is the shared database of the prefix and field strategies.
+	// With TenantDatabase it is the prefix of the tenant databases, e.g.
+	// "app" gives "app_acme", and may be empty.
+	Database string
+	// Separator joins the prefix and the tenant, "_" by default
+	Separator string
+	// Field holds the tenant with TenantField, "tenantId" by default
+	Field string
+	// Resolve returns the tenant of a context, TenantFromContext() by default
+	Resolve func(ctx context.Context) (string, error)
+}
+
+// WithTenant returns a copy of ctx carrying the tenant the operations of
+// a TenantView are performed for
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey, tenant)
+}
+
+// TenantFromContext returns the tenant set by WithTenant(), empty if there is none
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey).(string)
+	return tenant
+}
+
+// Tenancy routes the operations of its views to the tenant of their
+// context, create it once with Mongo.Tenancy()
+type Tenancy struct {
+	m    *Mongo
+	opts TenantOptions
+}
+
+// Tenancy returns the multi-tenant router of m with the given strategy
+func (m *Mongo) Tenancy(opts TenantOptions) (*Tenancy, error) {
+	if opts.Strategy != TenantDatabase && opts.Database == "" {
+		return nil, errors.New("the shared database of the tenants is required")
+	}
+	if opts.Separator == "" {
+		opts.Separator = "_"
+	}
+	if opts.Field == "" {
+		opts.Field = "tenantId"
+	}
+	if opts.Resolve == nil {
+		opts.Resolve = func(ctx context.Context) (string, error) {
+			if tenant := TenantFromContext(ctx); tenant != "" {
+				return tenant, nil
+			}
+			return "", ErrNoTenant
+		}
+	}
+	return &Tenancy{m: m, opts: opts}, nil
+}
+
+// TenantView performs operations for a single tenant, collections are
+// given by their logical name and the view picks the database, the
+// collection and the filters of the tenant. The view has no way to reach
+// the data of other tenants. Collection settings, e.g. EnableSoftDelete(),
+// apply to the physical database and collection.
+type TenantView struct {
+	m      *Mongo
+	opts   TenantOptions
+	tenant string
+	db     string
+}
+
+// View returns the view of the tenant of ctx, the operations of the view
+// derive their contexts from ctx:
+// users, err := tenancy.View(WithTenant(r.Context(), "acme"))
+func (t *Tenancy) View(ctx context.Context) (*TenantView, error) {
+	tenant, err := t.opts.Resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.checkTenant(tenant); err != nil {
+		return nil, err
+	}
+	var db = t.opts.Database
+	if t.opts.Strategy == TenantDatabase {
+		db = tenant
+		if t.opts.Database != "" {
+			db = t.opts.Database + t.opts.Separator + tenant
+		}
+	}
+	return &TenantView{m: t.m.With(Context(ctx)), opts: t.opts, tenant: tenant, db: db}, nil
+}
+
+// checkTenant refuses the tenants whose names would reach the data of
+// another tenant or the system databases
+func (t *Tenancy) checkTenant(tenant string) error {
+	if !tenantPattern.MatchString(tenant) {
+		return errors.New("invalid tenant " + strconv.Quote(tenant))
+	}
+	switch t.opts.Strategy {
+	case TenantCollectionPrefix:
+		// "acme" with "x_orders" and "acme_x" with "orders" would share a collection
+		if strings.Contains(tenant, t.opts.Separator) {
+			return errors.New("invalid tenant " + strconv.Quote(tenant) + ", it contains the separator " + strconv.Quote(t.opts.Separator))
+		}
+	case TenantDatabase:
+		if t.opts.Database != "" {
+			break
+		}
+		for _, db := range systemDatabases {
+			if strings.EqualFold(tenant, db) {
+				return errors.New("invalid tenant " + strconv.Quote(tenant) + ", it is a system database")
+			}
+		}
+	}
+	return nil
+}
+
+// Tenant returns the tenant of the view
+func (v *TenantView) Tenant() string {
+	return v.tenant
+}
+
+// Database returns the database the view works on
+func (v *TenantView) Database() string {
+	return v.db
+}
+
+// Collection returns the physical name of the logical collection coll
+func (v *TenantView) Collection(coll string) string {
+	if v.opts.Strategy == TenantCollectionPrefix {
+		return v.tenant + v.opts.Separator + coll
+	}
+	return coll
+}
+
+// filter restricts filter to the documents of the tenant
+func (v *TenantView) filter(filter interface{}) interface{} {
+	if v.opts.Strategy != TenantField {
+		return filter
+	}
+	return andFilter(filter, bson.M{v.opts.Field: v.tenant})
+}
+
+// document sets the tenant field of a document about to be stored, a
+// document of another tenant is refused
+func (v *TenantView) document(doc interface{}) (interface{}, error) {
+	if v.opts.Strategy != TenantField {
+		return doc, nil
+	}
+	d, err := toDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	if current, ok := lookup(d, v.opts.Field); ok && current != v.tenant {
+		return nil, ErrCrossTenant
+	}
+	return set(d, v.opts.Field, v.tenant), nil
+}
+
+// update refuses updates moving documents to another tenant
+func (v *TenantView) update(update interface{}) error {
+	if v.opts.Strategy != TenantField {
+		return nil
+	}
+	d, err := toDocument(update)
+	if err != nil {
+		return err
+	}
+	if touchesField(d, v.opts.Field) {
+		return ErrCrossTenant
+	}
+	return nil
+}
+
+// touchesField reports whether an update changes field or a path inside
+// it, either as the key of an operator or as the target of $rename
+func touchesField(update bson.D, field string) bool {
+	var within = func(path string) bool {
+		return path == field || strings.HasPrefix(path, field+".")
+	}
+	for _, e := range update {
+		operatorDoc, err := toDocument(e.Value)
+		if err != nil {
+			continue
+		}
+		for _, op := range operatorDoc {
+			if within(op.Key) {
+				return true
+			}
+			if target, ok := op.Value.(string); ok && e.Key == "$rename" && within(target) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pipeline restricts an aggregation to the documents of the tenant and
+// refuses the stages that read or write other collections or databases.
+// With TenantField a $match stage is prepended, or the tenant is added to
+// the query of a leading $geoNear, which must stay first.
+func (v *TenantView) pipeline(pipeline interface{}) (bson.A, error) {
+	parsed, err := parsePipeline(pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var stages = bson.A{}
+	if v.opts.Strategy == TenantField {
+		var tenant = bson.M{v.opts.Field: v.tenant}
+		if len(parsed) > 0 && len(parsed[0]) == 1 && parsed[0][0].Key == "$geoNear" {
+			geoNear, err := toDocument(parsed[0][0].Value)
+			if err != nil {
+				return nil, err
+			}
+			query, _ := lookup(geoNear, "query")
+			parsed[0] = bson.D{{Key: "$geoNear", Value: set(geoNear, "query", andFilter(query, tenant))}}
+		} else {
+			stages = append(stages, bson.M{"$match": tenant})
+		}
+	}
+	for _, stage := range parsed {
+		if err := v.checkStage(stage); err != nil {
+			return nil, err
+		}
+		stages = append(stages, stage)
+	}
+	return stages, nil
+}
+
+func parsePipeline(pipeline interface{}) ([]bson.D, error) {
+	data, err := bson.Marshal(bson.M{"pipeline": pipeline})
+	if err != nil {
+		return nil, errors.New("invalid pipeline, got error: " + err.Error())
+	}
+	var parsed struct {
+		Pipeline []bson.D `bson:"pipeline"`
+	}
+	if err := bson.Unmarshal(data, &parsed); err != nil {
+		return nil, errors.New("invalid pipeline, got error: " + err.Error())
+	}
+	return parsed.Pipeline, nil
+}
+
+func (v *TenantView) checkStage(stage bson.D) error {
+	for _, e := range stage {
+		switch e.Key {
+		case "$lookup", "$graphLookup", "$unionWith", "$out", "$merge":
+			// the tenant databases contain nothing but the tenant's data,
+			// as long as the stage doesn't name another database
+			if v.opts.Strategy != TenantDatabase {
+				return ErrCrossTenant
+			}
+			if target, ok := e.Value.(bson.D); ok {
+				for _, key := range []string{"into", "from"} {
+					if nested, ok := lookup(target, key); ok {
+						if nestedDoc, ok := nested.(bson.D); ok {
+							target = nestedDoc
+						}
+					}
+				}
+				if db, ok := lookup(target, "db"); ok && db != v.db {
+					return ErrCrossTenant
+				}
+			}
+		case "$collStats", "$indexStats", "$planCacheStats":
+			// they must come first and report on the whole collection
+			if v.opts.Strategy == TenantField {
+				return errors.New(e.Key + " reports on the documents of every tenant, it can't be used with TenantField")
+			}
+		case "$facet":
+			facets, _ := e.Value.(bson.D)
+			for _, facet := range facets {
+				stages, err := parsePipeline(facet.Value)
+				if err != nil {
+					return err
+				}
+				for _, stage := range stages {
+					if err := v.checkStage(stage); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// FindOne is Mongo.FindOne() on the tenant's collection
+func (v *TenantView) FindOne(coll string, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	return v.m.FindOne(v.db, v.Collection(coll), v.filter(filter), opts...)
+}
+
+// FindMany is Mongo.FindMany() on the tenant's collection
+func (v *TenantView) FindMany(coll string, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	return v.m.FindMany(v.db, v.Collection(coll), v.filter(filter), opts...)
+}
+
+// Count is Mongo.Count() on the tenant's collection
+func (v *TenantView) Count(coll string, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	return v.m.Count(v.db, v.Collection(coll), v.filter(filter), opts...)
+}
+
+// Search is Mongo.Search() on the tenant's collection
+func (v *TenantView) Search(coll string, filters map[string][]string, sorting map[string]int, limit, skip int64) (*mongo.Cursor, error) {
+	return v.m.Search(v.db, v.Collection(coll), v.searchFilters(filters), sorting, limit, skip)
+}
+
+// SearchCount is Mongo.SearchCount() on the tenant's collection
+func (v *TenantView) SearchCount(coll string, filters map[string][]string) (int64, error) {
+	return v.m.SearchCount(v.db, v.Collection(coll), v.searchFilters(filters))
+}
+
+func (v *TenantView) searchFilters(filters map[string][]string) map[string][]string {
+	if v.opts.Strategy != TenantField {
+		return filters
+	}
+	var result = make(map[string][]string, len(filters)+1)
+	for k, f := range filters {
+		result[k] = f
+	}
+	result[v.opts.Field] = []string{v.tenant, "eq"}
+	return result
+}
+
+// Aggregate is Mongo.Aggregate() on the tenant's collection. Stages
+// reaching other collections ($lookup, $graphLookup, $unionWith, $out and
+// $merge) are only accepted by the TenantDatabase strategy.
+func (v *TenantView) Aggregate(coll string, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	stages, err := v.pipeline(pipeline)
+	if err != nil {
+		return nil, err
+	}
+	return v.m.Aggregate(v.db, v.Collection(coll), stages, opts...)
+}
+
+// InsertOne is Mongo.InsertOne() on the tenant's collection
+func (v *TenantView) InsertOne(coll string, doc interface{}) (*mongo.InsertOneResult, error) {
+	doc, err := v.document(doc)
+	if err != nil {
+		return nil, err
+	}
+	return v.m.InsertOne(v.db, v.Collection(coll), doc)
+}
+
+// InsertMany is Mongo.InsertMany() on the tenant's collection
+func (v *TenantView) InsertMany(coll string, docs []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error) {
+	var prepared = make([]interface{}, len(docs))
+	for i, doc := range docs {
+		var err error
+		if prepared[i], err = v.document(doc); err != nil {
+			return nil, err
+		}
+	}
+	return v.m.InsertMany(v.db, v.Collection(coll), prepared, opts...)
+}
+
+// UpdateOne is Mongo.UpdateOne() on the tenant's collection
+func (v *TenantView) UpdateOne(coll string, filter interface{}, data interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	if err := v.update(data); err != nil {
+		return nil, err
+	}
+	return v.m.UpdateOne(v.db, v.Collection(coll), v.filter(filter), data, opts...)
+}
+
+// UpdateMany is Mongo.UpdateMany() on the tenant's collection
+func (v *TenantView) UpdateMany(coll string, filter interface{}, data interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	if err := v.update(data); err != nil {
+		return nil, err
+	}
+	return v.m.UpdateMany(v.db, v.Collection(coll), v.filter(filter), data, opts...)
+}
+
+// ReplaceOne is Mongo.ReplaceOne() on the tenant's collection
+func (v *TenantView) ReplaceOne(coll string, filter interface{}, replacement interface{}, opts ...*options.ReplaceOptions) (*mongo.UpdateResult, error) {
+	replacement, err := v.document(replacement)
+	if err != nil {
+		return nil, err
+	}
+	return v.m.ReplaceOne(v.db, v.Collection(coll), v.filter(filter), replacement, opts...)
+}
+
+// DeleteOne is Mongo.DeleteOne() on the tenant's collection
+func (v *TenantView) DeleteOne(coll string, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return v.m.DeleteOne(v.db, v.Collection(coll), v.filter(filter), opts...)
+}
+
+// DeleteMany is Mongo.DeleteMany() on the tenant's collection
+func (v *TenantView) DeleteMany(coll string, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return v.m.DeleteMany(v.db, v.Collection(coll), v.filter(filter), opts...)
+}
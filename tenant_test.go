@@ -0,0 +1,116 @@
+package mongoadapter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestTenancy_View(t *testing.T) {
+	m := &Mongo{registry: newSettingsRegistry()}
+	tenancy, err := m.Tenancy(TenantOptions{Strategy: TenantDatabase, Database: "app"})
+	assert.Nil(t, err)
+	_, err = tenancy.View(context.Background())
+	assert.Equal(t, ErrNoTenant, err)
+	_, err = tenancy.View(WithTenant(context.Background(), "../admin"))
+	assert.Error(t, err)
+	view, err := tenancy.View(WithTenant(context.Background(), "acme"))
+	assert.Nil(t, err)
+	assert.Equal(t, "app_acme", view.Database())
+	assert.Equal(t, "users", view.Collection("users"))
+
+	_, err = m.Tenancy(TenantOptions{Strategy: TenantField})
+	assert.Error(t, err, "the shared database is required")
+	tenancy, _ = m.Tenancy(TenantOptions{Strategy: TenantCollectionPrefix, Database: "app"})
+	view, _ = tenancy.View(WithTenant(context.Background(), "acme"))
+	assert.Equal(t, "app", view.Database())
+	assert.Equal(t, "acme_users", view.Collection("users"))
+	_, err = tenancy.View(WithTenant(context.Background(), "acme_x"))
+	assert.Error(t, err, `"acme_x" with "orders" would reach "acme" with "x_orders"`)
+
+	tenancy, _ = m.Tenancy(TenantOptions{Strategy: TenantDatabase})
+	for _, tenant := range []string{"admin", "local", "config", "Admin"} {
+		_, err = tenancy.View(WithTenant(context.Background(), tenant))
+		assert.Error(t, err, tenant)
+	}
+}
+
+func TestTenantView_guards(t *testing.T) {
+	m := &Mongo{registry: newSettingsRegistry()}
+	tenancy, _ := m.Tenancy(TenantOptions{Strategy: TenantField, Database: "app"})
+	view, _ := tenancy.View(WithTenant(context.Background(), "acme"))
+
+	doc, err := view.document(bson.M{"name": "sara"})
+	assert.Nil(t, err)
+	assert.Equal(t, bson.D{{Key: "name", Value: "sara"}, {Key: "tenantId", Value: "acme"}}, doc)
+	_, err = view.document(bson.M{"name": "sara", "tenantId": "other"})
+	assert.Equal(t, ErrCrossTenant, err)
+	assert.Equal(t, ErrCrossTenant, view.update(bson.M{"$set": bson.M{"tenantId": "other"}}))
+	assert.Equal(t, ErrCrossTenant, view.update(bson.M{"$rename": bson.M{"other": "tenantId"}}))
+	assert.Equal(t, ErrCrossTenant, view.update(bson.M{"$set": bson.M{"tenantId.x": "other"}}))
+	assert.Equal(t, ErrCrossTenant, view.update(bson.M{"$unset": bson.M{"tenantId": ""}}))
+	assert.Nil(t, view.update(bson.M{"$set": bson.M{"name": "sara"}}))
+	assert.Nil(t, view.update(bson.M{"$set": bson.M{"tenantIdentifier": "x"}}))
+
+	stages, err := view.pipeline(mongo.Pipeline{{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$city"}}}}})
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{"$match": bson.M{"tenantId": "acme"}}, stages[0])
+	_, err = view.pipeline(bson.A{bson.M{"$lookup": bson.M{"from": "orders"}}})
+	assert.Equal(t, ErrCrossTenant, err)
+	_, err = view.pipeline(bson.A{bson.M{"$facet": bson.M{"a": bson.A{bson.M{"$unionWith": "orders"}}}}})
+	assert.Equal(t, ErrCrossTenant, err)
+	stages, err = view.pipeline(bson.A{bson.M{"$geoNear": bson.M{"near": bson.A{0, 0}, "distanceField": "distance"}}})
+	assert.Nil(t, err)
+	assert.Len(t, stages, 1, "$geoNear must stay first")
+	query, _ := lookup(stages[0].(bson.D)[0].Value.(bson.D), "query")
+	assert.Equal(t, bson.M{"tenantId": "acme"}, query)
+	stages, err = view.pipeline(bson.A{bson.M{"$geoNear": bson.M{"near": bson.A{0, 0}, "distanceField": "distance", "query": bson.M{"open": true}}}})
+	assert.Nil(t, err)
+	query, _ = lookup(stages[0].(bson.D)[0].Value.(bson.D), "query")
+	assert.Equal(t, bson.M{"$and": bson.A{bson.D{{Key: "open", Value: true}}, bson.M{"tenantId": "acme"}}}, query)
+	_, err = view.pipeline(bson.A{bson.M{"$collStats": bson.M{"count": bson.M{}}}})
+	assert.NotNil(t, err, "first-only stages can't be restricted to the tenant")
+	_, err = view.pipeline(bson.A{bson.M{"$indexStats": bson.M{}}})
+	assert.NotNil(t, err)
+
+	tenancy, _ = m.Tenancy(TenantOptions{Strategy: TenantDatabase})
+	view, _ = tenancy.View(WithTenant(context.Background(), "acme"))
+	_, err = view.pipeline(bson.A{bson.M{"$lookup": bson.M{"from": "orders"}}})
+	assert.Nil(t, err)
+	_, err = view.pipeline(bson.A{bson.M{"$merge": bson.M{"into": bson.M{"db": "other", "coll": "x"}}}})
+	assert.Equal(t, ErrCrossTenant, err)
+}
+
+func TestTenantView_TenantField(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	var coll = "tenantUsers"
+	_ = m.conn.Database(mongoDatabase).Collection(coll).Drop(context.Background())
+	tenancy, err := m.Tenancy(TenantOptions{Strategy: TenantField, Database: mongoDatabase})
+	assert.Nil(t, err)
+	acme, _ := tenancy.View(WithTenant(context.Background(), "acme"))
+	globex, _ := tenancy.View(WithTenant(context.Background(), "globex"))
+
+	_, err = acme.InsertOne(coll, DummyUser{Name: "sara", Email: "sara@acme.com"})
+	assert.Nil(t, err)
+	_, err = globex.InsertMany(coll, []interface{}{DummyUser{Name: "sara", Email: "sara@globex.com"}, DummyUser{Name: "john"}})
+	assert.Nil(t, err)
+
+	var user DummyUser
+	assert.Nil(t, acme.FindOne(coll, bson.M{"name": "sara"}).Decode(&user))
+	assert.Equal(t, "sara@acme.com", user.Email)
+	n, err := globex.Count(coll, bson.M{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), n)
+	n, err = acme.SearchCount(coll, map[string][]string{"tenantId": {"globex", "eq"}})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), n, "the tenant filter can't be overridden")
+
+	res, err := acme.DeleteMany(coll, bson.M{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), res.DeletedCount)
+	n, _ = globex.Count(coll, bson.M{})
+	assert.Equal(t, int64(2), n)
+}
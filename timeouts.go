@@ -0,0 +1,78 @@
+package mongoadapter
+
+import "time"
+
+// defaultTimeout is used for the connection, read and write timeouts
+// left to 0 in the configuration
+const defaultTimeout = 5 * time.Second
+
+// operationTimeouts overrides the read and write timeouts for some kinds
+// of operations, 0 falls back to the read or write timeout
+type operationTimeouts struct {
+	count     time.Duration
+	aggregate time.Duration
+	bulk      time.Duration
+}
+
+// legacyDuration reads a timeout below a millisecond as a number of
+// seconds. MongoConfig timeouts used to be seconds, e.g. ReadTimeout: 5,
+// and no server round-trip fits in less than a millisecond anyway.
+func legacyDuration(d time.Duration) time.Duration {
+	if d > 0 && d < time.Millisecond {
+		return d * time.Second
+	}
+	return d
+}
+
+// countTimeout bounds Count(), EstimatedCount() and SearchCount()
+func (m *Mongo) countTimeout() time.Duration {
+	if m.opTimeouts.count != 0 {
+		return m.opTimeouts.count
+	}
+	return m.readTimeout
+}
+
+// aggregateTimeout bounds Aggregate() and Search()
+func (m *Mongo) aggregateTimeout() time.Duration {
+	if m.opTimeouts.aggregate != 0 {
+		return m.opTimeouts.aggregate
+	}
+	return m.readTimeout
+}
+
+// bulkTimeout bounds InsertMany(), UpdateMany() and DeleteMany()
+func (m *Mongo) bulkTimeout() time.Duration {
+	if m.opTimeouts.bulk != 0 {
+		return m.opTimeouts.bulk
+	}
+	return m.writeTimeout
+}
+
+// Timeouts sets the read and write timeouts of the view, e.g.
+// Timeouts(30*time.Second, 0), 0 keeps the current one
+func Timeouts(read, write time.Duration) Option {
+	return func(m *Mongo) {
+		if read != 0 {
+			m.readTimeout = legacyDuration(read)
+		}
+		if write != 0 {
+			m.writeTimeout = legacyDuration(write)
+		}
+	}
+}
+
+// OperationTimeouts sets the timeouts of the view's counts, aggregations
+// and bulk writes, 0 keeps the current one
+func OperationTimeouts(count, aggregate, bulk time.Duration) Option {
+	return func(m *Mongo) {
+		if count != 0 {
+			m.opTimeouts.count = legacyDuration(count)
+		}
+		if aggregate != 0 {
+			m.opTimeouts.aggregate = legacyDuration(aggregate)
+		}
+		if bulk != 0 {
+			m.opTimeouts.bulk = legacyDuration(bulk)
+		}
+	}
+}
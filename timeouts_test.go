@@ -0,0 +1,28 @@
+package mongoadapter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLegacyDuration(t *testing.T) {
+	assert.Equal(t, 5*time.Second, legacyDuration(5), "legacy seconds")
+	assert.Equal(t, 5*time.Second, legacyDuration(5*time.Second))
+	assert.Equal(t, 250*time.Millisecond, legacyDuration(250*time.Millisecond))
+	assert.Equal(t, time.Duration(0), legacyDuration(0))
+}
+
+func TestOperationTimeouts(t *testing.T) {
+	m := &Mongo{registry: newSettingsRegistry(), readTimeout: 5 * time.Second, writeTimeout: 10 * time.Second}
+	assert.Equal(t, 5*time.Second, m.countTimeout(), "falls back to the read timeout")
+	assert.Equal(t, 5*time.Second, m.aggregateTimeout(), "falls back to the read timeout")
+	assert.Equal(t, 10*time.Second, m.bulkTimeout(), "falls back to the write timeout")
+
+	var view = m.With(OperationTimeouts(time.Second, time.Minute, 0), Timeouts(0, 20))
+	assert.Equal(t, time.Second, view.countTimeout())
+	assert.Equal(t, time.Minute, view.aggregateTimeout())
+	assert.Equal(t, 20*time.Second, view.bulkTimeout(), "legacy seconds")
+	assert.Equal(t, 5*time.Second, m.countTimeout(), "the view must not change m")
+}
@@ -0,0 +1,210 @@
+package mongoadapter
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TimestampOptions configures the field names maintained by EnableTimestamps().
+// Empty names fall back to "createdAt", "updatedAt" and "version", a name
+// of "-" disables the field.
+type TimestampOptions struct {
+	CreatedAtField string
+	UpdatedAtField string
+	VersionField   string
+}
+
+func (o TimestampOptions) withDefaults() TimestampOptions {
+	if o.CreatedAtField == "" {
+		o.CreatedAtField = "createdAt"
+	}
+	if o.UpdatedAtField == "" {
+		o.UpdatedAtField = "updatedAt"
+	}
+	if o.VersionField == "" {
+		o.VersionField = "version"
+	}
+	return o
+}
+
+// EnableTimestamps makes the adapter maintain creation/update timestamps and
+// a version counter on the given collection:
+// InsertOne and InsertMany set createdAt (unless already set), updatedAt and version 1,
+// UpdateOne and UpdateMany set updatedAt with $currentDate and increment version,
+// upserts also get createdAt through $setOnInsert, ReplaceOne sets updatedAt.
+// Update pipelines get the same fields from a $set stage appended to them.
+func (m *Mongo) EnableTimestamps(db, coll string, opts TimestampOptions) {
+	opts = opts.withDefaults()
+	m.updateSettings(db, coll, func(s *collectionSettings) {
+		s.timestamps = &opts
+	})
+}
+
+// DisableTimestamps stops maintaining timestamps on the given collection
+func (m *Mongo) DisableTimestamps(db, coll string) {
+	m.updateSettings(db, coll, func(s *collectionSettings) {
+		s.timestamps = nil
+	})
+}
+
+// stampInsert adds the timestamps and initial version to a new document
+func (o *TimestampOptions) stampInsert(doc interface{}, now time.Time) (interface{}, error) {
+	d, err := toDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	if o.CreatedAtField != "-" {
+		if v, ok := lookup(d, o.CreatedAtField); !ok || isZeroTime(v) {
+			d = set(d, o.CreatedAtField, now)
+		}
+	}
+	if o.UpdatedAtField != "-" {
+		d = set(d, o.UpdatedAtField, now)
+	}
+	if o.VersionField != "-" {
+		// structs always carry their version field, a zero one is unset
+		if v, ok := lookup(d, o.VersionField); !ok || isZeroNumber(v) {
+			d = set(d, o.VersionField, int64(1))
+		}
+	}
+	return d, nil
+}
+
+// stampUpdate adds $currentDate for updatedAt, $inc for version and, on
+// upserts, $setOnInsert for createdAt. Fields already touched by the update
+// are left alone so the server does not reject conflicting operators.
+func (o *TimestampOptions) stampUpdate(update interface{}, upsert bool, now time.Time) (interface{}, error) {
+	if isUpdatePipeline(update) {
+		return o.stampPipeline(update, upsert, now)
+	}
+	d, err := toDocument(update)
+	if err != nil {
+		return nil, err
+	}
+	if o.UpdatedAtField != "-" && !updatesField(d, o.UpdatedAtField) {
+		if d, err = setOperatorField(d, "$currentDate", o.UpdatedAtField, true); err != nil {
+			return nil, err
+		}
+	}
+	if o.VersionField != "-" && !updatesField(d, o.VersionField) {
+		if d, err = setOperatorField(d, "$inc", o.VersionField, int64(1)); err != nil {
+			return nil, err
+		}
+	}
+	if upsert && o.CreatedAtField != "-" && !updatesField(d, o.CreatedAtField) {
+		if d, err = setOperatorField(d, "$setOnInsert", o.CreatedAtField, now); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// stampPipeline appends to an update pipeline a $set stage for updatedAt,
+// version and, on upserts, createdAt. Fields already set by a stage of the
+// pipeline are left alone.
+func (o *TimestampOptions) stampPipeline(update interface{}, upsert bool, now time.Time) (interface{}, error) {
+	stages, err := parsePipeline(update)
+	if err != nil {
+		return nil, err
+	}
+	var fields = bson.D{}
+	if o.UpdatedAtField != "-" && !pipelineSets(stages, o.UpdatedAtField) {
+		fields = append(fields, bson.E{Key: o.UpdatedAtField, Value: "$$NOW"})
+	}
+	if o.VersionField != "-" && !pipelineSets(stages, o.VersionField) {
+		var current = bson.D{{Key: "$ifNull", Value: bson.A{"$" + o.VersionField, int64(0)}}}
+		fields = append(fields, bson.E{Key: o.VersionField, Value: bson.D{{Key: "$add", Value: bson.A{current, int64(1)}}}})
+	}
+	if upsert && o.CreatedAtField != "-" && !pipelineSets(stages, o.CreatedAtField) {
+		fields = append(fields, bson.E{Key: o.CreatedAtField, Value: bson.D{{Key: "$ifNull", Value: bson.A{"$" + o.CreatedAtField, now}}}})
+	}
+	var pipeline = make(bson.A, 0, len(stages)+1)
+	for _, stage := range stages {
+		pipeline = append(pipeline, stage)
+	}
+	if len(fields) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$set", Value: fields}})
+	}
+	return pipeline, nil
+}
+
+// pipelineSets reports whether a $set or $addFields stage sets field
+func pipelineSets(stages []bson.D, field string) bool {
+	for _, stage := range stages {
+		for _, e := range stage {
+			if e.Key != "$set" && e.Key != "$addFields" {
+				continue
+			}
+			fields, err := toDocument(e.Value)
+			if err != nil {
+				continue
+			}
+			if _, ok := lookup(fields, field); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stampReplacement sets updatedAt on a replacement document. A replacement
+// overwrites the whole document, so it must carry createdAt and version
+// itself (documents loaded with FindOne do), createdAt is only filled in
+// when missing, e.g. on upserts.
+func (o *TimestampOptions) stampReplacement(doc interface{}, now time.Time) (interface{}, error) {
+	d, err := toDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	if o.CreatedAtField != "-" {
+		if v, ok := lookup(d, o.CreatedAtField); !ok || isZeroTime(v) {
+			d = set(d, o.CreatedAtField, now)
+		}
+	}
+	if o.UpdatedAtField != "-" {
+		d = set(d, o.UpdatedAtField, now)
+	}
+	return d, nil
+}
+
+func isZeroTime(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return true
+	case time.Time:
+		return t.IsZero()
+	case primitive.DateTime:
+		// a zero time.Time is stored as its number of milliseconds since epoch
+		return int64(t) == time.Time{}.Unix()*1000
+	}
+	return false
+}
+
+// isZeroNumber reports whether v is a numeric zero, or nil
+func isZeroNumber(v interface{}) bool {
+	switch n := v.(type) {
+	case nil:
+		return true
+	case int32:
+		return n == 0
+	case int64:
+		return n == 0
+	case float64:
+		return n == 0
+	}
+	return false
+}
+
+// isUpsert reports whether any of the update options asks for an upsert
+func isUpsert(opts []*options.UpdateOptions) bool {
+	var upsert bool
+	for _, v := range opts {
+		if v != nil && v.Upsert != nil {
+			upsert = *v.Upsert
+		}
+	}
+	return upsert
+}
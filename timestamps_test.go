@@ -0,0 +1,114 @@
+package mongoadapter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type TimestampedUser struct {
+	Name      string    `bson:"name"`
+	CreatedAt time.Time `bson:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+	Version   int64     `bson:"version"`
+}
+
+func TestMongo_EnableTimestamps(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	var coll = "timestamped"
+	_ = m.conn.Database(mongoDatabase).Collection(coll).Drop(context.Background())
+	m.EnableTimestamps(mongoDatabase, coll, TimestampOptions{})
+	defer m.DisableTimestamps(mongoDatabase, coll)
+
+	var before = time.Now().Add(-time.Second)
+	_, err := m.InsertOne(mongoDatabase, coll, TimestampedUser{Name: "stamped"})
+	assert.Nil(t, err)
+	var user TimestampedUser
+	assert.Nil(t, m.FindOne(mongoDatabase, coll, bson.M{"name": "stamped"}).Decode(&user))
+	assert.True(t, user.CreatedAt.After(before))
+	assert.Equal(t, user.CreatedAt, user.UpdatedAt)
+	assert.Equal(t, int64(1), user.Version)
+
+	time.Sleep(10 * time.Millisecond)
+	_, err = m.UpdateOne(mongoDatabase, coll, bson.M{"name": "stamped"}, bson.M{"$set": bson.M{"name": "restamped"}})
+	assert.Nil(t, err)
+	var updated TimestampedUser
+	assert.Nil(t, m.FindOne(mongoDatabase, coll, bson.M{"name": "restamped"}).Decode(&updated))
+	assert.Equal(t, user.CreatedAt, updated.CreatedAt)
+	assert.True(t, updated.UpdatedAt.After(user.UpdatedAt))
+	assert.Equal(t, int64(2), updated.Version)
+
+	_, err = m.UpdateOne(mongoDatabase, coll, bson.M{"name": "upserted"}, bson.M{"$set": bson.M{"name": "upserted"}}, options.Update().SetUpsert(true))
+	assert.Nil(t, err)
+	var upserted TimestampedUser
+	assert.Nil(t, m.FindOne(mongoDatabase, coll, bson.M{"name": "upserted"}).Decode(&upserted))
+	assert.False(t, upserted.CreatedAt.IsZero())
+	assert.Equal(t, int64(1), upserted.Version)
+}
+
+func TestMongo_EnableTimestamps_customFields(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	var coll = "customTimestamped"
+	_ = m.conn.Database(mongoDatabase).Collection(coll).Drop(context.Background())
+	m.EnableTimestamps(mongoDatabase, coll, TimestampOptions{CreatedAtField: "created", VersionField: "-"})
+	defer m.DisableTimestamps(mongoDatabase, coll)
+
+	_, err := m.InsertMany(mongoDatabase, coll, []interface{}{bson.M{"name": "a"}, bson.M{"name": "b"}})
+	assert.Nil(t, err)
+	var doc bson.M
+	assert.Nil(t, m.FindOne(mongoDatabase, coll, bson.M{"name": "a"}).Decode(&doc))
+	assert.Contains(t, doc, "created")
+	assert.Contains(t, doc, "updatedAt")
+	assert.NotContains(t, doc, "version")
+}
+
+func TestTimestampOptions_stampUpdate_mustKeepUserFields(t *testing.T) {
+	var opts = TimestampOptions{}.withDefaults()
+	update, err := opts.stampUpdate(bson.M{"$set": bson.M{"updatedAt": "manual"}}, false, time.Now())
+	assert.Nil(t, err)
+	doc := update.(bson.D)
+	_, hasCurrentDate := lookup(doc, "$currentDate")
+	assert.False(t, hasCurrentDate)
+	inc, _ := lookup(doc, "$inc")
+	assert.Equal(t, bson.D{{Key: "version", Value: int64(1)}}, inc)
+}
+
+func TestTimestampOptions_stampInsert_mustSetZeroVersion(t *testing.T) {
+	var opts = TimestampOptions{}.withDefaults()
+	doc, err := opts.stampInsert(TimestampedUser{Name: "stamped"}, time.Now())
+	assert.Nil(t, err)
+	version, _ := lookup(doc.(bson.D), "version")
+	assert.Equal(t, int64(1), version)
+
+	doc, err = opts.stampInsert(bson.M{"name": "imported", "version": int64(7)}, time.Now())
+	assert.Nil(t, err)
+	version, _ = lookup(doc.(bson.D), "version")
+	assert.Equal(t, int64(7), version, "an explicit version is kept")
+}
+
+func TestTimestampOptions_stampUpdate_pipeline(t *testing.T) {
+	var opts = TimestampOptions{}.withDefaults()
+	var pipeline = mongo.Pipeline{{{Key: "$set", Value: bson.D{{Key: "name", Value: bson.D{{Key: "$toUpper", Value: "$name"}}}}}}}
+	update, err := opts.stampUpdate(pipeline, true, time.Now())
+	assert.Nil(t, err, "pipelines are valid updates")
+	stages := update.(bson.A)
+	assert.Len(t, stages, 2)
+	stamp, _ := lookup(stages[1].(bson.D), "$set")
+	updatedAt, _ := lookup(stamp.(bson.D), "updatedAt")
+	assert.Equal(t, "$$NOW", updatedAt)
+	_, hasVersion := lookup(stamp.(bson.D), "version")
+	assert.True(t, hasVersion)
+	_, hasCreatedAt := lookup(stamp.(bson.D), "createdAt")
+	assert.True(t, hasCreatedAt, "upserts get createdAt")
+
+	update, err = opts.stampUpdate(bson.A{bson.M{"$addFields": bson.M{"updatedAt": "manual"}}}, false, time.Now())
+	assert.Nil(t, err)
+	stamp, _ = lookup(update.(bson.A)[1].(bson.D), "$set")
+	_, hasUpdatedAt := lookup(stamp.(bson.D), "updatedAt")
+	assert.False(t, hasUpdatedAt, "fields set by the pipeline are kept")
+}
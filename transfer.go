@@ -0,0 +1,571 @@
+package mongoadapter
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Export and import formats
+const (
+	// FormatJSON is a JSON array of Extended JSON documents
+	FormatJSON = "json"
+	// FormatNDJSON is one Extended JSON document per line
+	FormatNDJSON = "ndjson"
+	// FormatCSV is a header line followed by one line per document
+	FormatCSV = "csv"
+)
+
+// CSV column types for ImportOptions.Types
+const (
+	CSVString   = "string"
+	CSVInt      = "int"
+	CSVLong     = "long"
+	CSVDouble   = "double"
+	CSVBool     = "bool"
+	CSVDate     = "date"
+	CSVObjectID = "objectId"
+	CSVJSON     = "json"
+)
+
+// ExportOptions configures Export()
+type ExportOptions struct {
+	// Format is FormatJSON (default), FormatNDJSON or FormatCSV
+	Format string
+	// Canonical writes canonical Extended JSON instead of relaxed
+	Canonical bool
+	// Filter selects the exported documents, Search selects them with the
+	// filters of Search() instead
+	Filter interface{}
+	Search map[string][]string
+	// Projection, Sort and Limit are passed to the query
+	Projection interface{}
+	Sort       interface{}
+	Limit      int64
+	// Fields are the CSV columns, dotted paths reach into embedded
+	// documents. They default to the top-level fields of the first document.
+	Fields []string
+	// Progress is called with the number of documents written so far
+	Progress func(n int64)
+}
+
+// ImportOptions configures Import()
+type ImportOptions struct {
+	// Format is FormatJSON (default, which also reads NDJSON), FormatNDJSON or FormatCSV
+	Format string
+	// BatchSize is the number of documents per InsertMany, defaults to 1000
+	BatchSize int
+	// UpsertKey replaces the document having the same values for these
+	// fields instead of inserting, one ReplaceOne per document
+	UpsertKey []string
+	// Fields are the CSV columns, they default to the header line.
+	// Dotted names build embedded documents.
+	Fields []string
+	// Types are the CSV column types by field, see the CSV* constants.
+	// Columns default to strings and empty cells are skipped.
+	Types map[string]string
+	// Progress is called with the number of documents imported so far
+	Progress func(n int64)
+}
+
+// Export writes the documents of the collection to w and returns how many
+// were written. Soft-deleted documents are skipped unless the view includes
+// them. The export is not bound by the read timeout, give the view a
+// context with a deadline to limit it.
+func (m *Mongo) Export(db, coll string, w io.Writer, opts ExportOptions) (int64, error) {
+	var filter = opts.Filter
+	if opts.Search != nil {
+		filter = searchFilter(opts.Search)
+	}
+	if filter == nil {
+		filter = bson.M{}
+	}
+	var findOptions = options.Find()
+	if opts.Projection != nil {
+		findOptions.SetProjection(opts.Projection)
+	}
+	if opts.Sort != nil {
+		findOptions.SetSort(opts.Sort)
+	}
+	if opts.Limit > 0 {
+		findOptions.SetLimit(opts.Limit)
+	}
+	var ctx = m.context()
+	cur, err := m.collection(db, coll).Find(ctx, m.readFilter(db, coll, filter), findOptions)
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+
+	var writer documentWriter
+	switch opts.Format {
+	case "", FormatJSON:
+		writer = &jsonWriter{w: bufio.NewWriter(w), canonical: opts.Canonical, array: true}
+	case FormatNDJSON:
+		writer = &jsonWriter{w: bufio.NewWriter(w), canonical: opts.Canonical}
+	case FormatCSV:
+		writer = &csvWriter{w: csv.NewWriter(w), fields: opts.Fields}
+	default:
+		return 0, errors.New("unknown export format " + strconv.Quote(opts.Format))
+	}
+
+	var n int64
+	for cur.Next(ctx) {
+		if err := writer.write(cur.Current); err != nil {
+			return n, err
+		}
+		n++
+		if opts.Progress != nil && n%1000 == 0 {
+			opts.Progress(n)
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return n, err
+	}
+	if opts.Progress != nil && n%1000 != 0 {
+		opts.Progress(n)
+	}
+	return n, writer.close()
+}
+
+// Import reads documents from r into the collection and returns how many
+// were imported. Documents go through InsertMany(), or ReplaceOne() with
+// UpsertKey, so timestamps, validation and audit apply as usual.
+func (m *Mongo) Import(db, coll string, r io.Reader, opts ImportOptions) (int64, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	var reader documentReader
+	switch opts.Format {
+	case "", FormatJSON, FormatNDJSON:
+		reader = &jsonReader{r: bufio.NewReader(r)}
+	case FormatCSV:
+		reader = &csvReader{r: csv.NewReader(r), fields: opts.Fields, types: opts.Types}
+	default:
+		return 0, errors.New("unknown import format " + strconv.Quote(opts.Format))
+	}
+
+	var n int64
+	var batch = make([]interface{}, 0, opts.BatchSize)
+	var flush = func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if len(opts.UpsertKey) > 0 {
+			for _, doc := range batch {
+				if err := m.upsertByKey(db, coll, doc.(bson.D), opts.UpsertKey); err != nil {
+					return err
+				}
+			}
+		} else if _, err := m.InsertMany(db, coll, batch); err != nil {
+			return err
+		}
+		n += int64(len(batch))
+		batch = batch[:0]
+		if opts.Progress != nil {
+			opts.Progress(n)
+		}
+		return nil
+	}
+	for {
+		doc, err := reader.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, fmt.Errorf("failed to read document %d, got error: %v", n+int64(len(batch))+1, err)
+		}
+		batch = append(batch, doc)
+		if len(batch) == opts.BatchSize {
+			if err := flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, flush()
+}
+
+func (m *Mongo) upsertByKey(db, coll string, doc bson.D, key []string) error {
+	var filter = bson.D{}
+	for _, field := range key {
+		value, ok := lookupPath(doc, field)
+		if !ok {
+			return errors.New("document has no value for the upsert key " + strconv.Quote(field))
+		}
+		filter = append(filter, bson.E{Key: field, Value: value})
+	}
+	_, err := m.ReplaceOne(db, coll, filter, doc, options.Replace().SetUpsert(true))
+	return err
+}
+
+// lookupPath returns the value at a dotted path of doc
+func lookupPath(doc bson.D, path string) (interface{}, bool) {
+	var parts = strings.Split(path, ".")
+	value, ok := lookup(doc, parts[0])
+	for _, part := range parts[1:] {
+		if !ok {
+			return nil, false
+		}
+		embedded, isDoc := value.(bson.D)
+		if !isDoc {
+			return nil, false
+		}
+		value, ok = lookup(embedded, part)
+	}
+	return value, ok
+}
+
+// setPath sets the value at a dotted path of doc, creating the embedded documents
+func setPath(doc bson.D, path string, value interface{}) bson.D {
+	var i = strings.Index(path, ".")
+	if i < 0 {
+		return set(doc, path, value)
+	}
+	var embedded bson.D
+	if current, ok := lookup(doc, path[:i]); ok {
+		embedded, _ = current.(bson.D)
+	}
+	return set(doc, path[:i], setPath(embedded, path[i+1:], value))
+}
+
+type documentWriter interface {
+	write(doc bson.Raw) error
+	close() error
+}
+
+type jsonWriter struct {
+	w         *bufio.Writer
+	canonical bool
+	array     bool
+	started   bool
+}
+
+func (jw *jsonWriter) write(doc bson.Raw) error {
+	data, err := bson.MarshalExtJSON(doc, jw.canonical, false)
+	if err != nil {
+		return err
+	}
+	switch {
+	case jw.array && !jw.started:
+		err = jw.w.WriteByte('[')
+	case jw.array:
+		err = jw.w.WriteByte(',')
+	}
+	jw.started = true
+	if err != nil {
+		return err
+	}
+	if _, err := jw.w.Write(data); err != nil {
+		return err
+	}
+	if !jw.array {
+		return jw.w.WriteByte('\n')
+	}
+	return nil
+}
+
+func (jw *jsonWriter) close() error {
+	if jw.array {
+		var end = "]\n"
+		if !jw.started {
+			end = "[]\n"
+		}
+		if _, err := jw.w.WriteString(end); err != nil {
+			return err
+		}
+	}
+	return jw.w.Flush()
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	fields []string
+	header bool
+}
+
+func (cw *csvWriter) write(doc bson.Raw) error {
+	if cw.fields == nil {
+		elements, err := doc.Elements()
+		if err != nil {
+			return err
+		}
+		for _, e := range elements {
+			cw.fields = append(cw.fields, e.Key())
+		}
+	}
+	if !cw.header {
+		cw.header = true
+		if err := cw.w.Write(cw.fields); err != nil {
+			return err
+		}
+	}
+	var record = make([]string, len(cw.fields))
+	for i, field := range cw.fields {
+		value, err := doc.LookupErr(strings.Split(field, ".")...)
+		if err == nil {
+			record[i] = csvValue(value)
+		}
+	}
+	return cw.w.Write(record)
+}
+
+func (cw *csvWriter) close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// csvValue formats a value for a CSV cell, in a way parseCSVValue reads back
+func csvValue(v bson.RawValue) string {
+	switch v.Type {
+	case bsontype.String:
+		return v.StringValue()
+	case bsontype.Int32:
+		return strconv.Itoa(int(v.Int32()))
+	case bsontype.Int64:
+		return strconv.FormatInt(v.Int64(), 10)
+	case bsontype.Double:
+		return strconv.FormatFloat(v.Double(), 'g', -1, 64)
+	case bsontype.Boolean:
+		return strconv.FormatBool(v.Boolean())
+	case bsontype.ObjectID:
+		return v.ObjectID().Hex()
+	case bsontype.DateTime:
+		return time.Unix(0, v.DateTime()*int64(time.Millisecond)).UTC().Format(time.RFC3339Nano)
+	case bsontype.Null, bsontype.Undefined:
+		return ""
+	case bsontype.EmbeddedDocument:
+		data, err := bson.MarshalExtJSON(v.Document(), false, false)
+		if err == nil {
+			return string(data)
+		}
+	case bsontype.Array:
+		// arrays can't be marshaled as top-level Extended JSON
+		data, err := bson.MarshalExtJSON(bson.D{{Key: "a", Value: v}}, false, false)
+		if err == nil {
+			var wrapped struct {
+				A json.RawMessage `json:"a"`
+			}
+			if json.Unmarshal(data, &wrapped) == nil {
+				return string(wrapped.A)
+			}
+		}
+	}
+	return v.String()
+}
+
+type documentReader interface {
+	read() (bson.D, error)
+}
+
+// jsonReader reads a JSON array of documents or a stream of documents,
+// which covers NDJSON
+type jsonReader struct {
+	r     *bufio.Reader
+	d     *json.Decoder
+	array bool
+}
+
+func (jr *jsonReader) read() (bson.D, error) {
+	if jr.d == nil {
+		first, err := firstNonSpace(jr.r)
+		if err != nil {
+			return nil, err
+		}
+		jr.d = json.NewDecoder(jr.r)
+		if first == '[' {
+			jr.array = true
+			if _, err := jr.d.Token(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if jr.array && !jr.d.More() {
+		return nil, io.EOF
+	}
+	var raw json.RawMessage
+	if err := jr.d.Decode(&raw); err != nil {
+		return nil, err
+	}
+	return decodeExtJSON(raw)
+}
+
+// firstNonSpace returns the first byte of r that is not a white space, without consuming it
+func firstNonSpace(r *bufio.Reader) (byte, error) {
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			return c, r.UnreadByte()
+		}
+	}
+}
+
+func decodeExtJSON(data []byte) (bson.D, error) {
+	var doc bson.D
+	err := bson.UnmarshalExtJSON(data, false, &doc)
+	return doc, err
+}
+
+type csvReader struct {
+	r      *csv.Reader
+	fields []string
+	types  map[string]string
+}
+
+func (cr *csvReader) read() (bson.D, error) {
+	if cr.fields == nil {
+		header, err := cr.r.Read()
+		if err != nil {
+			return nil, err
+		}
+		cr.fields = header
+	}
+	record, err := cr.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	var doc = bson.D{}
+	for i, cell := range record {
+		if i >= len(cr.fields) || cell == "" {
+			continue
+		}
+		value, err := parseCSVValue(cell, cr.types[cr.fields[i]])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %v: %v", cr.fields[i], err)
+		}
+		doc = setPath(doc, cr.fields[i], value)
+	}
+	return doc, nil
+}
+
+func parseCSVValue(cell, kind string) (interface{}, error) {
+	switch kind {
+	case "", CSVString:
+		return cell, nil
+	case CSVInt:
+		v, err := strconv.ParseInt(cell, 10, 32)
+		return int32(v), err
+	case CSVLong:
+		return strconv.ParseInt(cell, 10, 64)
+	case CSVDouble:
+		return strconv.ParseFloat(cell, 64)
+	case CSVBool:
+		return strconv.ParseBool(cell)
+	case CSVDate:
+		return time.Parse(time.RFC3339Nano, cell)
+	case CSVObjectID:
+		return primitive.ObjectIDFromHex(cell)
+	case CSVJSON:
+		// wrapped so that arrays and scalars can be read as well
+		var wrapped struct {
+			V interface{} `bson:"v"`
+		}
+		err := bson.UnmarshalExtJSON([]byte(`{"v":`+cell+`}`), false, &wrapped)
+		return wrapped.V, err
+	}
+	return nil, errors.New("unknown CSV type " + strconv.Quote(kind))
+}
+
+// RunTransferCommand runs the export and import subcommands on args:
+//
+//	export [-format json|ndjson|csv] [-canonical] [-filter <json>] [-projection <json>] [-fields a,b] <db> <coll>
+//	import [-format json|ndjson|csv] [-batch n] [-upsert-key a,b] [-types field:type,...] <db> <coll>
+//
+// Documents are written to out and read from in, progress is reported on progress.
+func RunTransferCommand(ctx context.Context, m *Mongo, args []string, in io.Reader, out, progress io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("no transfer command specified, expected export or import")
+	}
+	var flags = flag.NewFlagSet(args[0], flag.ContinueOnError)
+	flags.SetOutput(progress)
+	var format = flags.String("format", FormatJSON, "json, ndjson or csv")
+	var fields = flags.String("fields", "", "comma separated CSV columns")
+	var report = func(n int64) {
+		_, _ = fmt.Fprintf(progress, "%v documents\n", n)
+	}
+	switch args[0] {
+	case "export":
+		var canonical = flags.Bool("canonical", false, "write canonical Extended JSON")
+		var filter = flags.String("filter", "", "Extended JSON filter")
+		var projection = flags.String("projection", "", "Extended JSON projection")
+		var limit = flags.Int64("limit", 0, "maximum number of documents")
+		db, coll, err := parseTransferArgs(flags, args[1:])
+		if err != nil {
+			return err
+		}
+		var opts = ExportOptions{Format: *format, Canonical: *canonical, Limit: *limit, Fields: splitList(*fields), Progress: report}
+		if opts.Filter, err = parseJSONArg("filter", *filter); err != nil {
+			return err
+		}
+		if opts.Projection, err = parseJSONArg("projection", *projection); err != nil {
+			return err
+		}
+		_, err = m.With(Context(ctx)).Export(db, coll, out, opts)
+		return err
+	case "import":
+		var batch = flags.Int("batch", 1000, "documents per insert")
+		var upsertKey = flags.String("upsert-key", "", "comma separated fields identifying the documents to replace")
+		var types = flags.String("types", "", "comma separated CSV column types, e.g. age:int,born:date")
+		db, coll, err := parseTransferArgs(flags, args[1:])
+		if err != nil {
+			return err
+		}
+		var opts = ImportOptions{Format: *format, BatchSize: *batch, UpsertKey: splitList(*upsertKey), Fields: splitList(*fields), Progress: report}
+		if *types != "" {
+			opts.Types = map[string]string{}
+			for _, v := range splitList(*types) {
+				var i = strings.LastIndex(v, ":")
+				if i < 0 {
+					return errors.New("invalid column type " + strconv.Quote(v) + ", expected field:type")
+				}
+				opts.Types[v[:i]] = v[i+1:]
+			}
+		}
+		_, err = m.With(Context(ctx)).Import(db, coll, in, opts)
+		return err
+	}
+	return errors.New("unknown transfer command " + strconv.Quote(args[0]))
+}
+
+func parseTransferArgs(flags *flag.FlagSet, args []string) (string, string, error) {
+	if err := flags.Parse(args); err != nil {
+		return "", "", err
+	}
+	if flags.NArg() != 2 {
+		return "", "", errors.New(flags.Name() + " expects a database and a collection")
+	}
+	return flags.Arg(0), flags.Arg(1), nil
+}
+
+func parseJSONArg(name, value string) (interface{}, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var doc bson.D
+	if err := bson.UnmarshalExtJSON([]byte(value), false, &doc); err != nil {
+		return nil, errors.New("invalid " + name + ", got error: " + err.Error())
+	}
+	return doc, nil
+}
+
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
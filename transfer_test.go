@@ -0,0 +1,109 @@
+package mongoadapter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMongo_Export(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	var coll = "exported"
+	_ = m.conn.Database(mongoDatabase).Collection(coll).Drop(context.Background())
+	_ = m.conn.Database(mongoDatabase).Collection(coll + "Copy").Drop(context.Background())
+	_, err := insertDummyUser(mongoDatabase, coll, 25)
+	assert.Nil(t, err)
+
+	for _, format := range []string{FormatJSON, FormatNDJSON} {
+		var buf bytes.Buffer
+		n, err := m.Export(mongoDatabase, coll, &buf, ExportOptions{Format: format, Canonical: true})
+		assert.Nil(t, err)
+		assert.Equal(t, int64(25), n)
+
+		var progress []int64
+		n, err = m.Import(mongoDatabase, coll+"Copy", &buf, ImportOptions{
+			BatchSize: 10,
+			UpsertKey: []string{"_id"},
+			Progress:  func(n int64) { progress = append(progress, n) },
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, int64(25), n)
+		assert.Equal(t, []int64{10, 20, 25}, progress)
+	}
+	count, _ := m.Count(mongoDatabase, coll+"Copy", bson.M{})
+	assert.Equal(t, int64(25), count)
+}
+
+func TestMongo_Export_csv(t *testing.T) {
+	m, _ := NewMongo(mongoConfig)
+	var coll = "exportedCSV"
+	_ = m.conn.Database(mongoDatabase).Collection(coll).Drop(context.Background())
+	var input = "name,age,address.city\nsara,30,rome\njohn,40,\n"
+	n, err := m.Import(mongoDatabase, coll, strings.NewReader(input), ImportOptions{
+		Format: FormatCSV,
+		Types:  map[string]string{"age": CSVInt},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), n)
+
+	var out bytes.Buffer
+	_, err = m.Export(mongoDatabase, coll, &out, ExportOptions{
+		Format: FormatCSV,
+		Search: map[string][]string{"name": {"sara", "eq"}},
+		Fields: []string{"name", "age", "address.city"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "name,age,address.city\nsara,30,rome\n", out.String())
+}
+
+func TestJSONReader(t *testing.T) {
+	for _, input := range []string{
+		` [{"a": 1}, {"a": {"$numberLong": "2"}}] `,
+		"{\"a\": 1}\n{\"a\": {\"$numberLong\": \"2\"}}\n",
+	} {
+		reader := &jsonReader{r: bufio.NewReader(strings.NewReader(input))}
+		first, err := reader.read()
+		assert.Nil(t, err)
+		assert.Equal(t, bson.D{{Key: "a", Value: int32(1)}}, first)
+		second, err := reader.read()
+		assert.Nil(t, err)
+		assert.Equal(t, bson.D{{Key: "a", Value: int64(2)}}, second)
+		_, err = reader.read()
+		assert.Error(t, err)
+	}
+}
+
+func TestCSVValues(t *testing.T) {
+	var id = primitive.NewObjectID()
+	var born = time.Date(1990, 5, 1, 10, 0, 0, 0, time.UTC)
+	raw, _ := bson.Marshal(bson.D{
+		{Key: "id", Value: id},
+		{Key: "born", Value: born},
+		{Key: "tags", Value: bson.A{"a", "b"}},
+		{Key: "score", Value: 1.5},
+	})
+	var out bytes.Buffer
+	writer := &csvWriter{w: csv.NewWriter(&out)}
+	assert.Nil(t, writer.write(raw))
+	assert.Nil(t, writer.close())
+
+	reader := &csvReader{r: csv.NewReader(&out), types: map[string]string{
+		"id": CSVObjectID, "born": CSVDate, "tags": CSVJSON, "score": CSVDouble,
+	}}
+	doc, err := reader.read()
+	assert.Nil(t, err)
+	assert.Equal(t, bson.D{
+		{Key: "id", Value: id},
+		{Key: "born", Value: born},
+		{Key: "tags", Value: bson.A{"a", "b"}},
+		{Key: "score", Value: 1.5},
+	}, doc)
+}